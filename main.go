@@ -2,15 +2,304 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/cbartram/hearthhub/src"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"time"
 )
 
+// RUN_MODE selects how the binary is started:
+//   - "" or "api": lambda serving API Gateway proxy requests (default)
+//   - "scheduler": lambda invoked by an EventBridge rule which runs due server schedules
+//   - "pruner": lambda invoked by an EventBridge rule which applies backup retention policies
+//   - "reconciler": lambda invoked by an EventBridge rule which reconciles storage usage with the bucket
+//   - "eraser": lambda invoked by an EventBridge rule which erases accounts whose deletion grace period has passed
+//   - "exporter": lambda invoked asynchronously with a queued data export, or by an EventBridge rule to build every
+//     export which was not dispatched or whose worker stopped
+//   - "restorer": lambda invoked asynchronously with a queued world restore, or by an EventBridge rule to run every
+//     restore which was not dispatched or whose worker stopped
+//   - "interactions": lambda invoked asynchronously with a queued Discord slash command, or by an EventBridge rule to
+//     run every queued command which was not dispatched
+//   - "auth-challenge": lambda attached to the user pool's define, create and verify auth challenge triggers
+//   - "register-commands": registers the Discord slash command definitions and exits
+//   - "migrate-passwords": rotates passwords stored in custom:temporary_password, clears the attribute and exits
+//   - "migrate-user-state": copies installed mods, backups and server details out of Cognito attributes and exits
+//   - "rewrap-secrets": encrypts plaintext refresh tokens, re-encrypts secrets under the current master key and exits
+//   - "server": long-running HTTP server with an in-process scheduler ticker
 func main() {
-	lambda.Start(handleRequest)
+	mode := os.Getenv("RUN_MODE")
+	switch mode {
+	case "", "api", "scheduler", "eraser", "exporter", "restorer", "migrate-user-state", "server":
+		// These modes read and write user state so they refuse to start without somewhere to keep it
+		if _, err := service.MakeUserStateRepository(); err != nil {
+			log.Fatalf("failed to start %q: %v", mode, err)
+		}
+	}
+
+	switch mode {
+	case "scheduler":
+		lambda.Start(handleScheduledEvent)
+	case "pruner":
+		lambda.Start(handlePruneEvent)
+	case "reconciler":
+		lambda.Start(handleReconcileEvent)
+	case "eraser":
+		lambda.Start(handleEraseEvent)
+	case "exporter":
+		lambda.Start(handleExportEvent)
+	case "restorer":
+		lambda.Start(handleRestoreEvent)
+	case "interactions":
+		lambda.Start(handleInteractionEvent)
+	case "auth-challenge":
+		lambda.Start(handleAuthChallenge)
+	case "register-commands":
+		registerCommands()
+	case "migrate-passwords":
+		migratePasswords()
+	case "migrate-user-state":
+		migrateUserState()
+	case "rewrap-secrets":
+		rewrapSecrets()
+	case "server":
+		runServer()
+	default:
+		lambda.Start(handleRequest)
+	}
 }
 
 func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	return src.MakeRouter(ctx).ProxyWithContext(ctx, request)
 }
+
+func handleScheduledEvent(ctx context.Context, event events.CloudWatchEvent) error {
+	s3, err := service.MakeS3Service("us-east-1")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("running due schedules for event: %s", event.ID)
+	return src.MakeScheduler(s3).RunDue(ctx, event.Time)
+}
+
+func handlePruneEvent(ctx context.Context, event events.CloudWatchEvent) error {
+	s3, err := service.MakeS3Service("us-east-1")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("pruning auto backups for event: %s", event.ID)
+	return service.MakeRetentionService(s3, service.MakeEntitlementService(s3)).PruneAll(ctx)
+}
+
+func handleReconcileEvent(ctx context.Context, event events.CloudWatchEvent) error {
+	s3, err := service.MakeS3Service("us-east-1")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("reconciling storage usage for event: %s", event.ID)
+	return service.MakeQuotaService(s3, service.MakeEntitlementService(s3)).ReconcileAll(ctx)
+}
+
+func handleEraseEvent(ctx context.Context, event events.CloudWatchEvent) error {
+	s3, err := service.MakeS3Service("us-east-1")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("erasing deleted accounts for event: %s", event.ID)
+	return makeErasureService(s3).RunDue(ctx, event.Time)
+}
+
+// handleExportEvent builds the queued export named by the event, or every pending export when invoked by the
+// scheduled rule whose event has no id.
+func handleExportEvent(ctx context.Context, event model.ExportJobEvent) error {
+	s3, err := service.MakeS3Service("us-east-1")
+	if err != nil {
+		return err
+	}
+
+	exports := service.MakeExportService(s3, service.MakeCognitoService())
+	if event.ID == "" {
+		log.Infof("running pending exports")
+		return exports.RunPending(ctx)
+	}
+
+	return exports.RunJob(ctx, event.DiscordID, event.ID)
+}
+
+// handleRestoreEvent runs the queued restore named by the event, or every pending restore when invoked by the
+// scheduled rule whose event has no id.
+func handleRestoreEvent(ctx context.Context, event model.RestoreJobEvent) error {
+	s3, err := service.MakeS3Service("us-east-1")
+	if err != nil {
+		return err
+	}
+
+	server, err := service.MakeServerService()
+	if err != nil {
+		return err
+	}
+
+	fileManager, err := service.MakeFileManagerService()
+	if err != nil {
+		return err
+	}
+
+	restores := service.MakeRestoreService(s3, server, fileManager, service.MakeCognitoService())
+	if event.ID == "" {
+		log.Infof("running pending restores")
+		return restores.RunPending(ctx)
+	}
+
+	return restores.RunJob(ctx, event.ServerID, event.ID)
+}
+
+// handleInteractionEvent runs the queued slash command named by the event, or every queued command when invoked by
+// the scheduled rule whose event has no id.
+func handleInteractionEvent(ctx context.Context, event model.InteractionJobEvent) error {
+	s3, err := service.MakeS3Service("us-east-1")
+	if err != nil {
+		return err
+	}
+
+	discord, err := service.MakeDiscordService()
+	if err != nil {
+		return err
+	}
+
+	server, err := service.MakeServerService()
+	if err != nil {
+		log.Errorf("failed to create server api client: %v", err)
+	}
+
+	interactions := service.MakeInteractionService(s3, discord, server, nil, nil, nil)
+	if event.ID == "" {
+		log.Infof("running queued interactions")
+		return interactions.RunPending(ctx)
+	}
+
+	return interactions.RunJob(ctx, event.ID)
+}
+
+// handleAuthChallenge dispatches the Cognito custom auth triggers by their trigger source since one lambda serves all
+// three.
+func handleAuthChallenge(ctx context.Context, raw json.RawMessage) (any, error) {
+	var header events.CognitoEventUserPoolsHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, err
+	}
+
+	challenges := service.MakeAuthChallengeService()
+	switch header.TriggerSource {
+	case "DefineAuthChallenge_Authentication":
+		var event events.CognitoEventUserPoolsDefineAuthChallenge
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return nil, err
+		}
+		challenges.DefineAuthChallenge(&event)
+		return event, nil
+	case "CreateAuthChallenge_Authentication":
+		var event events.CognitoEventUserPoolsCreateAuthChallenge
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return nil, err
+		}
+		return event, challenges.CreateAuthChallenge(&event)
+	case "VerifyAuthChallengeResponse_Authentication":
+		var event events.CognitoEventUserPoolsVerifyAuthChallenge
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return nil, err
+		}
+		challenges.VerifyAuthChallenge(&event)
+		return event, nil
+	}
+
+	return nil, fmt.Errorf("unsupported trigger source: %s", header.TriggerSource)
+}
+
+func makeErasureService(s3 *service.S3Service) *service.ErasureService {
+	return service.MakeErasureService(s3, service.MakeCognitoService(), service.MakeMembershipService(s3), service.MakeBillingService(s3, service.MakeEntitlementService(s3)))
+}
+
+func runServer() {
+	ctx := context.Background()
+	s3, err := service.MakeS3Service("us-east-1")
+	if err != nil {
+		log.Fatalf("failed to create s3 client: %v", err)
+	}
+
+	go src.MakeScheduler(s3).Start(ctx, time.Minute)
+	go service.MakeRetentionService(s3, service.MakeEntitlementService(s3)).Start(ctx, time.Hour)
+	go service.MakeQuotaService(s3, service.MakeEntitlementService(s3)).Start(ctx, 6*time.Hour)
+	go makeErasureService(s3).Start(ctx, time.Hour)
+
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	log.Infof("starting server on: %s", addr)
+	if err = src.MakeEngine(ctx).Run(addr); err != nil {
+		log.Fatalf("server exited: %v", err)
+	}
+}
+
+func registerCommands() {
+	discord, err := service.MakeDiscordService()
+	if err != nil {
+		log.Fatalf("failed to create discord service: %v", err)
+	}
+
+	if err = service.MakeInteractionService(nil, discord, nil, nil, nil, nil).RegisterCommands(context.Background()); err != nil {
+		log.Fatalf("failed to register discord commands: %v", err)
+	}
+
+	log.Infof("registered %d discord commands", len(service.ValheimCommands))
+}
+
+func migratePasswords() {
+	migrated, err := service.MakeCognitoService().MigrateTemporaryPasswords(context.Background())
+	if err != nil {
+		log.Fatalf("failed to migrate passwords after %d users: %v", migrated, err)
+	}
+
+	log.Infof("migrated %d users off custom:temporary_password", migrated)
+}
+
+func migrateUserState() {
+	migrated, err := service.MakeCognitoService().MigrateUserState(context.Background())
+	if err != nil {
+		log.Fatalf("failed to migrate user state after %d users: %v", migrated, err)
+	}
+
+	log.Infof("migrated state of %d users out of cognito attributes", migrated)
+}
+
+// rewrapSecrets is run after changing KMS_KEY_ID or the keyfile's current key. The previous master key must remain
+// usable until it has finished.
+func rewrapSecrets() {
+	ctx := context.Background()
+	s3, err := service.MakeS3Service("us-east-1")
+	if err != nil {
+		log.Fatalf("failed to create s3 client: %v", err)
+	}
+
+	migrated, err := service.MakeCognitoService().MigrateRefreshTokens(ctx)
+	if err != nil {
+		log.Fatalf("failed to migrate refresh tokens after %d users: %v", migrated, err)
+	}
+
+	rewrapped, err := service.MakeSecretService(s3).RewrapAll(ctx)
+	if err != nil {
+		log.Fatalf("failed to rewrap secrets after %d secrets: %v", rewrapped, err)
+	}
+
+	log.Infof("encrypted %d plaintext refresh tokens and rewrapped %d secrets", migrated, rewrapped)
+}
@@ -0,0 +1,34 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+type ActivityHandler struct {
+	Audit *service.AuditService
+}
+
+// HandleRequest Returns the audit records about the authenticated user, newest first. The limit and before query
+// parameters page through older records.
+func (h *ActivityHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	page, err := h.Audit.Activity(ctx, user.DiscordID, c.Query("before"), limit)
+	if err != nil {
+		log.Errorf("failed to get activity for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get activity: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
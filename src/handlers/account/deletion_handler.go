@@ -0,0 +1,107 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+type DeleteAccountHandler struct {
+	Erasure *service.ErasureService
+	Audit   *service.AuditService
+}
+
+// HandleRequest Disables the authenticated user's account and schedules it and all of their files to be deleted once
+// the grace period has passed.
+func (h *DeleteAccountHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	request, err := h.Erasure.RequestErasure(ctx, user.DiscordID)
+	if errors.Is(err, service.ErrErasurePending) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   err.Error(),
+			"request": request,
+		})
+		return
+	}
+
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       user.DiscordID,
+		Subject:     user.DiscordID,
+		Action:      model.AuditActionAccountDelete,
+		Result:      model.AuditResultSuccess,
+	}
+
+	if err != nil {
+		record.Result = model.AuditResultFailure
+		record.Error = err.Error()
+		h.Audit.Record(ctx, record)
+		log.Errorf("failed to request deletion of user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to delete account: %v", err),
+		})
+		return
+	}
+
+	record.Target = request.ID
+	h.Audit.Record(ctx, record)
+	log.Infof("user: %s requested account deletion scheduled for: %s", user.DiscordID, request.ScheduledFor)
+	c.JSON(http.StatusAccepted, request)
+}
+
+type GetDeletionHandler struct {
+	Erasure *service.ErasureService
+}
+
+// HandleRequest Returns the authenticated user's pending account deletion.
+func (h *GetDeletionHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	request, err := h.Erasure.Pending(ctx, user.DiscordID)
+	if errors.Is(err, service.ErrErasureNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to get pending deletion for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get pending deletion: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+type CancelDeletionHandler struct {
+	Erasure *service.ErasureService
+}
+
+// HandleRequest Cancels the authenticated user's pending account deletion and re-enables their account.
+func (h *CancelDeletionHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	request, err := h.Erasure.Cancel(ctx, user.DiscordID)
+	if errors.Is(err, service.ErrErasureNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to cancel deletion for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to cancel deletion: %v", err),
+		})
+		return
+	}
+
+	log.Infof("user: %s cancelled account deletion", user.DiscordID)
+	c.JSON(http.StatusOK, request)
+}
@@ -0,0 +1,51 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+type GetEntitlementsHandler struct {
+	Entitlements *service.EntitlementService
+}
+
+// HandleRequest Returns the authenticated user's plan, limits and active grants.
+func (h *GetEntitlementsHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	entitlements, err := h.Entitlements.Entitlements(ctx, user.DiscordID)
+	if err != nil {
+		log.Errorf("failed to get entitlements for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get entitlements: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, entitlements)
+}
+
+type GetUsageHandler struct {
+	Quota *service.QuotaService
+}
+
+// HandleRequest Returns the storage the authenticated user is using per prefix along with the quota of their plan.
+func (h *GetUsageHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	usage, err := h.Quota.Usage(ctx, user.DiscordID)
+	if err != nil {
+		log.Errorf("failed to get storage usage for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get storage usage: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
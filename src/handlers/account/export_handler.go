@@ -0,0 +1,67 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+type StartExportHandler struct {
+	Exports *service.ExportService
+}
+
+// HandleRequest Starts building an archive of everything stored for the authenticated user. The returned job is
+// polled through the export status route until it has completed.
+func (h *StartExportHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	job, err := h.Exports.StartExport(ctx, user.DiscordID)
+	if errors.Is(err, service.ErrExportInProgress) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  err.Error(),
+			"export": job,
+		})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to start export for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to start export: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+type GetExportHandler struct {
+	Exports *service.ExportService
+}
+
+// HandleRequest Returns the export identified by the :exportId path parameter including a download link once it has
+// completed.
+func (h *GetExportHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	job, err := h.Exports.GetExport(ctx, user.DiscordID, c.Param("exportId"))
+	if errors.Is(err, service.ErrExportNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to get export for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get export: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
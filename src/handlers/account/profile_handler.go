@@ -0,0 +1,76 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type SyncProfileHandler struct {
+	Discord *service.DiscordService
+	Cognito *service.CognitoService
+}
+
+// HandleRequest Refreshes the authenticated user's Discord username and avatar from their Discord profile and
+// returns the updated user. The body must contain a Discord access token belonging to the user.
+func (h *SyncProfileHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	if h.Discord == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "discord service is unavailable"})
+		return
+	}
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.SyncProfileRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	if reqBody.DiscordAccessToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: discord_access_token missing."})
+		return
+	}
+
+	profile, err := h.Discord.GetUserInfo(reqBody.DiscordAccessToken)
+	if err != nil {
+		log.Errorf("failed to get discord profile for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("failed to get discord profile: %v", err),
+		})
+		return
+	}
+
+	if profile.ID != user.DiscordID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "discord access token does not belong to the authenticated user"})
+		return
+	}
+
+	synced, changed, err := h.Cognito.SyncDiscordProfile(ctx, user.DiscordID, profile)
+	if err != nil {
+		log.Errorf("failed to sync discord profile for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to sync profile: %v", err),
+		})
+		return
+	}
+
+	if len(changed) > 0 {
+		log.Infof("synced %v from discord for user: %s", changed, user.DiscordID)
+	}
+
+	c.JSON(http.StatusOK, synced)
+}
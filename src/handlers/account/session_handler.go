@@ -0,0 +1,117 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+type ListSessionsHandler struct {
+	Cognito *service.CognitoService
+}
+
+// HandleRequest Returns the authenticated user's active sessions, most recently used first. The session the request
+// was made with is marked as current.
+func (h *ListSessionsHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	sessions, err := h.Cognito.ListSessions(ctx, user.DiscordID)
+	if err != nil {
+		log.Errorf("failed to list sessions for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to list sessions: %v", err),
+		})
+		return
+	}
+
+	current := service.SessionID(c.Query("refreshToken"))
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+	})
+}
+
+type RevokeSessionHandler struct {
+	Cognito *service.CognitoService
+	Audit   *service.AuditService
+}
+
+// HandleRequest Signs the authenticated user out of the session identified by the :sessionId path parameter.
+func (h *RevokeSessionHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+	sessionId := c.Param("sessionId")
+
+	err := h.Cognito.RevokeSession(ctx, user.DiscordID, sessionId)
+	if errors.Is(err, service.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       user.DiscordID,
+		Subject:     user.DiscordID,
+		Action:      model.AuditActionSessionRevoke,
+		Target:      sessionId,
+		Result:      model.AuditResultSuccess,
+	}
+
+	if err != nil {
+		record.Result = model.AuditResultFailure
+		record.Error = err.Error()
+		h.Audit.Record(ctx, record)
+		log.Errorf("failed to revoke session: %s for user: %s: %v", sessionId, user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to revoke session: %v", err),
+		})
+		return
+	}
+
+	h.Audit.Record(ctx, record)
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("session: %s revoked", sessionId),
+	})
+}
+
+type RevokeAllSessionsHandler struct {
+	Cognito *service.CognitoService
+	Audit   *service.AuditService
+}
+
+// HandleRequest Signs the authenticated user out of every session, including the one the request was made with.
+func (h *RevokeAllSessionsHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	err := h.Cognito.GlobalSignOut(ctx, user.DiscordID)
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       user.DiscordID,
+		Subject:     user.DiscordID,
+		Action:      model.AuditActionSignOutAll,
+		Result:      model.AuditResultSuccess,
+	}
+
+	if err != nil {
+		record.Result = model.AuditResultFailure
+		record.Error = err.Error()
+		h.Audit.Record(ctx, record)
+		log.Errorf("failed to sign out user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to sign out: %v", err),
+		})
+		return
+	}
+
+	h.Audit.Record(ctx, record)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "signed out of every session",
+	})
+}
@@ -0,0 +1,95 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+const steamCallbackPath = "/api/v1/link/steam/callback"
+
+type SteamLinkStartHandler struct {
+	Steam *service.SteamService
+}
+
+// HandleRequest Starts linking a Steam account to the authenticated user and returns the Steam sign in URL the web
+// app should send the user to.
+func (h *SteamLinkStartHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	scheme := c.GetHeader("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	redirectURL, err := h.Steam.StartLink(ctx, user.DiscordID, fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, steamCallbackPath))
+	if err != nil {
+		log.Errorf("failed to start steam link for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to start steam link: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"redirectUrl": redirectURL,
+	})
+}
+
+type SteamLinkCallbackHandler struct {
+	Steam *service.SteamService
+}
+
+// HandleRequest Handles the redirect back from Steam. The OpenID assertion is verified with Steam and the Steam ID is
+// linked to the user who started linking. The user is redirected to the web app when STEAM_LINK_REDIRECT_URL is set.
+func (h *SteamLinkCallbackHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	discordId, steamId, err := h.Steam.CompleteLink(ctx, c.Request.URL.Query())
+	if err != nil {
+		log.Errorf("failed to complete steam link: %v", err)
+	}
+
+	if redirect := h.Steam.ResultURL(steamId, err); redirect != "" {
+		c.Redirect(http.StatusFound, redirect)
+		return
+	}
+
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{
+			"discordId": discordId,
+			"steamId":   steamId,
+		})
+	case errors.Is(err, service.ErrSteamLinkExpired), errors.Is(err, service.ErrSteamAssertion):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to link steam account: %v", err),
+		})
+	}
+}
+
+type SteamUnlinkHandler struct {
+	Steam *service.SteamService
+}
+
+// HandleRequest Removes the Steam account linked to the authenticated user.
+func (h *SteamUnlinkHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	if err := h.Steam.Unlink(ctx, user.DiscordID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to unlink steam account: %v", err),
+		})
+		return
+	}
+
+	log.Infof("unlinked steam account from user: %s", user.DiscordID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "steam account unlinked",
+	})
+}
@@ -0,0 +1,118 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type ListTokensHandler struct {
+	Tokens *service.PersonalTokenService
+}
+
+// HandleRequest Returns the authenticated user's personal access tokens. The tokens themselves are never returned.
+func (h *ListTokensHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	tokens, err := h.Tokens.List(ctx, user.DiscordID)
+	if err != nil {
+		log.Errorf("failed to list tokens for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to list tokens: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+	})
+}
+
+type CreateTokenHandler struct {
+	Tokens *service.PersonalTokenService
+	Audit  *service.AuditService
+}
+
+// HandleRequest Creates a personal access token for the authenticated user. The token is only returned in this
+// response.
+func (h *CreateTokenHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.PersonalAccessTokenRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	token, err := h.Tokens.Create(ctx, user.DiscordID, reqBody)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("failed to create token: %v", err),
+		})
+		return
+	}
+
+	h.Audit.Record(ctx, model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       user.DiscordID,
+		Subject:     user.DiscordID,
+		Action:      model.AuditActionTokenCreate,
+		Target:      token.Prefix,
+		Result:      model.AuditResultSuccess,
+	})
+
+	log.Infof("user: %s created personal access token: %s", user.DiscordID, token.Prefix)
+	c.JSON(http.StatusOK, token)
+}
+
+type RevokeTokenHandler struct {
+	Tokens *service.PersonalTokenService
+	Audit  *service.AuditService
+}
+
+// HandleRequest Revokes the personal access token identified by the :tokenId path parameter.
+func (h *RevokeTokenHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+	tokenId := c.Param("tokenId")
+
+	err := h.Tokens.Revoke(ctx, user.DiscordID, tokenId)
+	if errors.Is(err, service.ErrPersonalTokenNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to revoke token: %s for user: %s: %v", tokenId, user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to revoke token: %v", err),
+		})
+		return
+	}
+
+	h.Audit.Record(ctx, model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       user.DiscordID,
+		Subject:     user.DiscordID,
+		Action:      model.AuditActionTokenRevoke,
+		Target:      tokenId,
+		Result:      model.AuditResultSuccess,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("token: %s revoked", tokenId),
+	})
+}
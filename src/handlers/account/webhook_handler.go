@@ -0,0 +1,137 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type ListWebhooksHandler struct {
+	Notifier *service.NotifierService
+}
+
+// HandleRequest Returns the authenticated user's Discord webhooks.
+func (h *ListWebhooksHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	webhooks, err := h.Notifier.ListWebhooks(ctx, user.DiscordID)
+	if err != nil {
+		log.Errorf("failed to list webhooks for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to list webhooks: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks": webhooks,
+	})
+}
+
+type PutWebhookHandler struct {
+	Notifier *service.NotifierService
+}
+
+// HandleRequest Creates a webhook or, when the :webhookId path parameter is present, replaces an existing one.
+func (h *PutWebhookHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.WebhookRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	enabled := true
+	if reqBody.Enabled != nil {
+		enabled = *reqBody.Enabled
+	}
+
+	webhook, err := h.Notifier.PutWebhook(ctx, user.DiscordID, model.Webhook{
+		ID:      c.Param("webhookId"),
+		URL:     reqBody.URL,
+		Events:  reqBody.Events,
+		Enabled: enabled,
+	})
+
+	if errors.Is(err, service.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("failed to save webhook: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+type DeleteWebhookHandler struct {
+	Notifier *service.NotifierService
+}
+
+// HandleRequest Removes the webhook identified by the :webhookId path parameter.
+func (h *DeleteWebhookHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	err := h.Notifier.DeleteWebhook(ctx, user.DiscordID, c.Param("webhookId"))
+	if errors.Is(err, service.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to delete webhook for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to delete webhook: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "webhook deleted",
+	})
+}
+
+type TestWebhookHandler struct {
+	Notifier *service.NotifierService
+}
+
+// HandleRequest Sends a test message to the webhook identified by the :webhookId path parameter.
+func (h *TestWebhookHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	err := h.Notifier.Test(ctx, user.DiscordID, c.Param("webhookId"))
+	if errors.Is(err, service.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("failed to deliver test message: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "test message delivered",
+	})
+}
@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type GetUserEntitlementsHandler struct {
+	Entitlements *service.EntitlementService
+}
+
+// HandleRequest Returns the entitlements of the user identified by the :discordId path parameter.
+func (h *GetUserEntitlementsHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	discordId := c.Param("discordId")
+
+	entitlements, err := h.Entitlements.Entitlements(ctx, discordId)
+	if err != nil {
+		log.Errorf("failed to get entitlements for user: %s: %v", discordId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get entitlements: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, entitlements)
+}
+
+type GrantEntitlementHandler struct {
+	Entitlements *service.EntitlementService
+	Audit        *service.AuditService
+}
+
+// HandleRequest Grants a plan and/or features to the user identified by the :discordId path parameter for a limited
+// time.
+func (h *GrantEntitlementHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	admin := c.MustGet("user").(*model.CognitoUser)
+	discordId := c.Param("discordId")
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.GrantEntitlementRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	grant, err := h.Entitlements.Grant(ctx, discordId, admin.DiscordID, reqBody)
+	if err != nil {
+		h.Audit.Record(ctx, model.AuditRecord{
+			RequestMeta: c.MustGet("request").(model.RequestMeta),
+			Actor:       admin.DiscordID,
+			Subject:     discordId,
+			Action:      "admin.entitlement.grant",
+			Result:      model.AuditResultFailure,
+			Error:       err.Error(),
+		})
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("failed to grant entitlement: %v", err),
+		})
+		return
+	}
+
+	h.Audit.Record(ctx, model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       admin.DiscordID,
+		Subject:     discordId,
+		Action:      "admin.entitlement.grant",
+		Target:      grant.ID,
+		Result:      model.AuditResultSuccess,
+	})
+	c.JSON(http.StatusOK, grant)
+}
+
+type RevokeEntitlementHandler struct {
+	Entitlements *service.EntitlementService
+	Audit        *service.AuditService
+}
+
+// HandleRequest Revokes the grant identified by the :grantId path parameter from the user identified by the
+// :discordId path parameter.
+func (h *RevokeEntitlementHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	admin := c.MustGet("user").(*model.CognitoUser)
+	discordId := c.Param("discordId")
+	grantId := c.Param("grantId")
+
+	err := h.Entitlements.RevokeGrant(ctx, discordId, grantId)
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       admin.DiscordID,
+		Subject:     discordId,
+		Action:      "admin.entitlement.revoke",
+		Target:      grantId,
+		Result:      model.AuditResultSuccess,
+	}
+	if err != nil {
+		record.Result = model.AuditResultFailure
+		record.Error = err.Error()
+	}
+	h.Audit.Record(ctx, record)
+
+	if errors.Is(err, service.ErrGrantNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to revoke entitlement: %s from user: %s: %v", grantId, discordId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to revoke entitlement: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("entitlement: %s revoked", grantId),
+	})
+}
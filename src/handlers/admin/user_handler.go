@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+type SearchUsersHandler struct {
+	Admin *service.AdminService
+}
+
+// HandleRequest Returns a page of users matching the query parameter. The field query parameter selects whether the
+// query is matched against the Discord ID (default), the Discord username or the email address.
+func (h *SearchUsersHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "25"))
+
+	page, err := h.Admin.SearchUsers(ctx, c.Query("field"), c.Query("query"), int32(limit), c.Query("paginationToken"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("failed to search users: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+type GetUserDetailHandler struct {
+	Admin *service.AdminService
+}
+
+// HandleRequest Returns the user identified by the :discordId path parameter along with their files, servers,
+// entitlements and storage usage.
+func (h *GetUserDetailHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	discordId := c.Param("discordId")
+
+	detail, err := h.Admin.UserDetail(ctx, discordId)
+	if err != nil {
+		log.Errorf("failed to get detail for user: %s: %v", discordId, err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("failed to get user: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, detail)
+}
+
+type SetUserEnabledHandler struct {
+	Cognito *service.CognitoService
+	Audit   *service.AuditService
+	Enabled bool
+}
+
+// HandleRequest Enables or disables the user identified by the :discordId path parameter. Disabled users can no
+// longer sign in, including through Discord, until an admin enables them again.
+func (h *SetUserEnabledHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	admin := c.MustGet("user").(*model.CognitoUser)
+	discordId := c.Param("discordId")
+
+	action := "admin.user.disable"
+	if h.Enabled {
+		action = "admin.user.enable"
+	}
+	err := h.Cognito.SetAdminDisabled(ctx, discordId, !h.Enabled)
+
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       admin.DiscordID,
+		Subject:     discordId,
+		Action:      action,
+		Result:      model.AuditResultSuccess,
+	}
+	if err != nil {
+		record.Result = model.AuditResultFailure
+		record.Error = err.Error()
+		h.Audit.Record(ctx, record)
+		log.Errorf("failed to set enabled: %t for user: %s: %v", h.Enabled, discordId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to update user: %s", discordId),
+		})
+		return
+	}
+
+	h.Audit.Record(ctx, record)
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("user: %s updated", discordId),
+		"enabled": h.Enabled,
+	})
+}
+
+type ForceRefreshSessionHandler struct {
+	Cognito *service.CognitoService
+	Audit   *service.AuditService
+}
+
+// HandleRequest Revokes every session of the user identified by the :discordId path parameter so their clients must
+// sign in again and pick up any changes made to the account.
+func (h *ForceRefreshSessionHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	admin := c.MustGet("user").(*model.CognitoUser)
+	discordId := c.Param("discordId")
+
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       admin.DiscordID,
+		Subject:     discordId,
+		Action:      "admin.user.refresh_session",
+		Result:      model.AuditResultSuccess,
+	}
+	err := h.Cognito.GlobalSignOut(ctx, discordId)
+	if err != nil {
+		record.Result = model.AuditResultFailure
+		record.Error = err.Error()
+		h.Audit.Record(ctx, record)
+		log.Errorf("failed to refresh session for user: %s: %v", discordId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to refresh session: %v", err),
+		})
+		return
+	}
+
+	h.Audit.Record(ctx, record)
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("sessions for user: %s revoked", discordId),
+	})
+}
+
+type UserActivityHandler struct {
+	Audit *service.AuditService
+}
+
+// HandleRequest Returns the audit records about the user identified by the :discordId path parameter, newest first.
+// The limit and before query parameters page through older records.
+func (h *UserActivityHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	discordId := c.Param("discordId")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	page, err := h.Audit.Activity(ctx, discordId, c.Query("before"), limit)
+	if err != nil {
+		log.Errorf("failed to get activity for user: %s: %v", discordId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get activity: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type GetRetentionHandler struct {
+	Retention *service.RetentionService
+}
+
+// HandleRequest Returns the authenticated user's retention policy and pinned backups.
+func (h *GetRetentionHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	settings, err := h.Retention.GetSettings(ctx, user.DiscordID)
+	if err != nil {
+		log.Errorf("failed to get backup settings for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get backup settings: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+type PutRetentionHandler struct {
+	Retention *service.RetentionService
+}
+
+// HandleRequest Replaces the authenticated user's retention policy. Each bucket must be within the plan limits.
+func (h *PutRetentionHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.RetentionPolicy
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	settings, err := h.Retention.SetPolicy(ctx, user.DiscordID, reqBody)
+	if err != nil {
+		log.Errorf("failed to set retention policy for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("failed to set retention policy: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+type PreviewPruneHandler struct {
+	Retention *service.RetentionService
+}
+
+// HandleRequest Performs a dry run of the authenticated user's retention policy and returns which backups would be
+// kept and which would be removed by the next pruning run.
+func (h *PreviewPruneHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	result, err := h.Retention.Prune(ctx, user.DiscordID, true)
+	if err != nil {
+		log.Errorf("failed to preview pruning for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to preview pruning: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+type PinBackupHandler struct {
+	Retention *service.RetentionService
+	Pinned    bool
+}
+
+// HandleRequest Pins or unpins one of the authenticated user's automatic backups. Pinned backups are never pruned.
+func (h *PinBackupHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.PinBackupRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil || reqBody.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: name is required"})
+		return
+	}
+
+	settings, err := h.Retention.SetPinned(ctx, user.DiscordID, reqBody.Name, h.Pinned)
+	if errors.Is(err, service.ErrBackupNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("backup: %s does not exist", reqBody.Name),
+		})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to update pinned backups for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to update pinned backups: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
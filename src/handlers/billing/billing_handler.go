@@ -0,0 +1,124 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+// maxWebhookBodySize is the largest Stripe event accepted, real events are well below it.
+const maxWebhookBodySize = 1 << 20
+
+type CheckoutHandler struct {
+	Billing *service.BillingService
+}
+
+// HandleRequest Creates a Stripe Checkout session for the requested plan and returns the URL to send the user to.
+// Users who already have an active subscription are given a customer portal URL instead.
+func (h *CheckoutHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.CheckoutRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	checkoutURL, err := h.Billing.CreateCheckoutSession(ctx, user, reqBody.Plan)
+	if errors.Is(err, service.ErrPlanNotPurchasable) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to create checkout session for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("failed to create checkout session: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url": checkoutURL,
+	})
+}
+
+type PortalHandler struct {
+	Billing *service.BillingService
+}
+
+// HandleRequest Creates a Stripe customer portal session and returns the URL to send the user to.
+func (h *PortalHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	portalURL, err := h.Billing.CreatePortalSession(ctx, user.DiscordID)
+	if errors.Is(err, service.ErrNoBillingAccount) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to create portal session for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("failed to create portal session: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url": portalURL,
+	})
+}
+
+type WebhookHandler struct {
+	Billing *service.BillingService
+}
+
+// HandleRequest Handles the /api/v1/billing/webhook route which Stripe calls when a subscription changes. Every
+// request must carry a valid Stripe-Signature. A non 2xx response makes Stripe retry the event later.
+func (h *WebhookHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	bodyRaw, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBodySize))
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	if err = h.Billing.VerifySignature(c.GetHeader("Stripe-Signature"), bodyRaw); err != nil {
+		log.Errorf("rejected stripe webhook: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var event model.StripeEvent
+	if err = json.Unmarshal(bodyRaw, &event); err != nil || event.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: not a stripe event"})
+		return
+	}
+
+	if err = h.Billing.HandleEvent(ctx, &event); err != nil {
+		log.Errorf("failed to handle stripe event: %s of type: %s: %v", event.ID, event.Type, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to handle event: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"received": true,
+	})
+}
@@ -11,7 +11,10 @@ import (
 	"net/http"
 )
 
-type CognitoAuthHandler struct{}
+type CognitoAuthHandler struct {
+	Gate  *service.GuildGateService
+	Audit *service.AuditService
+}
 
 // HandleRequest Authenticates that a refresh token is valid for a given user id. This returns the entire
 // user object with a refreshed access token.
@@ -38,13 +41,36 @@ func (h *CognitoAuthHandler) HandleRequest(c *gin.Context, ctx context.Context)
 	authManager := service.MakeCognitoService()
 	log.Infof("authenticating user with discord id: %s", reqBody.DiscordID)
 	isAuth, cognitoUser := authManager.AuthUser(ctx, &reqBody.RefreshToken, &reqBody.DiscordID)
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       reqBody.DiscordID,
+		Subject:     reqBody.DiscordID,
+		Action:      model.AuditActionLogin,
+		Result:      model.AuditResultSuccess,
+	}
 
 	// Note: This also has checked that the user account in cognito is enabled.
 	if isAuth {
+		if err = h.Gate.Enforce(ctx, cognitoUser); err != nil {
+			log.Errorf("user: %s failed guild check: %v", reqBody.DiscordID, err)
+			record.Result = model.AuditResultFailure
+			record.Error = err.Error()
+			h.Audit.Record(ctx, record)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		h.Audit.Record(ctx, record)
+		authManager.TouchSession(ctx, reqBody.DiscordID, reqBody.RefreshToken, record.RequestMeta)
 		log.Infof("user auth ok")
 		c.JSON(http.StatusOK, cognitoUser)
 	} else {
 		log.Errorf("user is unauthorized")
+		record.Result = model.AuditResultFailure
+		record.Error = "user unauthorized"
+		h.Audit.Record(ctx, record)
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "user unauthorized",
 		})
@@ -3,6 +3,7 @@ package cognito
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cbartram/hearthhub/src/model"
 	"github.com/cbartram/hearthhub/src/service"
@@ -12,7 +13,12 @@ import (
 	"net/http"
 )
 
-type CognitoCreateUserRequestHandler struct{}
+type CognitoCreateUserRequestHandler struct {
+	Discord *service.DiscordService
+	Gate    *service.GuildGateService
+	Audit   *service.AuditService
+	Erasure *service.ErasureService
+}
 
 // HandleRequest This method handles the creation of a new cognito user after the user has finished the discord
 // OAuth flow. It will return a Cognito refresh token AND access token which will be used by the Kraken service to authenticate a user
@@ -27,18 +33,95 @@ func (h *CognitoCreateUserRequestHandler) HandleRequest(c *gin.Context, ctx cont
 	}
 
 	var reqBody model.CognitoCreateUserRequest
-	if err := json.Unmarshal(bodyRaw, &reqBody); err != nil {
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
 		return
 	}
 
+	// A session is issued for the discord id in the body so the caller must prove they own it before anything else
+	if h.Discord == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "discord service is unavailable"})
+		return
+	}
+
+	if reqBody.DiscordAccessToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: discord_access_token missing."})
+		return
+	}
+
+	discordUser, err := h.Discord.GetUserInfo(reqBody.DiscordAccessToken)
+	if err != nil || discordUser.ID != reqBody.DiscordID {
+		log.Errorf("discord access token does not belong to user: %s: %v", reqBody.DiscordID, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "discord access token does not belong to the given discord id"})
+		return
+	}
+
 	authManager := service.MakeCognitoService()
 
 	// We want to assert that the user does not exist before we create it.
 	user, _ := authManager.GetUser(ctx, &reqBody.DiscordID)
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       reqBody.DiscordID,
+		Subject:     reqBody.DiscordID,
+		Action:      model.AuditActionLogin,
+		Target:      "discord_oauth",
+		Result:      model.AuditResultSuccess,
+	}
+
+	if h.Gate.Enabled() {
+		check, err := h.Gate.CheckLogin(ctx, reqBody.DiscordID, reqBody.DiscordAccessToken)
+		if err != nil {
+			log.Errorf("failed to check guild membership for user: %s: %s", reqBody.DiscordID, err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if !check.Allowed {
+			record.Result = model.AuditResultFailure
+			record.Error = check.Reason
+			h.Audit.Record(ctx, record)
+			if user != nil && user.AccountEnabled {
+				authManager.DisableUser(ctx, reqBody.DiscordID)
+			}
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("%s: %s", service.ErrGuildAccessDenied, check.Reason),
+			})
+			return
+		}
+	}
+	if user != nil {
+		adminDisabled, err := authManager.IsAdminDisabled(ctx, reqBody.DiscordID)
+		if err != nil {
+			log.Errorf("failed to check if user: %s was disabled by an admin: %v", reqBody.DiscordID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("failed to check account status: %v", err),
+			})
+			return
+		}
+
+		if adminDisabled {
+			record.Result = model.AuditResultFailure
+			record.Error = "account disabled by an admin"
+			h.Audit.Record(ctx, record)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "your account has been disabled by an administrator",
+			})
+			return
+		}
+	}
+
 	if user == nil {
+		// New users are created with their profile as Discord reports it rather than as the client sent it
+		reqBody.DiscordUsername = discordUser.Username
+		reqBody.AvatarId = discordUser.Avatar
 		creds, err := authManager.CreateCognitoUser(ctx, &reqBody)
 		if err != nil {
+			record.Result = model.AuditResultFailure
+			record.Error = err.Error()
+			h.Audit.Record(ctx, record)
 			log.Errorf("error while creating new cognito user: %s", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "error while creating new cognito user:" + err.Error(),
@@ -46,6 +129,9 @@ func (h *CognitoCreateUserRequestHandler) HandleRequest(c *gin.Context, ctx cont
 			return
 		}
 
+		h.Audit.Record(ctx, record)
+		authManager.CreateSession(ctx, reqBody.DiscordID, *creds.RefreshToken, record.RequestMeta)
+
 		// Note: this does not provide the cognito id. However, users are located via username (discord id) not cognito id.
 		c.JSON(http.StatusOK, model.CognitoUser{
 			DiscordUsername:  reqBody.DiscordUsername,
@@ -63,11 +149,44 @@ func (h *CognitoCreateUserRequestHandler) HandleRequest(c *gin.Context, ctx cont
 			},
 		})
 	} else {
-		// User already exists.
-		log.Infof("user already exists, re-enabling and refreshing session")
-		authManager.EnableUser(ctx, reqBody.DiscordID)
+		// User already exists. An account with a pending deletion is disabled, signing in with Discord during the
+		// grace period cancels the deletion rather than re-enabling an account which would still be erased later.
+		_, err = h.Erasure.Pending(ctx, reqBody.DiscordID)
+		switch {
+		case err == nil:
+			log.Infof("user: %s signed in with a pending deletion, cancelling it", reqBody.DiscordID)
+			if _, err = h.Erasure.Cancel(ctx, reqBody.DiscordID); err != nil {
+				log.Errorf("failed to cancel deletion for user: %s: %v", reqBody.DiscordID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": fmt.Sprintf("failed to cancel pending account deletion: %v", err),
+				})
+				return
+			}
+
+			h.Audit.Record(ctx, model.AuditRecord{
+				RequestMeta: record.RequestMeta,
+				Actor:       reqBody.DiscordID,
+				Subject:     reqBody.DiscordID,
+				Action:      model.AuditActionAccountDeleteCancel,
+				Target:      "discord_oauth",
+				Result:      model.AuditResultSuccess,
+			})
+		case errors.Is(err, service.ErrErasureNotFound):
+			log.Infof("user already exists, re-enabling and refreshing session")
+			authManager.EnableUser(ctx, reqBody.DiscordID)
+		default:
+			log.Errorf("failed to check pending deletion for user: %s: %v", reqBody.DiscordID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("failed to check pending account deletion: %v", err),
+			})
+			return
+		}
+
 		creds, err := authManager.RefreshSession(ctx, reqBody.DiscordID)
 		if err != nil {
+			record.Result = model.AuditResultFailure
+			record.Error = err.Error()
+			h.Audit.Record(ctx, record)
 			log.Errorf("error: failed to refresh existing user session: " + err.Error())
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": fmt.Sprintf("user with discord id: %s already exists. failed to refresh session", reqBody.DiscordID),
@@ -75,11 +194,26 @@ func (h *CognitoCreateUserRequestHandler) HandleRequest(c *gin.Context, ctx cont
 			return
 		}
 
+		h.Audit.Record(ctx, record)
+		authManager.CreateSession(ctx, reqBody.DiscordID, creds.RefreshToken, record.RequestMeta)
+
+		// Users who renamed themselves or changed avatar on Discord since they last signed in are brought up to date
+		username, avatarId := reqBody.DiscordUsername, reqBody.AvatarId
+		synced, changed, err := authManager.SyncDiscordProfile(ctx, reqBody.DiscordID, discordUser)
+		if err != nil {
+			log.Warnf("failed to sync discord profile for user: %s: %v", reqBody.DiscordID, err)
+		} else {
+			username, avatarId = synced.DiscordUsername, synced.AvatarId
+			if len(changed) > 0 {
+				log.Infof("synced %v from discord for user: %s", changed, reqBody.DiscordID)
+			}
+		}
+
 		c.JSON(http.StatusOK, model.CognitoUser{
-			DiscordUsername:  reqBody.DiscordUsername,
+			DiscordUsername:  username,
 			Email:            reqBody.DiscordEmail,
 			DiscordID:        reqBody.DiscordID,
-			AvatarId:         reqBody.AvatarId,
+			AvatarId:         avatarId,
 			AccountEnabled:   true,
 			InstalledMods:    user.InstalledMods,
 			InstalledBackups: user.InstalledBackups,
@@ -11,10 +11,12 @@ import (
 	"net/http"
 )
 
-type CognitoRefreshSessionHandler struct{}
+type CognitoRefreshSessionHandler struct {
+	Audit *service.AuditService
+}
 
-// HandleRequest Authenticates that a refresh token is valid for a given user id. This returns the entire
-// user object with a refreshed access token.
+// HandleRequest Issues a new session, including a new refresh token, for a user who still holds a valid refresh token.
+// The session of the old refresh token is revoked so a stolen token cannot be used to keep minting new ones.
 func (h *CognitoRefreshSessionHandler) HandleRequest(c *gin.Context, ctx context.Context) {
 	bodyRaw, err := io.ReadAll(c.Request.Body)
 	if err != nil {
@@ -29,7 +31,7 @@ func (h *CognitoRefreshSessionHandler) HandleRequest(c *gin.Context, ctx context
 		return
 	}
 
-	if reqBody.DiscordID == "" {
+	if reqBody.DiscordID == "" || reqBody.RefreshToken == "" {
 		log.Errorf("error: discord id '%s' missing from request body: ", reqBody.DiscordID)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: discordId or refreshToken missing."})
 		return
@@ -37,12 +39,39 @@ func (h *CognitoRefreshSessionHandler) HandleRequest(c *gin.Context, ctx context
 
 	authManager := service.MakeCognitoService()
 	log.Infof("authenticating user with discord id: %s", reqBody.DiscordID)
-	creds, err := authManager.RefreshSession(ctx, reqBody.RefreshToken)
+	isAuth, _ := authManager.AuthUser(ctx, &reqBody.RefreshToken, &reqBody.DiscordID)
+	if !isAuth {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "user unauthorized",
+		})
+		return
+	}
+
+	creds, err := authManager.RefreshSession(ctx, reqBody.DiscordID)
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       reqBody.DiscordID,
+		Subject:     reqBody.DiscordID,
+		Action:      model.AuditActionRefreshSession,
+		Result:      model.AuditResultSuccess,
+	}
 
 	if err != nil {
+		record.Result = model.AuditResultFailure
+		record.Error = err.Error()
+		h.Audit.Record(ctx, record)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "error: failed to refresh user session: " + err.Error(),
 		})
+		return
+	}
+
+	h.Audit.Record(ctx, record)
+	authManager.CreateSession(ctx, reqBody.DiscordID, creds.RefreshToken, record.RequestMeta)
+
+	err = authManager.RevokeRefreshToken(ctx, reqBody.DiscordID, reqBody.RefreshToken, record.RequestMeta)
+	if err != nil {
+		log.Errorf("failed to revoke previous session of user: %s: %v", reqBody.DiscordID, err)
 	}
 
 	log.Infof("user auth ok")
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type DiscordInteractionsHandler struct {
+	Discord      *service.DiscordService
+	Interactions *service.InteractionService
+}
+
+// HandleRequest Handles the /api/v1/discord/interactions route which Discord calls when a user invokes one of the
+// /valheim slash commands. Every request must carry a valid Ed25519 signature from Discord. Slow commands are queued
+// and acknowledged with a deferred response which the interactions worker edits once they have finished.
+func (h *DiscordInteractionsHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	if h.Discord == nil || h.Interactions == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "discord interactions are not configured"})
+		return
+	}
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	signature := c.GetHeader("X-Signature-Ed25519")
+	timestamp := c.GetHeader("X-Signature-Timestamp")
+	if !h.Discord.VerifyInteraction(signature, timestamp, bodyRaw) {
+		log.Errorf("invalid discord interaction signature")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid request signature"})
+		return
+	}
+
+	var interaction model.DiscordInteraction
+	if err = json.Unmarshal(bodyRaw, &interaction); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.Interactions.Handle(ctx, &interaction))
+}
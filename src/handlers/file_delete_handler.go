@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"path"
+	"strings"
+)
+
+type DeleteFileHandler struct {
+	Quota *service.QuotaService
+	Audit *service.AuditService
+}
+
+// HandleRequest Deletes a single file, given by the prefix and name query parameters, from the server's mods, configs
+// or backups and removes it from the owner's storage usage.
+func (d *DeleteFileHandler) HandleRequest(c *gin.Context, s3Client *service.S3Service) {
+	serverId := c.GetString("serverId")
+	prefix := strings.TrimSuffix(c.Query("prefix"), "/")
+	name := c.Query("name")
+
+	if _, ok := ValidPrefixes[prefix]; !ok {
+		log.Errorf("invalid prefix: %s", prefix)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid prefix: %s", prefix),
+		})
+		return
+	}
+
+	if name == "" || name != path.Base(name) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "name must be a file name without a path",
+		})
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", prefix, serverId, name)
+	size, err := s3Client.ObjectSize(c.Request.Context(), key)
+	if errors.Is(err, service.ErrObjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("file: %s does not exist", name),
+		})
+		return
+	}
+
+	if err == nil {
+		err = s3Client.DeleteObject(c.Request.Context(), key)
+	}
+
+	user := c.MustGet("user").(*model.CognitoUser)
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       user.DiscordID,
+		Subject:     serverId,
+		Action:      model.AuditActionFileDelete,
+		Target:      key,
+		Result:      model.AuditResultSuccess,
+	}
+
+	if err != nil {
+		record.Result = model.AuditResultFailure
+		record.Error = err.Error()
+		d.Audit.Record(c.Request.Context(), record)
+		log.Errorf("failed to delete file: %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to delete file: %v", err),
+		})
+		return
+	}
+
+	if err = d.Quota.Record(c.Request.Context(), serverId, prefix, -size, -1); err != nil {
+		log.Errorf("failed to record storage usage for server: %s: %v", serverId, err)
+	}
+
+	d.Audit.Record(c.Request.Context(), record)
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("file deleted: %s", key),
+	})
+}
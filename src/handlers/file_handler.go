@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/cbartram/hearthhub/src/model"
@@ -17,41 +16,14 @@ import (
 type FileHandler struct{}
 
 // HandleRequest Handles the request for listing files under a given prefix. Since this route is deployed
-// to a lambda function and backed by the Cognito Authorizer only authorized users can invoke this.
+// to a lambda function and backed by the Cognito Authorizer only authorized users can invoke this. Members of a
+// server list its files by passing the serverId query parameter.
 func (f *FileHandler) HandleRequest(c *gin.Context, s3Client *service.S3Service) {
-	discordId := c.Query("discordId")
-	refreshToken := c.Query("refreshToken")
+	// Users are authenticated and authorized by the middleware. serverId is the owner of the files being listed which
+	// may be a server the user is a member of rather than their own.
+	serverId := c.GetString("serverId")
 	prefix := c.Query("prefix")
 
-	if discordId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "discordId query parameter is required",
-		})
-		return
-	}
-
-	if refreshToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "refreshToken query parameter is required",
-		})
-		return
-	}
-
-	// Anyone can list mods, only users can list their own backups and configuration
-	// Therefore, we need to verify that the given discord id belongs to the refresh token that was given
-	// in the Authorization header
-	authManager := service.MakeCognitoService()
-	log.Infof("authenticating user with discord id: %s", discordId)
-	_, user := authManager.AuthUser(context.Background(), &refreshToken, &discordId)
-
-	if user.DiscordID != discordId {
-		log.Errorf("authenticated user id: %s does not match given discord id: %s", user.DiscordID, discordId)
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "unauthorized: authenticated user id does not match given discord id",
-		})
-		return
-	}
-
 	// valid prefixes are stored in file_upload_handler.go and essentially are just:
 	// config, backups, mods to direct the s3 operation at where to list or put user files
 	_, ok := ValidPrefixes[prefix]
@@ -68,7 +40,7 @@ func (f *FileHandler) HandleRequest(c *gin.Context, s3Client *service.S3Service)
 		sanitizedPrefix = prefix[0 : len(prefix)-1]
 	}
 
-	path := fmt.Sprintf("%s/%s/", sanitizedPrefix, discordId)
+	path := fmt.Sprintf("%s/%s/", sanitizedPrefix, serverId)
 	log.Infof("prefix is sanitized and valid: %s, listing objects for path: %s", sanitizedPrefix, path)
 
 	objs, err := s3Client.ListObjects(path)
@@ -82,7 +54,7 @@ func (f *FileHandler) HandleRequest(c *gin.Context, s3Client *service.S3Service)
 
 	// Also perform a list objects on the default mods available  and concat the lists
 	if prefix == "mods" {
-		log.Infof("prefix is: mods, fetching default mods as well as custom mods for user: %s", discordId)
+		log.Infof("prefix is: mods, fetching default mods as well as custom mods for user: %s", serverId)
 		defaultObjs, err := s3Client.ListObjects("mods/general/")
 		if err != nil {
 			log.Errorf("failed to list default mods: %v", err)
@@ -96,7 +68,7 @@ func (f *FileHandler) HandleRequest(c *gin.Context, s3Client *service.S3Service)
 
 	if prefix == "backups" {
 		log.Infof("prefix is: backups fetching auto backups as well as uploaded backups")
-		autoBackups, err := s3Client.ListObjects(fmt.Sprintf("valheim-backups-auto/%s/", discordId))
+		autoBackups, err := s3Client.ListObjects(fmt.Sprintf("valheim-backups-auto/%s/", serverId))
 		if err != nil {
 			log.Errorf("failed to list auto backups: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
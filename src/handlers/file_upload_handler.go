@@ -1,8 +1,9 @@
 package handlers
 
 import (
-	"context"
+	"errors"
 	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
 	"github.com/cbartram/hearthhub/src/service"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
@@ -27,7 +28,11 @@ var ValidPrefixes = map[string]bool{
 	"mods/":    true,
 }
 
-type UploadFileHandler struct{}
+type UploadFileHandler struct {
+	Entitlements *service.EntitlementService
+	Quota        *service.QuotaService
+	Audit        *service.AuditService
+}
 
 // HandleRequest handles file uploads to S3
 func (u *UploadFileHandler) HandleRequest(c *gin.Context, s3Client *service.S3Service) {
@@ -40,24 +45,11 @@ func (u *UploadFileHandler) HandleRequest(c *gin.Context, s3Client *service.S3Se
 	}
 	defer file.Close()
 
-	discordId := c.Query("discordId")
-	refreshToken := c.Query("refreshToken")
+	// Users are authenticated and authorized by the middleware. serverId is the owner of the files being written
+	// which may be a server the user is a member of rather than their own.
+	serverId := c.GetString("serverId")
 	prefix := c.Query("query")
 
-	if discordId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "discordId query parameter is required",
-		})
-		return
-	}
-
-	if refreshToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "refreshToken query parameter is required",
-		})
-		return
-	}
-
 	// This is equivalent to multiplying 10 by 2^20 (2 to the power of 20)
 	// Since 2^20 = 1,048,576 (approximately 1 million), this gives us 10 megabytes in bytes
 	if header.Size > 30<<20 {
@@ -97,34 +89,90 @@ func (u *UploadFileHandler) HandleRequest(c *gin.Context, s3Client *service.S3Se
 		return
 	}
 
-	var sanitizedPrefix = prefix
-	if strings.HasSuffix("/", prefix) {
-		sanitizedPrefix = prefix[0 : len(prefix)-1]
+	sanitizedPrefix := strings.TrimSuffix(prefix, "/")
+
+	// Custom mods are a paid feature of the plan of the server owner since it is their storage being used
+	if sanitizedPrefix == "mods" {
+		err = u.Entitlements.Require(c.Request.Context(), serverId, model.FeatureCustomModUploads)
+		if errors.Is(err, service.ErrFeatureNotEntitled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err != nil {
+			log.Errorf("failed to get entitlements for server: %s: %v", serverId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("failed to get entitlements: %v", err),
+			})
+			return
+		}
 	}
 
-	// Validate user's given refresh token matches their provided discord id
-	authManager := service.MakeCognitoService()
-	log.Infof("authenticating user with discord id: %s", discordId)
-	_, user := authManager.AuthUser(context.Background(), &refreshToken, &discordId)
+	path := fmt.Sprintf("%s/%s/%s", sanitizedPrefix, serverId, header.Filename)
+
+	// Replacing a file only counts the difference in size against the quota
+	var deltaObjects int64 = 1
+	existingSize, err := s3Client.ObjectSize(c.Request.Context(), path)
+	if err == nil {
+		deltaObjects = 0
+	} else if !errors.Is(err, service.ErrObjectNotFound) {
+		log.Errorf("failed to check for existing file: %s: %v", path, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to check for existing file: %v", err),
+		})
+		return
+	}
+	deltaBytes := header.Size - existingSize
+
+	err = u.Quota.CheckQuota(c.Request.Context(), serverId, deltaBytes)
+	var quotaErr *service.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, model.QuotaExceededResponse{
+			Error:          quotaErr.Error(),
+			Code:           "quota_exceeded",
+			Plan:           quotaErr.Plan,
+			QuotaBytes:     quotaErr.QuotaBytes,
+			UsedBytes:      quotaErr.UsedBytes,
+			RequestedBytes: quotaErr.RequestedBytes,
+		})
+		return
+	}
 
-	if user.DiscordID != discordId {
-		log.Errorf("authenticated user id: %s does not match given discord id: %s", user.DiscordID, discordId)
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "unauthorized: authenticated user id does not match given discord id",
+	if err != nil {
+		log.Errorf("failed to check storage quota for server: %s: %v", serverId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to check storage quota: %v", err),
 		})
 		return
 	}
 
-	path := fmt.Sprintf("%s/%s/%s", sanitizedPrefix, discordId, header.Filename)
+	user := c.MustGet("user").(*model.CognitoUser)
+	record := model.AuditRecord{
+		RequestMeta: c.MustGet("request").(model.RequestMeta),
+		Actor:       user.DiscordID,
+		Subject:     serverId,
+		Action:      model.AuditActionFileUpload,
+		Target:      path,
+		Result:      model.AuditResultSuccess,
+	}
 
-	_, err = s3Client.UploadObject(context.Background(), path)
+	err = s3Client.UploadStream(c.Request.Context(), path, file)
 	if err != nil {
+		record.Result = model.AuditResultFailure
+		record.Error = err.Error()
+		u.Audit.Record(c.Request.Context(), record)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": fmt.Sprintf("failed to upload file: %v", err),
 		})
 		return
 	}
 
+	// The periodic reconcile corrects usage if recording fails so the upload is not failed for it
+	if err = u.Quota.Record(c.Request.Context(), serverId, sanitizedPrefix, deltaBytes, deltaObjects); err != nil {
+		log.Errorf("failed to record storage usage for server: %s: %v", serverId, err)
+	}
+
+	u.Audit.Record(c.Request.Context(), record)
 	c.JSON(http.StatusOK, gin.H{
 		"message": fmt.Sprintf("file upload ok: %s", path),
 	})
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type InternalEventHandler struct {
+	Notifier *service.NotifierService
+}
+
+// HandleRequest Accepts server and account events from internal services such as the server API and file manager
+// and delivers them to the subscribed webhooks of the user they belong to. Events without a Discord ID are sent
+// to the owner of the server they were raised for.
+func (h *InternalEventHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var event model.NotificationEvent
+	if err = json.Unmarshal(bodyRaw, &event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	if event.DiscordID == "" {
+		event.DiscordID = event.ServerID
+	}
+
+	if event.DiscordID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: discordId or serverId is required"})
+		return
+	}
+
+	log.Infof("received %s event for user: %s", event.Type, event.DiscordID)
+	if err = h.Notifier.Notify(ctx, event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("failed to notify: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "event accepted",
+	})
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type ListAccessEntriesHandler struct {
+	AccessLists *service.AccessListService
+}
+
+// HandleRequest Returns every entry in the admin, banned or permitted list of a server.
+func (h *ListAccessEntriesHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	list := c.Param("list")
+	if !validList(c, list) {
+		return
+	}
+
+	entries, err := h.AccessLists.ListEntries(ctx, serverId, list)
+	if err != nil {
+		log.Errorf("failed to list entries for list: %s: %v", list, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to list entries: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+	})
+}
+
+type PutAccessEntryHandler struct {
+	AccessLists    *service.AccessListService
+	CognitoService *service.CognitoService
+}
+
+// HandleRequest Adds an entry to a server's list or updates the note on an existing entry. When the :entryId path
+// parameter is present it takes precedence over the id in the request body.
+func (h *PutAccessEntryHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	list := c.Param("list")
+	if !validList(c, list) {
+		return
+	}
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.AccessListRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	if c.Param("entryId") != "" {
+		reqBody.ID = c.Param("entryId")
+	}
+
+	// Users rarely know their Steam64 id so a Discord user who has linked their Steam account can be used instead
+	if reqBody.ID == "" && reqBody.DiscordID != "" {
+		steamId, err := h.CognitoService.GetLinkedSteamID(ctx, reqBody.DiscordID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": fmt.Sprintf("user with discord id: %s does not exist", reqBody.DiscordID),
+			})
+			return
+		}
+
+		if steamId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("user with discord id: %s has not linked a steam account", reqBody.DiscordID),
+			})
+			return
+		}
+		reqBody.ID = steamId
+	}
+
+	if reqBody.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: id or discordId is required"})
+		return
+	}
+
+	entries, err := h.AccessLists.PutEntry(ctx, serverId, list, model.AccessListEntry{ID: reqBody.ID, Note: reqBody.Note})
+	if errors.Is(err, service.ErrInvalidPlatformID) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid id: %s: %v", reqBody.ID, err),
+		})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to put entry: %s in list: %s: %v", reqBody.ID, list, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to update list: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+	})
+}
+
+type DeleteAccessEntryHandler struct {
+	AccessLists *service.AccessListService
+}
+
+// HandleRequest Removes the entry identified by the :entryId path parameter from a server's list.
+func (h *DeleteAccessEntryHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	list := c.Param("list")
+	if !validList(c, list) {
+		return
+	}
+
+	entryId := c.Param("entryId")
+	entries, err := h.AccessLists.RemoveEntry(ctx, serverId, list, entryId)
+	if errors.Is(err, service.ErrEntryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("id: %s is not in the %s list", entryId, list),
+		})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to remove entry: %s from list: %s: %v", entryId, list, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to update list: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+	})
+}
+
+func validList(c *gin.Context, list string) bool {
+	if _, ok := service.AccessListFiles[list]; !ok {
+		log.Errorf("invalid list: %s", list)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid list: %s, must be one of: admin, banned, permitted", list),
+		})
+		return false
+	}
+	return true
+}
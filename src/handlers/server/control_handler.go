@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+type StatusHandler struct {
+	Servers *service.ServerService
+}
+
+// HandleRequest Returns the state of a server and the players connected to it.
+func (h *StatusHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	status, err := h.Servers.Status(ctx, serverId)
+	if err != nil {
+		log.Errorf("failed to get status for server: %s: %v", serverId, err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("failed to get server status: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+type LogsHandler struct {
+	Servers *service.ServerService
+}
+
+// HandleRequest Returns the most recent log lines of a server. The tail query parameter controls how many lines are
+// returned.
+func (h *LogsHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	tail, err := strconv.Atoi(c.DefaultQuery("tail", "200"))
+	if err != nil || tail <= 0 || tail > 5000 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "tail must be a number between 1 and 5000",
+		})
+		return
+	}
+
+	lines, err := h.Servers.Logs(ctx, serverId, tail)
+	if err != nil {
+		log.Errorf("failed to get logs for server: %s: %v", serverId, err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("failed to get server logs: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"lines": lines,
+	})
+}
+
+type ActionHandler struct {
+	Servers      *service.ServerService
+	Entitlements *service.EntitlementService
+}
+
+// HandleRequest Starts, stops, restarts or backs up a server depending on the :action path parameter. Starting a
+// server requires the owner's plan to allow every server they own.
+func (h *ActionHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+	action := c.Param("action")
+
+	var err error
+	switch action {
+	case model.ScheduleActionStart:
+		entErr := h.Entitlements.RequireServerCapacity(ctx, serverId)
+		if errors.Is(entErr, service.ErrServerLimitReached) {
+			c.JSON(http.StatusForbidden, gin.H{"error": entErr.Error()})
+			return
+		}
+
+		if entErr != nil {
+			log.Errorf("failed to get entitlements for server: %s: %v", serverId, entErr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("failed to get entitlements: %v", entErr),
+			})
+			return
+		}
+
+		err = h.Servers.Start(ctx, serverId)
+	case model.ScheduleActionStop:
+		err = h.Servers.Stop(ctx, serverId)
+	case model.ScheduleActionRestart:
+		err = h.Servers.Restart(ctx, serverId, service.RestartOptions{
+			Warning: c.Query("warning"),
+		})
+	case model.ScheduleActionBackup:
+		err = h.Servers.Backup(ctx, serverId)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid action: %s, must be one of: start, stop, restart, backup", action),
+		})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to %s server: %s: %v", action, serverId, err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": fmt.Sprintf("failed to %s server: %v", action, err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("%s requested for server: %s", action, serverId),
+	})
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type ListMembersHandler struct {
+	Memberships *service.MembershipService
+}
+
+// HandleRequest Returns the members of a server and its outstanding invites.
+func (h *ListMembersHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	access, err := h.Memberships.GetServerAccess(ctx, serverId)
+	if err != nil {
+		log.Errorf("failed to get members for server: %s: %v", serverId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get members: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, access)
+}
+
+type UpdateMemberHandler struct {
+	Memberships *service.MembershipService
+}
+
+// HandleRequest Changes the role of the member identified by the :discordId path parameter.
+func (h *UpdateMemberHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+	discordId := c.Param("discordId")
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.MemberRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	err = h.Memberships.SetMemberRole(ctx, serverId, discordId, reqBody.Role)
+	if !writeMembershipError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, model.ServerMembership{ServerID: serverId, Role: reqBody.Role})
+}
+
+type RemoveMemberHandler struct {
+	Memberships *service.MembershipService
+}
+
+// HandleRequest Revokes the access of the member identified by the :discordId path parameter.
+func (h *RemoveMemberHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+	discordId := c.Param("discordId")
+
+	err := h.Memberships.RemoveMember(ctx, serverId, discordId)
+	if !writeMembershipError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("user: %s removed from server: %s", discordId, serverId),
+	})
+}
+
+type CreateInviteHandler struct {
+	Memberships *service.MembershipService
+}
+
+// HandleRequest Invites a Discord user to a server or, when no discordId is given, creates a shareable invite link.
+func (h *CreateInviteHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+	serverId := c.GetString("serverId")
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.InviteRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	invite, err := h.Memberships.CreateInvite(ctx, user.DiscordID, serverId, reqBody)
+	if !writeMembershipError(c, err) {
+		return
+	}
+
+	log.Infof("user: %s created %s invite for server: %s", user.DiscordID, invite.Role, serverId)
+	c.JSON(http.StatusOK, invite)
+}
+
+type RevokeInviteHandler struct {
+	Memberships *service.MembershipService
+}
+
+// HandleRequest Revokes the outstanding invite identified by the :token path parameter.
+func (h *RevokeInviteHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	err := h.Memberships.RevokeInvite(ctx, serverId, c.Param("token"))
+	if !writeMembershipError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "invite revoked",
+	})
+}
+
+type ListInvitesHandler struct {
+	Memberships *service.MembershipService
+}
+
+// HandleRequest Returns the servers the authenticated user is a member of and the invites sent to them.
+func (h *ListInvitesHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	userAccess, err := h.Memberships.GetUserAccess(ctx, user.DiscordID)
+	if err != nil {
+		log.Errorf("failed to get memberships for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get memberships: %v", err),
+		})
+		return
+	}
+
+	invites, err := h.Memberships.PendingInvites(ctx, user.DiscordID)
+	if err != nil {
+		log.Errorf("failed to get invites for user: %s: %v", user.DiscordID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get invites: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"servers": userAccess.Servers,
+		"invites": invites,
+	})
+}
+
+type AcceptInviteHandler struct {
+	Memberships *service.MembershipService
+}
+
+// HandleRequest Accepts the invite identified by the :token path parameter on behalf of the authenticated user.
+func (h *AcceptInviteHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	user := c.MustGet("user").(*model.CognitoUser)
+
+	membership, err := h.Memberships.AcceptInvite(ctx, user.DiscordID, c.Param("token"))
+	if !writeMembershipError(c, err) {
+		return
+	}
+
+	log.Infof("user: %s joined server: %s as %s", user.DiscordID, membership.ServerID, membership.Role)
+	c.JSON(http.StatusOK, membership)
+}
+
+// writeMembershipError writes the response for err and returns true when there was no error.
+func writeMembershipError(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	switch {
+	case errors.Is(err, service.ErrMemberNotFound), errors.Is(err, service.ErrInviteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrAlreadyHasRole):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInviteNotForYou):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		log.Errorf("membership operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("membership operation failed: %v", err)})
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+)
+
+type RestoreHandler struct {
+	Restores *service.RestoreService
+}
+
+// HandleRequest Starts restoring a world set onto a server. The current world is snapshot first and automatically
+// restored if any step fails. The returned job is polled through the restore status route which lists the status of
+// every step.
+func (h *RestoreHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.RestoreRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	if err = service.ValidateRestoreRequest(serverId, reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	log.Infof("restoring world: %s to server: %s", reqBody.Db, serverId)
+	job, err := h.Restores.StartRestore(ctx, serverId, reqBody)
+	if errors.Is(err, service.ErrRestoreInProgress) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   err.Error(),
+			"restore": job,
+		})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to start restore for server: %s: %v", serverId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to restore world: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+type GetRestoreHandler struct {
+	Restores *service.RestoreService
+}
+
+// HandleRequest Returns the progress of a restore.
+func (h *GetRestoreHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	restoreId := c.Param("restoreId")
+	job, err := h.Restores.GetRestore(ctx, serverId, restoreId)
+	if errors.Is(err, service.ErrRestoreNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("restore: %s does not exist", restoreId),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get restore: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
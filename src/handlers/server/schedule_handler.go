@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ListSchedulesHandler struct {
+	Scheduler *service.SchedulerService
+}
+
+// HandleRequest Returns every schedule configured for a server.
+func (h *ListSchedulesHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	schedules, err := h.Scheduler.ListSchedules(ctx, serverId)
+	if err != nil {
+		log.Errorf("failed to list schedules for server: %s: %v", serverId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to list schedules: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schedules": schedules,
+	})
+}
+
+type PutScheduleHandler struct {
+	Scheduler *service.SchedulerService
+}
+
+// HandleRequest Creates a schedule for a server or, when the :scheduleId path parameter is present, replaces an
+// existing one.
+func (h *PutScheduleHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	bodyRaw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Errorf("could not read body from request: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read body from request: " + err.Error()})
+		return
+	}
+
+	var reqBody model.ScheduleRequest
+	if err = json.Unmarshal(bodyRaw, &reqBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	enabled := true
+	if reqBody.Enabled != nil {
+		enabled = *reqBody.Enabled
+	}
+
+	schedule, err := h.Scheduler.PutSchedule(ctx, model.ServerSchedule{
+		ID:             c.Param("scheduleId"),
+		ServerID:       serverId,
+		Name:           reqBody.Name,
+		Cron:           reqBody.Cron,
+		TimeZone:       reqBody.TimeZone,
+		Action:         reqBody.Action,
+		Warning:        reqBody.Warning,
+		WarningSeconds: reqBody.WarningSeconds,
+		Enabled:        enabled,
+	})
+
+	if errors.Is(err, service.ErrScheduleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("schedule: %s does not exist", c.Param("scheduleId")),
+		})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to put schedule for server: %s: %v", serverId, err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("failed to save schedule: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+type DeleteScheduleHandler struct {
+	Scheduler *service.SchedulerService
+}
+
+// HandleRequest Removes a schedule from a server.
+func (h *DeleteScheduleHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	scheduleId := c.Param("scheduleId")
+	err := h.Scheduler.DeleteSchedule(ctx, serverId, scheduleId)
+	if errors.Is(err, service.ErrScheduleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("schedule: %s does not exist", scheduleId),
+		})
+		return
+	}
+
+	if err != nil {
+		log.Errorf("failed to delete schedule: %s: %v", scheduleId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to delete schedule: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("schedule: %s deleted", scheduleId),
+	})
+}
+
+type PreviewScheduleHandler struct {
+	Scheduler *service.SchedulerService
+}
+
+// HandleRequest Returns the next times a schedule will run. The optional count query parameter controls how many
+// runs are returned.
+func (h *PreviewScheduleHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	scheduleId := c.Param("scheduleId")
+	schedule, err := h.Scheduler.GetSchedule(ctx, serverId, scheduleId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("schedule: %s does not exist", scheduleId),
+		})
+		return
+	}
+
+	count, _ := strconv.Atoi(c.DefaultQuery("count", "5"))
+	runs, err := h.Scheduler.NextRuns(schedule, time.Now(), count)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("failed to preview schedule: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"nextRuns": runs,
+	})
+}
+
+type ScheduleHistoryHandler struct {
+	Scheduler *service.SchedulerService
+}
+
+// HandleRequest Returns the most recent schedule runs for a server, newest first.
+func (h *ScheduleHistoryHandler) HandleRequest(c *gin.Context, ctx context.Context) {
+	serverId := c.GetString("serverId")
+
+	runs, err := h.Scheduler.History(ctx, serverId)
+	if err != nil {
+		log.Errorf("failed to get schedule history for server: %s: %v", serverId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to get schedule history: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"runs": runs,
+	})
+}
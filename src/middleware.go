@@ -1,10 +1,37 @@
 package src
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/cbartram/hearthhub/src/util"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
 )
 
+// permissionScopes are the personal access token scopes which grant each permission.
+var permissionScopes = map[string]string{
+	model.PermissionFilesRead:     model.ScopeFilesRead,
+	model.PermissionFilesWrite:    model.ScopeFilesWrite,
+	model.PermissionServerRead:    model.ScopeServersControl,
+	model.PermissionServerControl: model.ScopeServersControl,
+}
+
+// maxPeekBodySize is the largest JSON body RateLimitMiddleware reads to find the Discord id a request acts as.
+const maxPeekBodySize = 64 << 10
+
 func LogrusMiddleware(logger *log.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		logger.WithFields(log.Fields{
@@ -14,3 +41,295 @@ func LogrusMiddleware(logger *log.Logger) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// RequestMetaMiddleware Stores the client IP, user agent and request id on the context under the "request" key so
+// audit records can identify where an action came from. Under Lambda the IP is the source IP API Gateway saw, which
+// callers cannot forge the way they can X-Forwarded-For. The request id is taken from the X-Request-Id header when the
+// caller provided a reasonable one, otherwise one is generated, and it is echoed back in the response.
+func RequestMetaMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader("X-Request-Id")
+		if requestId == "" || len(requestId) > 128 {
+			requestId, _ = util.MakeCrypto().GenerateID(16)
+		}
+
+		c.Set("request", model.RequestMeta{
+			IP:        clientIP(c),
+			UserAgent: c.Request.UserAgent(),
+			RequestID: requestId,
+		})
+		c.Header("X-Request-Id", requestId)
+		c.Next()
+	}
+}
+
+// clientIP returns the IP of the caller from the API Gateway request context when running under Lambda, otherwise
+// the IP gin resolves using the engine's trusted proxies.
+func clientIP(c *gin.Context) string {
+	if gateway, ok := core.GetAPIGatewayContextFromContext(c.Request.Context()); ok && gateway.Identity.SourceIP != "" {
+		return gateway.Identity.SourceIP
+	}
+
+	return c.ClientIP()
+}
+
+// RateLimitMiddleware Throttles requests with the buckets of policy and rejects Discord ids which are locked out after
+// repeated failed sign ins with a 429 and a Retry-After header. The Discord id is taken from the discordId query
+// parameter or, for JSON requests, the discordId field of the body. Responses of 401 for a Discord id are counted as
+// failed sign ins and any successful response clears them. It must run before AuthMiddleware.
+func RateLimitMiddleware(limiter *service.RateLimiter, policy service.RateLimitPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		meta := c.MustGet("request").(model.RequestMeta)
+		discordId := requestDiscordID(c)
+
+		if discordId != "" {
+			if wait := limiter.LockedOut(c.Request.Context(), discordId); wait > 0 {
+				log.Warnf("rejected request to: %s for locked out user: %s", policy.Route, discordId)
+				writeTooManyRequests(c, wait, "too many failed sign in attempts, try again later")
+				return
+			}
+		}
+
+		if wait := limiter.Allow(c.Request.Context(), policy, meta.IP, discordId); wait > 0 {
+			log.Warnf("rate limited request to: %s from ip: %s user: %s", policy.Route, meta.IP, discordId)
+			writeTooManyRequests(c, wait, "rate limit exceeded, try again later")
+			return
+		}
+
+		c.Next()
+
+		if discordId == "" {
+			return
+		}
+
+		switch status := c.Writer.Status(); {
+		case status == http.StatusUnauthorized:
+			limiter.RecordAuthFailure(c.Request.Context(), meta, discordId)
+		case status < 300:
+			limiter.RecordAuthSuccess(c.Request.Context(), discordId)
+		}
+	}
+}
+
+// requestDiscordID returns the Discord id a request acts as without consuming the body.
+func requestDiscordID(c *gin.Context) string {
+	if discordId := c.Query("discordId"); discordId != "" {
+		return discordId
+	}
+
+	if !strings.HasPrefix(c.ContentType(), "application/json") || c.Request.Body == nil {
+		return ""
+	}
+
+	bodyRaw, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPeekBodySize))
+	if err != nil {
+		return ""
+	}
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(bodyRaw), c.Request.Body))
+
+	var body struct {
+		DiscordID string `json:"discordId"`
+	}
+	_ = json.Unmarshal(bodyRaw, &body)
+	return body.DiscordID
+}
+
+// writeTooManyRequests aborts the request with a 429 telling the client to retry after wait.
+func writeTooManyRequests(c *gin.Context, wait time.Duration, message string) {
+	c.Header("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error": message,
+	})
+}
+
+// AuthMiddleware Authenticates the discordId and refreshToken query parameters with Cognito and stores the resulting
+// *model.CognitoUser on the context under the "user" key so handlers behind it do not need to re-authenticate. When
+// guild gating is enabled users who are no longer in the required Discord guild or role are rejected.
+//
+// When tokens is not nil a personal access token in an "Authorization: Bearer" header is accepted instead and the
+// *model.PersonalAccessToken is also stored under the "token" key. Routes which accept tokens must check their
+// scopes with RequirePermission, every other route passes a nil tokens so they are rejected.
+func AuthMiddleware(cognitoService *service.CognitoService, gate *service.GuildGateService, tokens *service.PersonalTokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && service.IsPersonalToken(bearer) {
+			authenticatePersonalToken(c, cognitoService, gate, tokens, bearer)
+			return
+		}
+
+		discordId := c.Query("discordId")
+		refreshToken := c.Query("refreshToken")
+
+		if discordId == "" || refreshToken == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "discordId and refreshToken query parameters are required",
+			})
+			return
+		}
+
+		isAuth, user := cognitoService.AuthUser(c.Request.Context(), &refreshToken, &discordId)
+		if !isAuth || user.DiscordID != discordId {
+			log.Errorf("user with discord id: %s is unauthorized", discordId)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "unauthorized: authenticated user id does not match given discord id",
+			})
+			return
+		}
+
+		if err := gate.Enforce(c.Request.Context(), user); err != nil {
+			writeGuildGateError(c, err)
+			return
+		}
+
+		cognitoService.TouchSession(c.Request.Context(), discordId, refreshToken, c.MustGet("request").(model.RequestMeta))
+		c.Set("user", user)
+		c.Next()
+	}
+}
+
+// authenticatePersonalToken Authenticates a request made with a personal access token on behalf of its owner.
+func authenticatePersonalToken(c *gin.Context, cognitoService *service.CognitoService, gate *service.GuildGateService, tokens *service.PersonalTokenService, bearer string) {
+	if tokens == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "unauthorized: personal access tokens are not accepted on this route",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	route := fmt.Sprintf("%s %s", c.Request.Method, c.FullPath())
+	discordId, token, err := tokens.Authenticate(ctx, bearer, route, c.MustGet("request").(model.RequestMeta))
+	if err != nil {
+		log.Errorf("rejected personal access token for: %s: %v", route, err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "unauthorized: " + service.ErrInvalidPersonalToken.Error(),
+		})
+		return
+	}
+
+	user, err := cognitoService.GetUser(ctx, &discordId)
+	if err != nil || !user.AccountEnabled {
+		log.Errorf("personal access token: %s belongs to a missing or disabled user: %s", token.Prefix, discordId)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "unauthorized: user is disabled or does not exist",
+		})
+		return
+	}
+
+	if err = gate.Enforce(ctx, user); err != nil {
+		writeGuildGateError(c, err)
+		return
+	}
+
+	c.Set("user", user)
+	c.Set("token", token)
+	c.Next()
+}
+
+// writeGuildGateError aborts the request with the response for an error returned by GuildGateService.Enforce.
+func writeGuildGateError(c *gin.Context, err error) {
+	status := http.StatusForbidden
+	if errors.Is(err, service.ErrGuildCheckRequired) {
+		status = http.StatusUnauthorized
+	}
+
+	c.AbortWithStatusJSON(status, gin.H{
+		"error": err.Error(),
+	})
+}
+
+// RequirePermission Authorizes the authenticated user against a server and aborts the request unless their role
+// grants permission. Requests made with a personal access token also need the scope for permission, permissions
+// without a scope cannot be used with tokens. The server is taken from the :serverId path parameter, then the serverId query parameter and
+// finally defaults to the user's own server. The resolved server and role are stored on the context under the
+// "serverId" and "role" keys. It must run after AuthMiddleware.
+func RequirePermission(memberships *service.MembershipService, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(*model.CognitoUser)
+
+		serverId := c.Param("serverId")
+		if serverId == "" {
+			serverId = c.Query("serverId")
+		}
+		if serverId == "" {
+			serverId = user.DiscordID
+		}
+
+		if value, ok := c.Get("token"); ok {
+			token := value.(*model.PersonalAccessToken)
+			scope, ok := permissionScopes[permission]
+			if !ok || !slices.Contains(token.Scopes, scope) {
+				log.Errorf("personal access token: %s of user: %s lacks the scope for: %s", token.Prefix, user.DiscordID, permission)
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": fmt.Sprintf("forbidden: personal access token does not grant: %s", permission),
+				})
+				return
+			}
+		}
+
+		role, err := memberships.Authorize(c.Request.Context(), user.DiscordID, serverId, permission)
+		if errors.Is(err, service.ErrForbidden) {
+			log.Errorf("user: %s does not have permission: %s on server: %s", user.DiscordID, permission, serverId)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if err != nil {
+			log.Errorf("failed to authorize user: %s on server: %s: %v", user.DiscordID, serverId, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to authorize user: " + err.Error(),
+			})
+			return
+		}
+
+		c.Set("serverId", serverId)
+		c.Set("role", role)
+		c.Next()
+	}
+}
+
+// RequireAdmin Aborts the request unless the authenticated user is a HearthHub admin. It must run after
+// AuthMiddleware.
+func RequireAdmin(cognitoService *service.CognitoService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(*model.CognitoUser)
+
+		isAdmin, err := cognitoService.IsAdmin(c.Request.Context(), user.DiscordID)
+		if err != nil {
+			log.Errorf("failed to check if user: %s is an admin: %v", user.DiscordID, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to authorize user: " + err.Error(),
+			})
+			return
+		}
+
+		if !isAdmin {
+			log.Errorf("user: %s is not an admin", user.DiscordID)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "forbidden: admin access required",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// InternalAuthMiddleware Only allows requests from internal services which present the shared INTERNAL_API_TOKEN in
+// the X-Internal-Token header. All requests are rejected when no token is configured.
+func InternalAuthMiddleware() gin.HandlerFunc {
+	token := os.Getenv("INTERNAL_API_TOKEN")
+	return func(c *gin.Context) {
+		given := c.GetHeader("X-Internal-Token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			log.Errorf("rejected internal request to: %s", c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "unauthorized",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,17 @@
+package model
+
+// AdminUserPage is a page of users returned by an admin search.
+type AdminUserPage struct {
+	Users           []CognitoUser `json:"users"`
+	PaginationToken string        `json:"paginationToken,omitempty"`
+}
+
+// AdminUserDetail is everything support staff need to see about a user.
+type AdminUserDetail struct {
+	User         *CognitoUser                `json:"user"`
+	Files        map[string][]SimpleS3Object `json:"files"`
+	Servers      []ServerMembership          `json:"servers"`
+	ServerAccess *ServerAccess               `json:"serverAccess"`
+	Entitlements *Entitlements               `json:"entitlements"`
+	Usage        *StorageUsage               `json:"usage"`
+}
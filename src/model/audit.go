@@ -0,0 +1,50 @@
+package model
+
+import "time"
+
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+
+	AuditActionLogin               = "auth.login"
+	AuditActionRefreshSession      = "auth.refresh_session"
+	AuditActionLockout             = "auth.lockout"
+	AuditActionSessionRevoke       = "auth.session.revoke"
+	AuditActionSignOutAll          = "auth.sign_out_all"
+	AuditActionTokenCreate         = "token.create"
+	AuditActionTokenRevoke         = "token.revoke"
+	AuditActionTokenUse            = "token.use"
+	AuditActionFileUpload          = "file.upload"
+	AuditActionFileDelete          = "file.delete"
+	AuditActionAccountDelete       = "account.delete"
+	AuditActionAccountDeleteCancel = "account.delete.cancel"
+)
+
+// RequestMeta identifies the HTTP request an action was performed in. It is set on the gin context under the
+// "request" key for every request.
+type RequestMeta struct {
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// AuditRecord is a single security relevant action. Actor is who performed the action and Subject is the user it was
+// performed on, they are the same when users act on their own account.
+type AuditRecord struct {
+	RequestMeta
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Subject string    `json:"subject"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target,omitempty"`
+	Result  string    `json:"result"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// AuditPage is a page of audit records, newest first. Cursor is passed as the before query parameter to fetch the
+// next page and is empty on the last page.
+type AuditPage struct {
+	Records []AuditRecord `json:"records"`
+	Cursor  string        `json:"cursor,omitempty"`
+}
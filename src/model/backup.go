@@ -0,0 +1,85 @@
+package model
+
+import "time"
+
+// RetentionPolicy is a grandfather-father-son policy for automatic backups. The newest KeepLast backups are kept
+// along with the newest backup in each of the last Hourly hours, Daily days and Weekly weeks that have one.
+type RetentionPolicy struct {
+	KeepLast int `json:"keepLast"`
+	Hourly   int `json:"hourly"`
+	Daily    int `json:"daily"`
+	Weekly   int `json:"weekly"`
+}
+
+// BackupSettings holds a user's retention policy and the backups they have pinned. Pinned backups are never pruned.
+type BackupSettings struct {
+	Retention RetentionPolicy `json:"retention"`
+	Pinned    []string        `json:"pinned"`
+}
+
+// Backup is a single world backup made up of the .fwl and .db files which share a name.
+type Backup struct {
+	Name         string    `json:"name"`
+	Keys         []string  `json:"keys"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	Pinned       bool      `json:"pinned"`
+}
+
+// PruneResult describes which backups a retention policy keeps and which it removes.
+type PruneResult struct {
+	Kept    []Backup `json:"kept"`
+	Removed []Backup `json:"removed"`
+	DryRun  bool     `json:"dryRun"`
+}
+
+// PinBackupRequest pins or unpins a backup by name.
+type PinBackupRequest struct {
+	Name string `json:"name"`
+}
+
+const (
+	RestoreStepPending   = "pending"
+	RestoreStepRunning   = "running"
+	RestoreStepSucceeded = "succeeded"
+	RestoreStepFailed    = "failed"
+	RestoreStepSkipped   = "skipped"
+)
+
+// RestoreRequest identifies the world set to install. Both files must belong to the user's uploaded or automatic
+// backups.
+type RestoreRequest struct {
+	Fwl string `json:"fwl"`
+	Db  string `json:"db"`
+}
+
+// RestoreStep is the progress of one stage of a restore.
+type RestoreStep struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt,omitempty"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+}
+
+// RestoreJob tracks a world restore from stopping the server through to restarting it. It is pending until a worker
+// starts it. SnapshotKeys holds the pre-restore snapshot which is restored automatically if a later step fails.
+type RestoreJob struct {
+	ID           string         `json:"id"`
+	ServerID     string         `json:"serverId"`
+	DiscordID    string         `json:"discordId"`
+	Status       string         `json:"status"`
+	World        RestoreRequest `json:"world"`
+	SnapshotKeys []string       `json:"snapshotKeys"`
+	Steps        []RestoreStep  `json:"steps"`
+	RolledBack   bool           `json:"rolledBack"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	UpdatedAt    time.Time      `json:"updatedAt"`
+}
+
+// RestoreJobEvent is the payload the restores worker is invoked with. An empty ID, as sent by a scheduled rule, runs
+// every restore which is still pending or has stopped making progress.
+type RestoreJobEvent struct {
+	ServerID string `json:"serverId,omitempty"`
+	ID       string `json:"id,omitempty"`
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	StripeEventSubscriptionCreated  = "customer.subscription.created"
+	StripeEventSubscriptionUpdated  = "customer.subscription.updated"
+	StripeEventSubscriptionDeleted  = "customer.subscription.deleted"
+	StripeEventInvoicePaymentFailed = "invoice.payment_failed"
+)
+
+// BillingAccount links a user to their Stripe customer and records the state of their subscription.
+type BillingAccount struct {
+	DiscordID          string    `json:"discordId"`
+	CustomerID         string    `json:"customerId"`
+	SubscriptionID     string    `json:"subscriptionId,omitempty"`
+	SubscriptionStatus string    `json:"subscriptionStatus,omitempty"`
+	Plan               string    `json:"plan"`
+	LastPaymentFailed  time.Time `json:"lastPaymentFailed,omitempty"`
+	LastEventCreated   int64     `json:"lastEventCreated,omitempty"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// CheckoutRequest starts a Stripe Checkout session for Plan.
+type CheckoutRequest struct {
+	Plan string `json:"plan"`
+}
+
+// StripeEvent is the envelope of a Stripe webhook event. Object is decoded based on Type.
+type StripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// StripeSubscription is the subset of a Stripe subscription used to decide a user's plan.
+type StripeSubscription struct {
+	ID       string            `json:"id"`
+	Customer string            `json:"customer"`
+	Status   string            `json:"status"`
+	Metadata map[string]string `json:"metadata"`
+	Items    struct {
+		Data []struct {
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// StripeInvoice is the subset of a Stripe invoice used to record failed payments.
+type StripeInvoice struct {
+	ID           string `json:"id"`
+	Customer     string `json:"customer"`
+	Subscription string `json:"subscription"`
+}
@@ -0,0 +1,114 @@
+package model
+
+import "time"
+
+const (
+	InteractionTypePing               = 1
+	InteractionTypeApplicationCommand = 2
+
+	InteractionResponsePong                   = 1
+	InteractionResponseChannelMessage         = 4
+	InteractionResponseDeferredChannelMessage = 5
+
+	// MessageFlagEphemeral makes an interaction response visible only to the user who invoked the command.
+	MessageFlagEphemeral = 1 << 6
+
+	CommandOptionTypeSubCommand = 1
+	CommandOptionTypeString     = 3
+
+	InteractionJobPending = "pending"
+	InteractionJobRunning = "running"
+)
+
+// DiscordInteraction is the subset of a Discord interaction payload used to dispatch slash commands.
+type DiscordInteraction struct {
+	ID            string                 `json:"id"`
+	ApplicationID string                 `json:"application_id"`
+	Type          int                    `json:"type"`
+	Token         string                 `json:"token"`
+	GuildID       string                 `json:"guild_id,omitempty"`
+	Data          DiscordInteractionData `json:"data"`
+	Member        *DiscordMember         `json:"member,omitempty"`
+	User          *DiscordUser           `json:"user,omitempty"`
+}
+
+// DiscordInteractionData holds the invoked command and its options.
+type DiscordInteractionData struct {
+	Name    string                     `json:"name"`
+	Options []DiscordInteractionOption `json:"options,omitempty"`
+}
+
+// DiscordInteractionOption is a sub command or a value passed to a command.
+type DiscordInteractionOption struct {
+	Name    string                     `json:"name"`
+	Type    int                        `json:"type"`
+	Value   any                        `json:"value,omitempty"`
+	Options []DiscordInteractionOption `json:"options,omitempty"`
+}
+
+// DiscordMember is the guild member who invoked an interaction inside a guild.
+type DiscordMember struct {
+	User  DiscordUser `json:"user"`
+	Roles []string    `json:"roles"`
+}
+
+// DiscordUser is the Discord user who invoked an interaction.
+type DiscordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// InteractionResponse is returned to Discord in response to an interaction.
+type InteractionResponse struct {
+	Type int                      `json:"type"`
+	Data *InteractionResponseData `json:"data,omitempty"`
+}
+
+// InteractionResponseData is the message sent back to the channel a command was invoked in.
+type InteractionResponseData struct {
+	Content string `json:"content"`
+	Flags   int    `json:"flags,omitempty"`
+}
+
+// InteractionJob is a slow slash command which was acknowledged with a deferred response and is carried out by the
+// interactions worker. Token edits the deferred response and expires along with the interaction after 15 minutes.
+type InteractionJob struct {
+	ID        string    `json:"id"`
+	Command   string    `json:"command"`
+	DiscordID string    `json:"discordId"`
+	ServerID  string    `json:"serverId"`
+	Token     string    `json:"token"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// InteractionJobEvent is the payload the interactions worker is invoked with. An empty ID, as sent by a scheduled
+// rule, runs every job which is still pending.
+type InteractionJobEvent struct {
+	ID string `json:"id,omitempty"`
+}
+
+// ApplicationCommand is a slash command definition registered with Discord.
+type ApplicationCommand struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Options     []ApplicationCommandOption `json:"options,omitempty"`
+}
+
+// ApplicationCommandOption is a sub command or argument of an ApplicationCommand.
+type ApplicationCommandOption struct {
+	Type        int                        `json:"type"`
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Required    bool                       `json:"required,omitempty"`
+	Options     []ApplicationCommandOption `json:"options,omitempty"`
+}
+
+// GuildCheck is the cached result of checking whether a user is in the required Discord guild and holds one of the
+// required roles.
+type GuildCheck struct {
+	Allowed   bool      `json:"allowed"`
+	Reason    string    `json:"reason,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
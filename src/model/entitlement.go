@@ -0,0 +1,64 @@
+package model
+
+import "time"
+
+const (
+	PlanFree     = "free"
+	PlanStandard = "standard"
+	PlanPremium  = "premium"
+
+	// FeatureCustomModUploads allows uploading mods which are not in the HearthHub catalog.
+	FeatureCustomModUploads = "custom_mod_uploads"
+)
+
+// PlanLimits are what a plan allows a user to do.
+type PlanLimits struct {
+	MaxServers        int             `json:"maxServers"`
+	StorageQuotaBytes int64           `json:"storageQuotaBytes"`
+	BackupRetention   RetentionPolicy `json:"backupRetention"`
+	CustomModUploads  bool            `json:"customModUploads"`
+}
+
+// EntitlementGrant is a time-boxed entitlement given to a user by an admin. A grant raises the user to Plan and
+// unlocks Features until it expires.
+type EntitlementGrant struct {
+	ID        string    `json:"id"`
+	Plan      string    `json:"plan,omitempty"`
+	Features  []string  `json:"features,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	GrantedBy string    `json:"grantedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// EntitlementRecord is what is stored for each user. Plan is the plan the user pays for.
+type EntitlementRecord struct {
+	Plan   string             `json:"plan"`
+	Grants []EntitlementGrant `json:"grants"`
+}
+
+// Entitlements are the effective plan and limits of a user once every active grant has been applied.
+type Entitlements struct {
+	DiscordID string             `json:"discordId"`
+	Plan      string             `json:"plan"`
+	BasePlan  string             `json:"basePlan"`
+	Limits    PlanLimits         `json:"limits"`
+	Grants    []EntitlementGrant `json:"grants"`
+}
+
+// HasFeature returns true when the user's limits include feature.
+func (e *Entitlements) HasFeature(feature string) bool {
+	switch feature {
+	case FeatureCustomModUploads:
+		return e.Limits.CustomModUploads
+	}
+	return false
+}
+
+// GrantEntitlementRequest grants a plan and/or features to a user for DurationHours.
+type GrantEntitlementRequest struct {
+	Plan          string   `json:"plan"`
+	Features      []string `json:"features"`
+	Reason        string   `json:"reason"`
+	DurationHours int      `json:"durationHours"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+const (
+	ErasureStatusPending   = "pending"
+	ErasureStatusCancelled = "cancelled"
+	ErasureStatusCompleted = "completed"
+	ErasureStatusFailed    = "failed"
+)
+
+// ErasureRequest is a user's request to delete their account. The account is disabled straight away and erased once
+// ScheduledFor has passed unless the user cancels first. The request is kept after erasure as the audit record of
+// what was deleted.
+type ErasureRequest struct {
+	ID           string        `json:"id"`
+	DiscordID    string        `json:"discordId"`
+	Status       string        `json:"status"`
+	RequestedAt  time.Time     `json:"requestedAt"`
+	ScheduledFor time.Time     `json:"scheduledFor"`
+	CancelledAt  *time.Time    `json:"cancelledAt,omitempty"`
+	CompletedAt  *time.Time    `json:"completedAt,omitempty"`
+	Steps        []ErasureStep `json:"steps"`
+}
+
+// ErasureStep records a single action taken while handling an erasure request.
+type ErasureStep struct {
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+	At     time.Time `json:"at"`
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+// ExportJob builds a zip of everything stored for a user. DownloadURL is a presigned link which is only set once the
+// export has completed and is regenerated every time the job is read.
+type ExportJob struct {
+	ID                string     `json:"id"`
+	DiscordID         string     `json:"discordId"`
+	Status            string     `json:"status"`
+	Key               string     `json:"key"`
+	Files             int        `json:"files"`
+	Size              int64      `json:"size"`
+	Error             string     `json:"error,omitempty"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	UpdatedAt         time.Time  `json:"updatedAt"`
+	CompletedAt       *time.Time `json:"completedAt,omitempty"`
+	DownloadURL       string     `json:"downloadUrl,omitempty"`
+	DownloadExpiresAt *time.Time `json:"downloadExpiresAt,omitempty"`
+}
+
+// ExportJobEvent is the payload the exports worker is invoked with. An empty ID, as sent by a scheduled rule, builds
+// every export which is still pending or has stopped making progress.
+type ExportJobEvent struct {
+	DiscordID string `json:"discordId,omitempty"`
+	ID        string `json:"id,omitempty"`
+}
@@ -23,6 +23,10 @@ type CognitoCreateUserRequest struct {
 	DiscordUsername string `json:"discord_username"`
 	DiscordEmail    string `json:"discord_email"`
 	AvatarId        string `json:"avatar_id"`
+
+	// DiscordAccessToken is the user's Discord OAuth token. It proves the caller owns DiscordID before a session is
+	// issued and must have been granted the guilds.members.read scope when guild gating is enabled.
+	DiscordAccessToken string `json:"discord_access_token"`
 }
 
 type CognitoUserStatusRequest struct {
@@ -53,8 +57,29 @@ type CognitoUser struct {
 	Email            string             `json:"email,omitempty"`
 	AvatarId         string             `json:"avatarId"`
 	DiscordID        string             `json:"discordId,omitempty"`
+	SteamID          string             `json:"steamId,omitempty"`
 	InstalledMods    map[string]bool    `json:"installedMods"`
 	InstalledBackups map[string]bool    `json:"installedBackups"`
 	AccountEnabled   bool               `json:"accountEnabled,omitempty"`
+	SchemaVersion    int                `json:"schemaVersion,omitempty"`
 	Credentials      CognitoCredentials `json:"credentials,omitempty"`
 }
+
+// AccessListEntry is a single platform id in one of a server's admin, banned or permitted lists.
+type AccessListEntry struct {
+	ID   string `json:"id"`
+	Note string `json:"note,omitempty"`
+}
+
+// AccessListRequest adds or updates a list entry. Either ID or DiscordID must be set, when DiscordID is given
+// the Steam account linked to that user is used as the id.
+type AccessListRequest struct {
+	ID        string `json:"id"`
+	DiscordID string `json:"discordId"`
+	Note      string `json:"note"`
+}
+
+// SyncProfileRequest is the body used to refresh a user's Discord username and avatar from Discord.
+type SyncProfileRequest struct {
+	DiscordAccessToken string `json:"discord_access_token"`
+}
@@ -0,0 +1,71 @@
+package model
+
+import "time"
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleViewer = "viewer"
+
+	// PermissionServerRead allows viewing a server's status and logs.
+	PermissionServerRead = "server:read"
+	// PermissionServerControl allows starting, stopping, restarting and backing up a server.
+	PermissionServerControl = "server:control"
+	// PermissionFilesRead allows listing a server's mods, configs and backups.
+	PermissionFilesRead = "files:read"
+	// PermissionFilesWrite allows uploading mods and configs and editing a server's access lists.
+	PermissionFilesWrite = "files:write"
+	// PermissionManage allows managing members, schedules and restores.
+	PermissionManage = "server:manage"
+)
+
+// ServerMember is a user who has been granted a role on a server they do not own.
+type ServerMember struct {
+	DiscordID string    `json:"discordId"`
+	Role      string    `json:"role"`
+	AddedBy   string    `json:"addedBy"`
+	AddedAt   time.Time `json:"addedAt"`
+}
+
+// ServerInvite grants Role on ServerID to whoever accepts it. When DiscordID is set only that user may accept it,
+// otherwise it is a shareable link which can be accepted by anyone until it expires.
+type ServerInvite struct {
+	Token     string    `json:"token"`
+	ServerID  string    `json:"serverId"`
+	Role      string    `json:"role"`
+	DiscordID string    `json:"discordId,omitempty"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Link      string    `json:"link,omitempty"`
+}
+
+// ServerAccess is everything needed to authorize users against a server.
+type ServerAccess struct {
+	Members []ServerMember `json:"members"`
+	Invites []ServerInvite `json:"invites"`
+}
+
+// ServerMembership is a server a user can access and the role they hold on it.
+type ServerMembership struct {
+	ServerID string `json:"serverId"`
+	Role     string `json:"role"`
+}
+
+// UserAccess indexes the servers a user is a member of and the invites sent directly to them.
+type UserAccess struct {
+	Servers []ServerMembership `json:"servers"`
+	Invites []string           `json:"invites"`
+}
+
+// InviteRequest creates an invite. Leave DiscordID empty to create a shareable link.
+type InviteRequest struct {
+	DiscordID      string `json:"discordId"`
+	Role           string `json:"role"`
+	ExpiresInHours int    `json:"expiresInHours"`
+}
+
+// MemberRequest changes the role of an existing member.
+type MemberRequest struct {
+	Role string `json:"role"`
+}
@@ -0,0 +1,70 @@
+package model
+
+import "time"
+
+const (
+	EventServerStarted    = "server.started"
+	EventServerStopped    = "server.stopped"
+	EventServerCrashed    = "server.crashed"
+	EventPlayerJoined     = "player.joined"
+	EventPlayerLeft       = "player.left"
+	EventBackupCompleted  = "backup.completed"
+	EventModInstallFailed = "mod.install_failed"
+)
+
+// Webhook is a Discord webhook a user has subscribed to a set of events. URL is masked, the full URL is a secret.
+type Webhook struct {
+	ID             string    `json:"id"`
+	URL            string    `json:"url"`
+	Events         []string  `json:"events"`
+	Enabled        bool      `json:"enabled"`
+	NotFoundCount  int       `json:"notFoundCount"`
+	DisabledReason string    `json:"disabledReason,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	LastDelivered  time.Time `json:"lastDelivered,omitempty"`
+}
+
+// WebhookRequest creates or replaces a webhook.
+type WebhookRequest struct {
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"`
+}
+
+// NotificationEvent is something which happened to a user's server or account that webhooks can subscribe to.
+type NotificationEvent struct {
+	Type      string            `json:"type"`
+	DiscordID string            `json:"discordId"`
+	ServerID  string            `json:"serverId,omitempty"`
+	Message   string            `json:"message,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// DiscordEmbed is a rich embed in a Discord webhook message.
+type DiscordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+	Fields      []DiscordEmbedField `json:"fields,omitempty"`
+	Footer      *DiscordEmbedFooter `json:"footer,omitempty"`
+}
+
+// DiscordEmbedField is a name / value pair shown in an embed.
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// DiscordEmbedFooter is the small text shown at the bottom of an embed.
+type DiscordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+// DiscordWebhookMessage is the body posted to a Discord webhook.
+type DiscordWebhookMessage struct {
+	Username string         `json:"username,omitempty"`
+	Embeds   []DiscordEmbed `json:"embeds"`
+}
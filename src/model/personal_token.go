@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+const (
+	// ScopeFilesRead allows listing files and reading access lists.
+	ScopeFilesRead = "files:read"
+	// ScopeFilesWrite allows uploading and deleting files and editing access lists.
+	ScopeFilesWrite = "files:write"
+	// ScopeServersControl allows viewing, starting, stopping and backing up servers.
+	ScopeServersControl = "servers:control"
+)
+
+// PersonalAccessToken is a long-lived token a user creates for automation such as CI. Only a hash of the token is
+// stored, Prefix is kept so users can tell their tokens apart. Hash is never returned by the API.
+type PersonalAccessToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	Hash       string     `json:"hash,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP string     `json:"lastUsedIp,omitempty"`
+}
+
+// PersonalAccessTokenRequest is the body used to create a personal access token. ExpiresInDays defaults to 30.
+type PersonalAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// CreatedPersonalAccessToken is returned once when a token is created. Token cannot be retrieved again.
+type CreatedPersonalAccessToken struct {
+	PersonalAccessToken
+	Token string `json:"token"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// RateLimitState is the token bucket, and for Discord ids the run of failed sign ins, stored under a rate limit key.
+// Version is incremented on every write and is used to detect concurrent updates.
+type RateLimitState struct {
+	Key         string    `json:"key"`
+	Tokens      float64   `json:"tokens"`
+	Failures    int       `json:"failures,omitempty"`
+	LockedUntil time.Time `json:"lockedUntil,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	Version     int64     `json:"version"`
+}
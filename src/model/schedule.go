@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+const (
+	ScheduleActionStart   = "start"
+	ScheduleActionStop    = "stop"
+	ScheduleActionRestart = "restart"
+	ScheduleActionBackup  = "backup"
+)
+
+// ServerSchedule runs Action against a server whenever Cron matches in the given TimeZone.
+type ServerSchedule struct {
+	ID             string    `json:"id"`
+	ServerID       string    `json:"serverId"`
+	Name           string    `json:"name"`
+	Cron           string    `json:"cron"`
+	TimeZone       string    `json:"timeZone"`
+	Action         string    `json:"action"`
+	Warning        string    `json:"warning,omitempty"`
+	WarningSeconds int       `json:"warningSeconds,omitempty"`
+	Enabled        bool      `json:"enabled"`
+	CreatedAt      time.Time `json:"createdAt"`
+	LastRunAt      time.Time `json:"lastRunAt,omitempty"`
+}
+
+// ScheduleRequest creates or replaces a server schedule.
+type ScheduleRequest struct {
+	Name           string `json:"name"`
+	Cron           string `json:"cron"`
+	TimeZone       string `json:"timeZone"`
+	Action         string `json:"action"`
+	Warning        string `json:"warning"`
+	WarningSeconds int    `json:"warningSeconds"`
+	Enabled        *bool  `json:"enabled"`
+}
+
+// ScheduleRun records a single execution of a schedule.
+type ScheduleRun struct {
+	ScheduleID string    `json:"scheduleId"`
+	Action     string    `json:"action"`
+	StartedAt  time.Time `json:"startedAt"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+}
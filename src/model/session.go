@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// Session is a refresh token issued to a user. The refresh token itself is never returned, sessions are identified
+// by a hash of it. Current is set on the session the request listing them was made with.
+type Session struct {
+	ID         string     `json:"id"`
+	UserAgent  string     `json:"userAgent,omitempty"`
+	IP         string     `json:"ip,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	Current    bool       `json:"current,omitempty"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// PrefixUsage is the storage used under one prefix such as mods/ or configs/.
+type PrefixUsage struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+// StorageUsage is the storage a user is using across every prefix counted against their quota.
+type StorageUsage struct {
+	DiscordID    string                 `json:"discordId"`
+	Prefixes     map[string]PrefixUsage `json:"prefixes"`
+	TotalBytes   int64                  `json:"totalBytes"`
+	TotalObjects int64                  `json:"totalObjects"`
+	QuotaBytes   int64                  `json:"quotaBytes"`
+	ReconciledAt time.Time              `json:"reconciledAt"`
+	UpdatedAt    time.Time              `json:"updatedAt"`
+}
+
+// QuotaExceededResponse is the body of the 413 returned when an upload would exceed the user's storage quota.
+type QuotaExceededResponse struct {
+	Error          string `json:"error"`
+	Code           string `json:"code"`
+	Plan           string `json:"plan"`
+	QuotaBytes     int64  `json:"quotaBytes"`
+	UsedBytes      int64  `json:"usedBytes"`
+	RequestedBytes int64  `json:"requestedBytes"`
+}
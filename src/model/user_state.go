@@ -0,0 +1,20 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// UserState is the mutable state of a user which used to live in Cognito custom attributes. Version is incremented
+// on every write and is used to detect concurrent updates. AdminDisabled is set while an admin has disabled the
+// account so signing in again does not re-enable it.
+type UserState struct {
+	DiscordID        string            `json:"discordId"`
+	InstalledMods    map[string]bool   `json:"installedMods"`
+	InstalledBackups map[string]bool   `json:"installedBackups"`
+	ServerDetails    json.RawMessage   `json:"serverDetails,omitempty"`
+	Preferences      map[string]string `json:"preferences,omitempty"`
+	AdminDisabled    bool              `json:"adminDisabled,omitempty"`
+	Version          int64             `json:"version"`
+	UpdatedAt        time.Time         `json:"updatedAt"`
+}
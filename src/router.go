@@ -4,12 +4,19 @@ import (
 	"context"
 	ginadapter "github.com/awslabs/aws-lambda-go-api-proxy/gin"
 	"github.com/cbartram/hearthhub/src/handlers"
+	"github.com/cbartram/hearthhub/src/handlers/account"
+	"github.com/cbartram/hearthhub/src/handlers/admin"
+	"github.com/cbartram/hearthhub/src/handlers/backup"
+	"github.com/cbartram/hearthhub/src/handlers/billing"
 	"github.com/cbartram/hearthhub/src/handlers/cognito"
+	"github.com/cbartram/hearthhub/src/handlers/server"
+	"github.com/cbartram/hearthhub/src/model"
 	"github.com/cbartram/hearthhub/src/service"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"log"
 	"os"
+	"strings"
 )
 
 func CORSMiddleware() gin.HandlerFunc {
@@ -18,7 +25,7 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -29,7 +36,14 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
+// MakeRouter wraps the API in an adapter which serves API Gateway proxy requests from the lambda runtime.
 func MakeRouter(ctx context.Context) *ginadapter.GinLambda {
+	return ginadapter.New(MakeEngine(ctx))
+}
+
+// MakeEngine builds the gin engine with every API route registered. It is served directly when the API runs as a
+// long-running process.
+func MakeEngine(ctx context.Context) *gin.Engine {
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: false,
@@ -45,47 +59,118 @@ func MakeRouter(ctx context.Context) *ginadapter.GinLambda {
 
 	r := gin.New()
 
+	// Forwarding headers are only honoured from the proxies listed in TRUSTED_PROXIES, otherwise anyone could pick
+	// the IP rate limits and audit records see.
+	var trustedProxies []string
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		trustedProxies = strings.Split(proxies, ",")
+	}
+
+	if err = r.SetTrustedProxies(trustedProxies); err != nil {
+		logrus.Errorf("invalid TRUSTED_PROXIES, trusting no proxies: %v", err)
+		_ = r.SetTrustedProxies(nil)
+	}
+
 	gin.DefaultWriter = logger.Writer()
 	gin.DefaultErrorWriter = logger.Writer()
 	gin.SetMode(gin.ReleaseMode)
 
-	r.Use(LogrusMiddleware(logger))
+	r.Use(LogrusMiddleware(logger), RequestMetaMiddleware())
 
 	s3, err := service.MakeS3Service("us-east-1")
 	if err != nil {
 		logrus.Errorf("failed to create s3 client: %v", err)
 	}
 
+	fileManager, err := service.MakeFileManagerService()
+	if err != nil {
+		logrus.Errorf("failed to create file manager client: %v", err)
+	}
+
+	cognitoService := service.MakeCognitoService()
+	accessLists := service.MakeAccessListService(s3, fileManager)
+	serverService, err := service.MakeServerService()
+	if err != nil {
+		logrus.Errorf("failed to create server api client: %v", err)
+	}
+
+	restores := service.MakeRestoreService(s3, serverService, fileManager, cognitoService)
+	entitlements := service.MakeEntitlementService(s3)
+	retention := service.MakeRetentionService(s3, entitlements)
+	billingService := service.MakeBillingService(s3, entitlements)
+	quota := service.MakeQuotaService(s3, entitlements)
+	exports := service.MakeExportService(s3, cognitoService)
+	memberships := service.MakeMembershipService(s3)
+	erasure := service.MakeErasureService(s3, cognitoService, memberships, billingService)
+	notifier := service.MakeNotifierService(s3)
+	steam := service.MakeSteamService(s3, cognitoService)
+	audit := service.MakeAuditService()
+	limiter := service.MakeRateLimiter(audit)
+	personalTokens := service.MakePersonalTokenService(s3, audit)
+	adminService := service.MakeAdminService(s3, cognitoService, memberships, entitlements, quota)
+
+	discordService, err := service.MakeDiscordService()
+	if err != nil {
+		logrus.Errorf("failed to create discord service: %v", err)
+	}
+
+	var interactions *service.InteractionService
+	var guildGate *service.GuildGateService
+	if discordService != nil {
+		guildGate = service.MakeGuildGateService(discordService, cognitoService, s3)
+		interactions = service.MakeInteractionService(s3, discordService, serverService, memberships, cognitoService, guildGate)
+	}
+
+	scheduler := service.MakeSchedulerService(s3, serverService, cognitoService, guildGate)
+
 	apiGroup := r.Group("/api/v1", CORSMiddleware())
 	cognitoGroup := apiGroup.Group("/cognito", CORSMiddleware())
+	serverGroup := apiGroup.Group("/servers/:serverId", CORSMiddleware(), AuthMiddleware(cognitoService, guildGate, personalTokens))
+	backupGroup := apiGroup.Group("/backups", CORSMiddleware(), AuthMiddleware(cognitoService, guildGate, nil))
+	inviteGroup := apiGroup.Group("/invites", CORSMiddleware(), AuthMiddleware(cognitoService, guildGate, nil))
+	meGroup := apiGroup.Group("/me", CORSMiddleware(), AuthMiddleware(cognitoService, guildGate, nil))
+	adminGroup := apiGroup.Group("/admin", CORSMiddleware(), AuthMiddleware(cognitoService, guildGate, nil), RequireAdmin(cognitoService))
+	billingGroup := apiGroup.Group("/billing", CORSMiddleware())
+	linkGroup := apiGroup.Group("/link", CORSMiddleware())
+	internalGroup := apiGroup.Group("/internal", InternalAuthMiddleware())
 
 	apiGroup.POST("/discord/oauth", func(c *gin.Context) {
 		handler := handlers.DiscordRequestHandler{}
 		handler.HandleRequest(c, ctx)
 	})
 
-	apiGroup.GET("/file", func(c *gin.Context) {
+	apiGroup.POST("/discord/interactions", func(c *gin.Context) {
+		handler := handlers.DiscordInteractionsHandler{Discord: discordService, Interactions: interactions}
+		handler.HandleRequest(c, ctx)
+	})
+
+	apiGroup.GET("/file", AuthMiddleware(cognitoService, guildGate, personalTokens), RequirePermission(memberships, model.PermissionFilesRead), func(c *gin.Context) {
 		handler := handlers.FileHandler{}
 		handler.HandleRequest(c, s3)
 	})
 
-	apiGroup.POST("/file/upload", func(c *gin.Context) {
-		handler := handlers.UploadFileHandler{}
+	apiGroup.POST("/file/upload", RateLimitMiddleware(limiter, service.FileUploadRateLimit), AuthMiddleware(cognitoService, guildGate, personalTokens), RequirePermission(memberships, model.PermissionFilesWrite), func(c *gin.Context) {
+		handler := handlers.UploadFileHandler{Entitlements: entitlements, Quota: quota, Audit: audit}
+		handler.HandleRequest(c, s3)
+	})
+
+	apiGroup.DELETE("/file", AuthMiddleware(cognitoService, guildGate, personalTokens), RequirePermission(memberships, model.PermissionFilesWrite), func(c *gin.Context) {
+		handler := handlers.DeleteFileHandler{Quota: quota, Audit: audit}
 		handler.HandleRequest(c, s3)
 	})
 
 	cognitoGroup.POST("/create-user", func(c *gin.Context) {
-		handler := cognito.CognitoCreateUserRequestHandler{}
+		handler := cognito.CognitoCreateUserRequestHandler{Discord: discordService, Gate: guildGate, Audit: audit, Erasure: erasure}
 		handler.HandleRequest(c, ctx)
 	})
 
-	cognitoGroup.POST("/auth", func(c *gin.Context) {
-		handler := cognito.CognitoAuthHandler{}
+	cognitoGroup.POST("/auth", RateLimitMiddleware(limiter, service.AuthRateLimit), func(c *gin.Context) {
+		handler := cognito.CognitoAuthHandler{Gate: guildGate, Audit: audit}
 		handler.HandleRequest(c, ctx)
 	})
 
-	cognitoGroup.POST("/refresh-session", func(c *gin.Context) {
-		handler := cognito.CognitoRefreshSessionHandler{}
+	cognitoGroup.POST("/refresh-session", RateLimitMiddleware(limiter, service.RefreshSessionRateLimit), func(c *gin.Context) {
+		handler := cognito.CognitoRefreshSessionHandler{Audit: audit}
 		handler.HandleRequest(c, ctx)
 	})
 
@@ -94,5 +179,320 @@ func MakeRouter(ctx context.Context) *ginadapter.GinLambda {
 		handler.HandleRequest(c, ctx)
 	})
 
-	return ginadapter.New(r)
+	serverGroup.GET("/lists/:list", RequirePermission(memberships, model.PermissionFilesRead), func(c *gin.Context) {
+		handler := server.ListAccessEntriesHandler{AccessLists: accessLists}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.POST("/lists/:list", RequirePermission(memberships, model.PermissionFilesWrite), func(c *gin.Context) {
+		handler := server.PutAccessEntryHandler{AccessLists: accessLists, CognitoService: cognitoService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.PUT("/lists/:list/:entryId", RequirePermission(memberships, model.PermissionFilesWrite), func(c *gin.Context) {
+		handler := server.PutAccessEntryHandler{AccessLists: accessLists, CognitoService: cognitoService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.DELETE("/lists/:list/:entryId", RequirePermission(memberships, model.PermissionFilesWrite), func(c *gin.Context) {
+		handler := server.DeleteAccessEntryHandler{AccessLists: accessLists}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.GET("/schedules", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.ListSchedulesHandler{Scheduler: scheduler}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.POST("/schedules", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.PutScheduleHandler{Scheduler: scheduler}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.GET("/schedules/history", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.ScheduleHistoryHandler{Scheduler: scheduler}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.PUT("/schedules/:scheduleId", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.PutScheduleHandler{Scheduler: scheduler}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.DELETE("/schedules/:scheduleId", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.DeleteScheduleHandler{Scheduler: scheduler}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.GET("/schedules/:scheduleId/next", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.PreviewScheduleHandler{Scheduler: scheduler}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.POST("/restore", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.RestoreHandler{Restores: restores}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.GET("/restore/:restoreId", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.GetRestoreHandler{Restores: restores}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.GET("/status", RequirePermission(memberships, model.PermissionServerRead), func(c *gin.Context) {
+		handler := server.StatusHandler{Servers: serverService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.GET("/logs", RequirePermission(memberships, model.PermissionServerRead), func(c *gin.Context) {
+		handler := server.LogsHandler{Servers: serverService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.POST("/actions/:action", RequirePermission(memberships, model.PermissionServerControl), func(c *gin.Context) {
+		handler := server.ActionHandler{Servers: serverService, Entitlements: entitlements}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.GET("/members", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.ListMembersHandler{Memberships: memberships}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.PUT("/members/:discordId", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.UpdateMemberHandler{Memberships: memberships}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.DELETE("/members/:discordId", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.RemoveMemberHandler{Memberships: memberships}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.POST("/invites", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.CreateInviteHandler{Memberships: memberships}
+		handler.HandleRequest(c, ctx)
+	})
+
+	serverGroup.DELETE("/invites/:token", RequirePermission(memberships, model.PermissionManage), func(c *gin.Context) {
+		handler := server.RevokeInviteHandler{Memberships: memberships}
+		handler.HandleRequest(c, ctx)
+	})
+
+	inviteGroup.GET("", func(c *gin.Context) {
+		handler := server.ListInvitesHandler{Memberships: memberships}
+		handler.HandleRequest(c, ctx)
+	})
+
+	inviteGroup.POST("/:token/accept", func(c *gin.Context) {
+		handler := server.AcceptInviteHandler{Memberships: memberships}
+		handler.HandleRequest(c, ctx)
+	})
+
+	backupGroup.GET("/retention", func(c *gin.Context) {
+		handler := backup.GetRetentionHandler{Retention: retention}
+		handler.HandleRequest(c, ctx)
+	})
+
+	backupGroup.PUT("/retention", func(c *gin.Context) {
+		handler := backup.PutRetentionHandler{Retention: retention}
+		handler.HandleRequest(c, ctx)
+	})
+
+	backupGroup.GET("/retention/preview", func(c *gin.Context) {
+		handler := backup.PreviewPruneHandler{Retention: retention}
+		handler.HandleRequest(c, ctx)
+	})
+
+	backupGroup.POST("/pins", func(c *gin.Context) {
+		handler := backup.PinBackupHandler{Retention: retention, Pinned: true}
+		handler.HandleRequest(c, ctx)
+	})
+
+	backupGroup.DELETE("/pins", func(c *gin.Context) {
+		handler := backup.PinBackupHandler{Retention: retention, Pinned: false}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.GET("/webhooks", func(c *gin.Context) {
+		handler := account.ListWebhooksHandler{Notifier: notifier}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.POST("/webhooks", func(c *gin.Context) {
+		handler := account.PutWebhookHandler{Notifier: notifier}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.PUT("/webhooks/:webhookId", func(c *gin.Context) {
+		handler := account.PutWebhookHandler{Notifier: notifier}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.DELETE("/webhooks/:webhookId", func(c *gin.Context) {
+		handler := account.DeleteWebhookHandler{Notifier: notifier}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.POST("/webhooks/:webhookId/test", func(c *gin.Context) {
+		handler := account.TestWebhookHandler{Notifier: notifier}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.GET("/entitlements", func(c *gin.Context) {
+		handler := account.GetEntitlementsHandler{Entitlements: entitlements}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.DELETE("", func(c *gin.Context) {
+		handler := account.DeleteAccountHandler{Erasure: erasure, Audit: audit}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.GET("/deletion", func(c *gin.Context) {
+		handler := account.GetDeletionHandler{Erasure: erasure}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.DELETE("/deletion", func(c *gin.Context) {
+		handler := account.CancelDeletionHandler{Erasure: erasure}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.POST("/export", func(c *gin.Context) {
+		handler := account.StartExportHandler{Exports: exports}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.GET("/export/:exportId", func(c *gin.Context) {
+		handler := account.GetExportHandler{Exports: exports}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.GET("/usage", func(c *gin.Context) {
+		handler := account.GetUsageHandler{Quota: quota}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.GET("/activity", func(c *gin.Context) {
+		handler := account.ActivityHandler{Audit: audit}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.GET("/sessions", func(c *gin.Context) {
+		handler := account.ListSessionsHandler{Cognito: cognitoService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.DELETE("/sessions/:sessionId", func(c *gin.Context) {
+		handler := account.RevokeSessionHandler{Cognito: cognitoService, Audit: audit}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.DELETE("/sessions", func(c *gin.Context) {
+		handler := account.RevokeAllSessionsHandler{Cognito: cognitoService, Audit: audit}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.POST("/sync-profile", func(c *gin.Context) {
+		handler := account.SyncProfileHandler{Discord: discordService, Cognito: cognitoService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.GET("/tokens", func(c *gin.Context) {
+		handler := account.ListTokensHandler{Tokens: personalTokens}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.POST("/tokens", func(c *gin.Context) {
+		handler := account.CreateTokenHandler{Tokens: personalTokens, Audit: audit}
+		handler.HandleRequest(c, ctx)
+	})
+
+	meGroup.DELETE("/tokens/:tokenId", func(c *gin.Context) {
+		handler := account.RevokeTokenHandler{Tokens: personalTokens, Audit: audit}
+		handler.HandleRequest(c, ctx)
+	})
+
+	adminGroup.GET("/users", func(c *gin.Context) {
+		handler := admin.SearchUsersHandler{Admin: adminService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	adminGroup.GET("/users/:discordId", func(c *gin.Context) {
+		handler := admin.GetUserDetailHandler{Admin: adminService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	adminGroup.POST("/users/:discordId/enable", func(c *gin.Context) {
+		handler := admin.SetUserEnabledHandler{Cognito: cognitoService, Audit: audit, Enabled: true}
+		handler.HandleRequest(c, ctx)
+	})
+
+	adminGroup.POST("/users/:discordId/disable", func(c *gin.Context) {
+		handler := admin.SetUserEnabledHandler{Cognito: cognitoService, Audit: audit, Enabled: false}
+		handler.HandleRequest(c, ctx)
+	})
+
+	adminGroup.POST("/users/:discordId/refresh-session", func(c *gin.Context) {
+		handler := admin.ForceRefreshSessionHandler{Cognito: cognitoService, Audit: audit}
+		handler.HandleRequest(c, ctx)
+	})
+
+	adminGroup.GET("/users/:discordId/activity", func(c *gin.Context) {
+		handler := admin.UserActivityHandler{Audit: audit}
+		handler.HandleRequest(c, ctx)
+	})
+
+	adminGroup.GET("/users/:discordId/entitlements", func(c *gin.Context) {
+		handler := admin.GetUserEntitlementsHandler{Entitlements: entitlements}
+		handler.HandleRequest(c, ctx)
+	})
+
+	adminGroup.POST("/users/:discordId/entitlements", func(c *gin.Context) {
+		handler := admin.GrantEntitlementHandler{Entitlements: entitlements, Audit: audit}
+		handler.HandleRequest(c, ctx)
+	})
+
+	adminGroup.DELETE("/users/:discordId/entitlements/:grantId", func(c *gin.Context) {
+		handler := admin.RevokeEntitlementHandler{Entitlements: entitlements, Audit: audit}
+		handler.HandleRequest(c, ctx)
+	})
+
+	billingGroup.POST("/checkout", AuthMiddleware(cognitoService, guildGate, nil), func(c *gin.Context) {
+		handler := billing.CheckoutHandler{Billing: billingService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	billingGroup.POST("/portal", AuthMiddleware(cognitoService, guildGate, nil), func(c *gin.Context) {
+		handler := billing.PortalHandler{Billing: billingService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	billingGroup.POST("/webhook", func(c *gin.Context) {
+		handler := billing.WebhookHandler{Billing: billingService}
+		handler.HandleRequest(c, ctx)
+	})
+
+	linkGroup.GET("/steam/start", AuthMiddleware(cognitoService, guildGate, nil), func(c *gin.Context) {
+		handler := account.SteamLinkStartHandler{Steam: steam}
+		handler.HandleRequest(c, ctx)
+	})
+
+	linkGroup.GET("/steam/callback", func(c *gin.Context) {
+		handler := account.SteamLinkCallbackHandler{Steam: steam}
+		handler.HandleRequest(c, ctx)
+	})
+
+	linkGroup.DELETE("/steam", AuthMiddleware(cognitoService, guildGate, nil), func(c *gin.Context) {
+		handler := account.SteamUnlinkHandler{Steam: steam}
+		handler.HandleRequest(c, ctx)
+	})
+
+	internalGroup.POST("/events", func(c *gin.Context) {
+		handler := handlers.InternalEventHandler{Notifier: notifier}
+		handler.HandleRequest(c, ctx)
+	})
+
+	return r
 }
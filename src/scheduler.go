@@ -0,0 +1,25 @@
+package src
+
+import (
+	"github.com/cbartram/hearthhub/src/service"
+	"github.com/sirupsen/logrus"
+)
+
+// MakeScheduler creates the scheduler used by both the schedule routes and the EventBridge / ticker entry points.
+func MakeScheduler(s3 *service.S3Service) *service.SchedulerService {
+	serverService, err := service.MakeServerService()
+	if err != nil {
+		logrus.Errorf("failed to create server api client: %v", err)
+	}
+
+	cognito := service.MakeCognitoService()
+
+	var gate *service.GuildGateService
+	if discord, err := service.MakeDiscordService(); err == nil {
+		gate = service.MakeGuildGateService(discord, cognito, s3)
+	} else {
+		logrus.Errorf("failed to create discord service, schedules are not guild gated: %v", err)
+	}
+
+	return service.MakeSchedulerService(s3, serverService, cognito, gate)
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+)
+
+// AccessListFiles maps the list names accepted by the API to the file Valheim reads them from.
+var AccessListFiles = map[string]string{
+	"admin":     "adminlist.txt",
+	"banned":    "bannedlist.txt",
+	"permitted": "permittedlist.txt",
+}
+
+var (
+	steamIDPattern    = regexp.MustCompile(`^7656119\d{10}$`)
+	prefixedIDPattern = regexp.MustCompile(`^(Steam_7656119\d{10}|Xbox_\d{1,20})$`)
+
+	ErrInvalidPlatformID = errors.New("id must be a Steam64 id or a Steam_ / Xbox_ prefixed platform id")
+	ErrEntryNotFound     = errors.New("entry does not exist in list")
+)
+
+// AccessListService manages the admin, banned and permitted lists for a Valheim server. Lists are stored as
+// plain text files next to the user's configs so they can be synced onto the server by the file manager.
+type AccessListService struct {
+	s3          *S3Service
+	fileManager *FileManagerService
+}
+
+// MakeAccessListService creates a new access list service. fileManager may be nil in which case list changes
+// are persisted but not synced to the server.
+func MakeAccessListService(s3 *S3Service, fileManager *FileManagerService) *AccessListService {
+	return &AccessListService{
+		s3:          s3,
+		fileManager: fileManager,
+	}
+}
+
+// ValidatePlatformID Returns an error if the id is not a raw Steam64 id or a Steam_ / Xbox_ prefixed id.
+func ValidatePlatformID(id string) error {
+	if steamIDPattern.MatchString(id) || prefixedIDPattern.MatchString(id) {
+		return nil
+	}
+	return ErrInvalidPlatformID
+}
+
+// ListEntries returns all entries in the given list for a server. A list which has never been written is empty.
+func (a *AccessListService) ListEntries(ctx context.Context, serverId, list string) ([]model.AccessListEntry, error) {
+	key, err := accessListKey(serverId, list)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := a.s3.GetObject(ctx, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return []model.AccessListEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseAccessList(string(body)), nil
+}
+
+// PutEntry adds an entry to the list or updates the note of an existing entry with the same id.
+func (a *AccessListService) PutEntry(ctx context.Context, serverId, list string, entry model.AccessListEntry) ([]model.AccessListEntry, error) {
+	if err := ValidatePlatformID(entry.ID); err != nil {
+		return nil, err
+	}
+
+	entries, err := a.ListEntries(ctx, serverId, list)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := false
+	for i := range entries {
+		if entries[i].ID == entry.ID {
+			entries[i].Note = entry.Note
+			updated = true
+		}
+	}
+
+	if !updated {
+		entries = append(entries, entry)
+	}
+
+	return entries, a.write(ctx, serverId, list, entries)
+}
+
+// RemoveEntry removes the entry with the given id from the list.
+func (a *AccessListService) RemoveEntry(ctx context.Context, serverId, list, id string) ([]model.AccessListEntry, error) {
+	entries, err := a.ListEntries(ctx, serverId, list)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := make([]model.AccessListEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.ID != id {
+			remaining = append(remaining, entry)
+		}
+	}
+
+	if len(remaining) == len(entries) {
+		return nil, ErrEntryNotFound
+	}
+
+	return remaining, a.write(ctx, serverId, list, remaining)
+}
+
+// write persists the list to S3 and asks the file manager to sync it onto the server.
+func (a *AccessListService) write(ctx context.Context, serverId, list string, entries []model.AccessListEntry) error {
+	key, err := accessListKey(serverId, list)
+	if err != nil {
+		return err
+	}
+
+	if err = a.s3.PutObject(ctx, key, []byte(FormatAccessList(entries))); err != nil {
+		return err
+	}
+
+	if a.fileManager == nil {
+		log.Warnf("no file manager configured, list: %s for server: %s will not be synced", list, serverId)
+		return nil
+	}
+
+	return a.fileManager.SyncFile(ctx, serverId, key, ValheimConfigDir)
+}
+
+// ParseAccessList parses the contents of a Valheim list file. Valheim ignores lines beginning with "//" so
+// notes are stored as a comment on the line directly above the id they belong to.
+func ParseAccessList(contents string) []model.AccessListEntry {
+	entries := make([]model.AccessListEntry, 0)
+	note := ""
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			note = ""
+			continue
+		}
+
+		if strings.HasPrefix(line, "//") {
+			note = strings.TrimSpace(strings.TrimPrefix(line, "//"))
+			continue
+		}
+
+		entries = append(entries, model.AccessListEntry{ID: line, Note: note})
+		note = ""
+	}
+
+	return entries
+}
+
+// FormatAccessList renders entries into the Valheim list file format understood by ParseAccessList.
+func FormatAccessList(entries []model.AccessListEntry) string {
+	var sb strings.Builder
+	for _, entry := range entries {
+		if entry.Note != "" {
+			// Notes must stay on a single line or the remainder would be read as an id
+			sb.WriteString("// " + strings.Join(strings.Fields(entry.Note), " ") + "\n")
+		}
+		sb.WriteString(entry.ID + "\n")
+	}
+	return sb.String()
+}
+
+func accessListKey(serverId, list string) (string, error) {
+	file, ok := AccessListFiles[list]
+	if !ok {
+		return "", fmt.Errorf("invalid list: %s", list)
+	}
+	return fmt.Sprintf("configs/%s/%s", serverId, file), nil
+}
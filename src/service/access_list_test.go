@@ -0,0 +1,74 @@
+package service
+
+import (
+	"github.com/cbartram/hearthhub/src/model"
+	"reflect"
+	"testing"
+)
+
+func TestParseAccessList(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     []model.AccessListEntry
+	}{
+		{name: "empty", contents: "", want: []model.AccessListEntry{}},
+		{
+			name:     "ids without notes",
+			contents: "76561198000000001\nXbox_123\n",
+			want:     []model.AccessListEntry{{ID: "76561198000000001"}, {ID: "Xbox_123"}},
+		},
+		{
+			name:     "note belongs to the id below it",
+			contents: "// server owner\n76561198000000001\n76561198000000002\n",
+			want:     []model.AccessListEntry{{ID: "76561198000000001", Note: "server owner"}, {ID: "76561198000000002"}},
+		},
+		{
+			name:     "blank line detaches a note",
+			contents: "// stray comment\n\n76561198000000001\n",
+			want:     []model.AccessListEntry{{ID: "76561198000000001"}},
+		},
+		{
+			name:     "last of several comments is kept",
+			contents: "// first\n//second\n76561198000000001",
+			want:     []model.AccessListEntry{{ID: "76561198000000001", Note: "second"}},
+		},
+		{
+			name:     "windows line endings and surrounding spaces",
+			contents: "  // griefer  \r\n  Steam_76561198000000001  \r\n",
+			want:     []model.AccessListEntry{{ID: "Steam_76561198000000001", Note: "griefer"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseAccessList(tt.contents); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAccessList() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatAccessList(t *testing.T) {
+	entries := []model.AccessListEntry{
+		{ID: "76561198000000001", Note: "server owner"},
+		{ID: "Xbox_123"},
+		{ID: "76561198000000002", Note: "multi\nline\n76561198000000003  note"},
+	}
+
+	want := "// server owner\n76561198000000001\nXbox_123\n// multi line 76561198000000003 note\n76561198000000002\n"
+	got := FormatAccessList(entries)
+	if got != want {
+		t.Fatalf("FormatAccessList() = %q, want %q", got, want)
+	}
+
+	// A multi line note must not turn into an extra id when the file is read back
+	wantEntries := []model.AccessListEntry{
+		{ID: "76561198000000001", Note: "server owner"},
+		{ID: "Xbox_123"},
+		{ID: "76561198000000002", Note: "multi line 76561198000000003 note"},
+	}
+	if parsed := ParseAccessList(got); !reflect.DeepEqual(parsed, wantEntries) {
+		t.Errorf("ParseAccessList(FormatAccessList()) = %+v, want %+v", parsed, wantEntries)
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"strings"
+)
+
+const (
+	AdminSearchDiscordID = "discord_id"
+	AdminSearchUsername  = "username"
+	AdminSearchEmail     = "email"
+
+	maxAdminPageSize     = 60
+	maxUsernameScanPages = 5
+)
+
+// AdminService backs the support staff API.
+type AdminService struct {
+	s3           *S3Service
+	cognito      *CognitoService
+	memberships  *MembershipService
+	entitlements *EntitlementService
+	quota        *QuotaService
+}
+
+// MakeAdminService creates a new admin service.
+func MakeAdminService(s3 *S3Service, cognito *CognitoService, memberships *MembershipService, entitlements *EntitlementService, quota *QuotaService) *AdminService {
+	return &AdminService{
+		s3:           s3,
+		cognito:      cognito,
+		memberships:  memberships,
+		entitlements: entitlements,
+		quota:        quota,
+	}
+}
+
+// SearchUsers returns a page of users whose field starts with query. Discord IDs and emails are filtered by Cognito.
+// Discord usernames are a custom attribute which Cognito cannot filter on so pages are scanned and matched here, in
+// which case a page may hold fewer than limit users even though there are more to come.
+func (a *AdminService) SearchUsers(ctx context.Context, field, query string, limit int32, paginationToken string) (*model.AdminUserPage, error) {
+	if limit <= 0 || limit > maxAdminPageSize {
+		limit = maxAdminPageSize
+	}
+
+	// Quotes and backslashes would break out of the filter expression
+	query = strings.NewReplacer(`"`, "", `\`, "").Replace(query)
+
+	var filter string
+	switch field {
+	case AdminSearchDiscordID, "":
+		if query != "" {
+			filter = fmt.Sprintf(`username ^= "%s"`, query)
+		}
+	case AdminSearchEmail:
+		filter = fmt.Sprintf(`email ^= "%s"`, query)
+	case AdminSearchUsername:
+		return a.scanUsernames(ctx, strings.ToLower(query), limit, paginationToken)
+	default:
+		return nil, fmt.Errorf("invalid search field: %s, must be one of: discord_id, username, email", field)
+	}
+
+	users, next, err := a.cognito.ListUsers(ctx, filter, limit, paginationToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.AdminUserPage{Users: users, PaginationToken: next}, nil
+}
+
+func (a *AdminService) scanUsernames(ctx context.Context, query string, limit int32, paginationToken string) (*model.AdminUserPage, error) {
+	page := &model.AdminUserPage{Users: make([]model.CognitoUser, 0)}
+	token := paginationToken
+
+	for i := 0; i < maxUsernameScanPages; i++ {
+		users, next, err := a.cognito.ListUsers(ctx, "", maxAdminPageSize, token)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range users {
+			if strings.HasPrefix(strings.ToLower(user.DiscordUsername), query) {
+				page.Users = append(page.Users, user)
+			}
+		}
+
+		token = next
+		if token == "" || int32(len(page.Users)) >= limit {
+			break
+		}
+	}
+
+	page.PaginationToken = token
+	return page, nil
+}
+
+// UserDetail returns a user along with their files, the servers they can access, who can access theirs, their
+// entitlements and storage usage.
+func (a *AdminService) UserDetail(ctx context.Context, discordId string) (*model.AdminUserDetail, error) {
+	user, err := a.cognito.GetUser(ctx, &discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &model.AdminUserDetail{
+		User:  user,
+		Files: make(map[string][]model.SimpleS3Object),
+	}
+
+	for _, prefix := range ErasurePrefixes {
+		objects, err := a.s3.ListObjects(fmt.Sprintf("%s/%s/", prefix, discordId))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+
+		files := make([]model.SimpleS3Object, 0, len(objects))
+		for _, obj := range objects {
+			files = append(files, model.SimpleS3Object{Key: *obj.Key, Size: *obj.Size})
+		}
+		detail.Files[prefix] = files
+	}
+
+	userAccess, err := a.memberships.GetUserAccess(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+	detail.Servers = userAccess.Servers
+
+	if detail.ServerAccess, err = a.memberships.GetServerAccess(ctx, discordId); err != nil {
+		return nil, err
+	}
+
+	if detail.Entitlements, err = a.entitlements.Entitlements(ctx, discordId); err != nil {
+		return nil, err
+	}
+
+	if detail.Usage, err = a.quota.Usage(ctx, discordId); err != nil {
+		return nil, err
+	}
+
+	return detail, nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/util"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+const (
+	defaultAuditLogPath = "/tmp/hearthhub-audit.jsonl"
+	maxAuditPageSize    = 100
+
+	// auditSortLayout is fixed width so sort keys compare lexicographically in time order.
+	auditSortLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+// AuditSink is where audit records are appended and queried from.
+type AuditSink interface {
+	// Append stores a record. Records are never updated or deleted.
+	Append(ctx context.Context, record model.AuditRecord) error
+
+	// Query returns up to limit records about subject, newest first, whose sort key is before the given cursor. An
+	// empty cursor starts from the newest record.
+	Query(ctx context.Context, subject string, before string, limit int) ([]model.AuditRecord, error)
+}
+
+// AuditService records security relevant actions such as logins, uploads and admin changes so they can be reviewed
+// by the user they concern and by admins.
+type AuditService struct {
+	sink AuditSink
+}
+
+// MakeAuditService creates a new audit service. Records are written to the DynamoDB table named by AUDIT_TABLE_NAME
+// when it is set, otherwise they are appended to the JSONL file at AUDIT_LOG_PATH.
+func MakeAuditService() *AuditService {
+	if table := os.Getenv("AUDIT_TABLE_NAME"); table != "" {
+		sink, err := MakeDynamoAuditSink(table)
+		if err == nil {
+			return &AuditService{sink: sink}
+		}
+		log.Errorf("failed to create dynamodb audit sink, falling back to file: %v", err)
+	}
+
+	path := os.Getenv("AUDIT_LOG_PATH")
+	if path == "" {
+		path = defaultAuditLogPath
+	}
+
+	return &AuditService{sink: MakeFileAuditSink(path)}
+}
+
+// Record stores an audit record. The action being audited has already happened so a failure to record it is logged
+// rather than returned.
+func (a *AuditService) Record(ctx context.Context, record model.AuditRecord) {
+	if record.ID == "" {
+		record.ID, _ = util.MakeCrypto().GenerateID(8)
+	}
+
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+
+	log.WithFields(log.Fields{
+		"actor":     record.Actor,
+		"subject":   record.Subject,
+		"action":    record.Action,
+		"target":    record.Target,
+		"result":    record.Result,
+		"requestId": record.RequestID,
+	}).Info("audit")
+
+	if err := a.sink.Append(ctx, record); err != nil {
+		log.Errorf("failed to write audit record: %s: %v", record.ID, err)
+	}
+}
+
+// Activity returns a page of the records about subject, newest first.
+func (a *AuditService) Activity(ctx context.Context, subject, before string, limit int) (*model.AuditPage, error) {
+	if limit <= 0 || limit > maxAuditPageSize {
+		limit = maxAuditPageSize
+	}
+
+	records, err := a.sink.Query(ctx, subject, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &model.AuditPage{Records: records}
+	if len(records) == limit {
+		page.Cursor = auditSortKey(records[len(records)-1])
+	}
+
+	return page, nil
+}
+
+// auditSortKey orders records by time with the id breaking ties between records written in the same instant.
+func auditSortKey(record model.AuditRecord) string {
+	return record.Time.UTC().Format(auditSortLayout) + "#" + record.ID
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/cbartram/hearthhub/src/model"
+	"time"
+)
+
+// DynamoAuditSink stores audit records in a DynamoDB table with a "subject" partition key and an "sk" sort key, both
+// strings, so a user's records can be queried newest first.
+type DynamoAuditSink struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// MakeDynamoAuditSink creates a sink which writes to table.
+func MakeDynamoAuditSink(table string) (*DynamoAuditSink, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("error loading default aws config: %w", err)
+	}
+
+	return &DynamoAuditSink{
+		client: dynamodb.NewFromConfig(cfg),
+		table:  table,
+	}, nil
+}
+
+func (d *DynamoAuditSink) Append(ctx context.Context, record model.AuditRecord) error {
+	item := map[string]types.AttributeValue{
+		"subject": &types.AttributeValueMemberS{Value: record.Subject},
+		"sk":      &types.AttributeValueMemberS{Value: auditSortKey(record)},
+		"time":    &types.AttributeValueMemberS{Value: record.Time.UTC().Format(time.RFC3339Nano)},
+	}
+
+	optional := map[string]string{
+		"id":        record.ID,
+		"actor":     record.Actor,
+		"action":    record.Action,
+		"target":    record.Target,
+		"result":    record.Result,
+		"error":     record.Error,
+		"ip":        record.IP,
+		"userAgent": record.UserAgent,
+		"requestId": record.RequestID,
+	}
+	for name, value := range optional {
+		if value != "" {
+			item[name] = &types.AttributeValueMemberS{Value: value}
+		}
+	}
+
+	// The condition guards against silently overwriting a record should two ever share a sort key
+	_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(sk)"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put audit record: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DynamoAuditSink) Query(ctx context.Context, subject string, before string, limit int) ([]model.AuditRecord, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(d.table),
+		KeyConditionExpression: aws.String("subject = :subject"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":subject": &types.AttributeValueMemberS{Value: subject},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	}
+
+	if before != "" {
+		input.KeyConditionExpression = aws.String("subject = :subject AND sk < :before")
+		input.ExpressionAttributeValues[":before"] = &types.AttributeValueMemberS{Value: before}
+	}
+
+	out, err := d.client.Query(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit records: %w", err)
+	}
+
+	records := make([]model.AuditRecord, 0, len(out.Items))
+	for _, item := range out.Items {
+		record := model.AuditRecord{
+			RequestMeta: model.RequestMeta{
+				IP:        stringAttribute(item, "ip"),
+				UserAgent: stringAttribute(item, "userAgent"),
+				RequestID: stringAttribute(item, "requestId"),
+			},
+			ID:      stringAttribute(item, "id"),
+			Actor:   stringAttribute(item, "actor"),
+			Subject: stringAttribute(item, "subject"),
+			Action:  stringAttribute(item, "action"),
+			Target:  stringAttribute(item, "target"),
+			Result:  stringAttribute(item, "result"),
+			Error:   stringAttribute(item, "error"),
+		}
+		record.Time, _ = time.Parse(time.RFC3339Nano, stringAttribute(item, "time"))
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func stringAttribute(item map[string]types.AttributeValue, name string) string {
+	if value, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return value.Value
+	}
+	return ""
+}
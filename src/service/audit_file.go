@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"sync"
+)
+
+// FileAuditSink appends audit records as JSON lines to a local file. It is intended for local development and single
+// instance deployments, queries read the whole file.
+type FileAuditSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+// MakeFileAuditSink creates a sink which appends to the file at path, creating it when it does not exist.
+func MakeFileAuditSink(path string) *FileAuditSink {
+	return &FileAuditSink{path: path}
+}
+
+func (f *FileAuditSink) Append(ctx context.Context, record model.AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func (f *FileAuditSink) Query(ctx context.Context, subject string, before string, limit int) ([]model.AuditRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	records := make([]model.AuditRecord, 0)
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record model.AuditRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Warnf("skipping malformed audit log line: %v", err)
+			continue
+		}
+
+		if record.Subject == subject && (before == "" || auditSortKey(record) < before) {
+			records = append(records, record)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return auditSortKey(records[i]) > auditSortKey(records[j])
+	})
+
+	return records[:min(limit, len(records))], nil
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuthCacheSize = 1024
+
+	// authCacheExpiryMargin is how long before the access token expires that a cached identity stops being used.
+	authCacheExpiryMargin = time.Minute
+
+	// authCacheLocalTTL caps how long an identity is cached when there is no shared invalidation store, since an
+	// invalidation on one instance cannot reach the others.
+	authCacheLocalTTL = 5 * time.Second
+
+	// authCacheClockSkew is added to invalidation times so entries cached on an instance whose clock runs slightly
+	// behind are still rejected.
+	authCacheClockSkew = 2 * time.Second
+)
+
+var (
+	sharedAuthCache     *AuthCache
+	sharedAuthCacheOnce sync.Once
+)
+
+// AuthCacheEntry is an authenticated user, including their access token but never their refresh token, and when
+// it was authenticated and stops being valid.
+type AuthCacheEntry struct {
+	User      model.CognitoUser
+	CachedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// AuthInvalidationStore records when each user's cached identities were last invalidated so every instance can
+// reject identities it cached before then.
+type AuthInvalidationStore interface {
+	// InvalidatedAt returns when the user was last invalidated or the zero time if they never were.
+	InvalidatedAt(ctx context.Context, discordId string) (time.Time, error)
+	Invalidate(ctx context.Context, discordId string, at time.Time) error
+}
+
+// AuthCache saves the two Cognito round trips AuthUser makes by caching the identity a refresh token resolved to
+// until shortly before its access token expires. Identities are only cached in process, keyed by a hash of the
+// refresh token and Discord ID. When AUTH_CACHE_TABLE_NAME is set every hit is checked against the user's
+// invalidation time in a DynamoDB table shared by every instance, otherwise identities are only cached for
+// authCacheLocalTTL.
+type AuthCache struct {
+	local  *LRUAuthCache
+	shared AuthInvalidationStore
+}
+
+// MakeAuthCache returns the process wide auth cache. Services are created per request so they must share one cache
+// for it to be of any use. The LRU holds AUTH_CACHE_SIZE entries, defaulting to 1024.
+func MakeAuthCache() *AuthCache {
+	sharedAuthCacheOnce.Do(func() {
+		size, err := strconv.Atoi(os.Getenv("AUTH_CACHE_SIZE"))
+		if err != nil || size <= 0 {
+			size = defaultAuthCacheSize
+		}
+
+		sharedAuthCache = &AuthCache{local: MakeLRUAuthCache(size)}
+		if table := os.Getenv("AUTH_CACHE_TABLE_NAME"); table != "" {
+			shared, err := MakeDynamoAuthInvalidationStore(table)
+			if err != nil {
+				log.Errorf("failed to create dynamodb auth invalidation store, caching for %s only: %v", authCacheLocalTTL, err)
+			} else {
+				sharedAuthCache.shared = shared
+			}
+		}
+	})
+
+	return sharedAuthCache
+}
+
+// Get returns a copy of the cached user for the refresh token, or false when there is no unexpired entry which was
+// cached after the user was last invalidated.
+func (a *AuthCache) Get(ctx context.Context, discordId, refreshToken string) (*model.CognitoUser, bool) {
+	key := authCacheKey(discordId, refreshToken)
+	entry := a.local.Get(key)
+	if entry == nil || !time.Now().Before(entry.ExpiresAt) {
+		return nil, false
+	}
+
+	if a.shared != nil {
+		invalidatedAt, err := a.shared.InvalidatedAt(ctx, discordId)
+		if err != nil {
+			log.Warnf("failed to read auth invalidation for user: %s: %v", discordId, err)
+			return nil, false
+		}
+
+		if !entry.CachedAt.After(invalidatedAt.Add(authCacheClockSkew)) {
+			a.local.Invalidate(discordId)
+			return nil, false
+		}
+	}
+
+	user := entry.User
+	user.Credentials.RefreshToken = refreshToken
+	return &user, true
+}
+
+// Put caches the user authenticated with refreshToken until shortly before their access token expires.
+// authenticatedAt must be when authentication started so a concurrent invalidation is never missed.
+func (a *AuthCache) Put(refreshToken string, user *model.CognitoUser, authenticatedAt time.Time) {
+	lifetime := time.Duration(user.Credentials.TokenExpiration)*time.Second - authCacheExpiryMargin
+	if a.shared == nil {
+		lifetime = min(lifetime, authCacheLocalTTL)
+	}
+	if lifetime <= 0 {
+		return
+	}
+
+	entry := AuthCacheEntry{User: *user, CachedAt: authenticatedAt, ExpiresAt: authenticatedAt.Add(lifetime)}
+	entry.User.Credentials.RefreshToken = ""
+	a.local.Put(user.DiscordID, authCacheKey(user.DiscordID, refreshToken), entry)
+}
+
+// Invalidate drops every cached identity of the user, on every instance when there is a shared invalidation store,
+// so their next request is authenticated with Cognito.
+func (a *AuthCache) Invalidate(ctx context.Context, discordId string) {
+	a.local.Invalidate(discordId)
+	if a.shared != nil {
+		if err := a.shared.Invalidate(ctx, discordId, time.Now()); err != nil {
+			log.Errorf("failed to invalidate shared auth cache for user: %s: %v", discordId, err)
+		}
+	}
+}
+
+func authCacheKey(discordId, refreshToken string) string {
+	sum := sha256.Sum256([]byte(discordId + ":" + refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// LRUAuthCache holds cached identities in process and evicts the least recently used one once it is full.
+type LRUAuthCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	byUser  map[string]map[string]struct{}
+}
+
+type lruAuthCacheItem struct {
+	discordId string
+	key       string
+	entry     AuthCacheEntry
+}
+
+// MakeLRUAuthCache creates an LRU holding at most size entries.
+func MakeLRUAuthCache(size int) *LRUAuthCache {
+	return &LRUAuthCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		byUser:  make(map[string]map[string]struct{}),
+	}
+}
+
+func (l *LRUAuthCache) Get(key string) *AuthCacheEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.entries[key]
+	if !ok {
+		return nil
+	}
+
+	l.order.MoveToFront(elem)
+	entry := elem.Value.(*lruAuthCacheItem).entry
+	return &entry
+}
+
+func (l *LRUAuthCache) Put(discordId, key string, entry AuthCacheEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.entries[key]; ok {
+		elem.Value.(*lruAuthCacheItem).entry = entry
+		l.order.MoveToFront(elem)
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(&lruAuthCacheItem{discordId: discordId, key: key, entry: entry})
+	if l.byUser[discordId] == nil {
+		l.byUser[discordId] = make(map[string]struct{})
+	}
+	l.byUser[discordId][key] = struct{}{}
+
+	for l.order.Len() > l.size {
+		l.remove(l.order.Back())
+	}
+}
+
+func (l *LRUAuthCache) Invalidate(discordId string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key := range l.byUser[discordId] {
+		l.remove(l.entries[key])
+	}
+}
+
+func (l *LRUAuthCache) remove(elem *list.Element) {
+	item := l.order.Remove(elem).(*lruAuthCacheItem)
+	delete(l.entries, item.key)
+	delete(l.byUser[item.discordId], item.key)
+	if len(l.byUser[item.discordId]) == 0 {
+		delete(l.byUser, item.discordId)
+	}
+}
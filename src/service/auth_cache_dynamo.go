@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"strconv"
+	"time"
+)
+
+// authInvalidationRetention is how long an invalidation is kept. It outlives the longest access token Cognito
+// issues and so every identity which could have been cached before it.
+const authInvalidationRetention = 25 * time.Hour
+
+// DynamoAuthInvalidationStore shares auth cache invalidations between instances through a DynamoDB table with a
+// "discordId" string partition key. Only the invalidation time is stored, never a token. Items carry an
+// "expiresAt" epoch which should be configured as the table's TTL attribute.
+type DynamoAuthInvalidationStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// MakeDynamoAuthInvalidationStore creates a store which reads and writes table.
+func MakeDynamoAuthInvalidationStore(table string) (*DynamoAuthInvalidationStore, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("error loading default aws config: %w", err)
+	}
+
+	return &DynamoAuthInvalidationStore{
+		client: dynamodb.NewFromConfig(cfg),
+		table:  table,
+	}, nil
+}
+
+func (d *DynamoAuthInvalidationStore) InvalidatedAt(ctx context.Context, discordId string) (time.Time, error) {
+	out, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            map[string]types.AttributeValue{"discordId": &types.AttributeValueMemberS{Value: discordId}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get auth invalidation: %s: %w", discordId, err)
+	}
+
+	value, ok := out.Item["invalidatedAt"].(*types.AttributeValueMemberN)
+	if !ok {
+		return time.Time{}, nil
+	}
+
+	nanos, err := strconv.ParseInt(value.Value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid auth invalidation for user: %s: %w", discordId, err)
+	}
+
+	return time.Unix(0, nanos), nil
+}
+
+func (d *DynamoAuthInvalidationStore) Invalidate(ctx context.Context, discordId string, at time.Time) error {
+	_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]types.AttributeValue{
+			"discordId":     &types.AttributeValueMemberS{Value: discordId},
+			"invalidatedAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(at.UnixNano(), 10)},
+			"expiresAt":     &types.AttributeValueMemberN{Value: strconv.FormatInt(at.Add(authInvalidationRetention).Unix(), 10)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put auth invalidation: %s: %w", discordId, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/cbartram/hearthhub/src/model"
+	"testing"
+	"time"
+)
+
+// memoryAuthInvalidationStore is an AuthInvalidationStore shared by the caches of a test.
+type memoryAuthInvalidationStore struct {
+	invalidatedAt map[string]time.Time
+	err           error
+}
+
+func (m *memoryAuthInvalidationStore) InvalidatedAt(ctx context.Context, discordId string) (time.Time, error) {
+	return m.invalidatedAt[discordId], m.err
+}
+
+func (m *memoryAuthInvalidationStore) Invalidate(ctx context.Context, discordId string, at time.Time) error {
+	m.invalidatedAt[discordId] = at
+	return m.err
+}
+
+func makeTestAuthCache(shared AuthInvalidationStore) *AuthCache {
+	return &AuthCache{local: MakeLRUAuthCache(defaultAuthCacheSize), shared: shared}
+}
+
+func makeTestCognitoUser(discordId string) *model.CognitoUser {
+	return &model.CognitoUser{
+		DiscordID: discordId,
+		Credentials: model.CognitoCredentials{
+			RefreshToken:    "refresh-" + discordId,
+			AccessToken:     "access-" + discordId,
+			TokenExpiration: 3600,
+		},
+	}
+}
+
+func TestAuthCacheGet(t *testing.T) {
+	ctx := context.Background()
+	user := makeTestCognitoUser("1")
+
+	t.Run("cached identity is returned with the refresh token", func(t *testing.T) {
+		cache := makeTestAuthCache(nil)
+		cache.Put(user.Credentials.RefreshToken, user, time.Now())
+
+		got, ok := cache.Get(ctx, "1", user.Credentials.RefreshToken)
+		if !ok {
+			t.Fatal("Get() missed a cached identity")
+		}
+		if got.Credentials.AccessToken != user.Credentials.AccessToken || got.Credentials.RefreshToken != user.Credentials.RefreshToken {
+			t.Errorf("Get() credentials = %+v, want %+v", got.Credentials, user.Credentials)
+		}
+
+		entry := cache.local.Get(authCacheKey("1", user.Credentials.RefreshToken))
+		if entry.User.Credentials.RefreshToken != "" {
+			t.Error("refresh token was cached")
+		}
+	})
+
+	t.Run("another refresh token or user misses", func(t *testing.T) {
+		cache := makeTestAuthCache(nil)
+		cache.Put(user.Credentials.RefreshToken, user, time.Now())
+
+		if _, ok := cache.Get(ctx, "1", "another-token"); ok {
+			t.Error("Get() hit for another refresh token")
+		}
+		if _, ok := cache.Get(ctx, "2", user.Credentials.RefreshToken); ok {
+			t.Error("Get() hit for another user")
+		}
+	})
+
+	t.Run("local only entries expire after the local ttl", func(t *testing.T) {
+		cache := makeTestAuthCache(nil)
+		cache.Put(user.Credentials.RefreshToken, user, time.Now().Add(-authCacheLocalTTL))
+
+		if _, ok := cache.Get(ctx, "1", user.Credentials.RefreshToken); ok {
+			t.Error("Get() hit for an entry older than the local ttl")
+		}
+	})
+
+	t.Run("tokens about to expire are not cached", func(t *testing.T) {
+		cache := makeTestAuthCache(&memoryAuthInvalidationStore{invalidatedAt: map[string]time.Time{}})
+		expiring := makeTestCognitoUser("1")
+		expiring.Credentials.TokenExpiration = int32(authCacheExpiryMargin / time.Second)
+		cache.Put(expiring.Credentials.RefreshToken, expiring, time.Now())
+
+		if _, ok := cache.Get(ctx, "1", expiring.Credentials.RefreshToken); ok {
+			t.Error("Get() hit for a token within the expiry margin")
+		}
+	})
+
+	t.Run("local invalidation", func(t *testing.T) {
+		cache := makeTestAuthCache(nil)
+		other := makeTestCognitoUser("2")
+		cache.Put(user.Credentials.RefreshToken, user, time.Now())
+		cache.Put(other.Credentials.RefreshToken, other, time.Now())
+
+		cache.Invalidate(ctx, "1")
+		if _, ok := cache.Get(ctx, "1", user.Credentials.RefreshToken); ok {
+			t.Error("Get() hit after the user was invalidated")
+		}
+		if _, ok := cache.Get(ctx, "2", other.Credentials.RefreshToken); !ok {
+			t.Error("Get() missed another user after invalidating")
+		}
+	})
+}
+
+func TestAuthCacheSharedInvalidation(t *testing.T) {
+	ctx := context.Background()
+	user := makeTestCognitoUser("1")
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		cachedAt      time.Time
+		invalidatedAt time.Time
+		err           error
+		want          bool
+	}{
+		{name: "never invalidated", cachedAt: now, want: true},
+		{name: "cached after invalidation", cachedAt: now, invalidatedAt: now.Add(-authCacheClockSkew - time.Second), want: true},
+		{name: "cached before invalidation", cachedAt: now.Add(-time.Minute), invalidatedAt: now},
+		{name: "cached within the clock skew", cachedAt: now, invalidatedAt: now.Add(-authCacheClockSkew + time.Millisecond)},
+		{name: "invalidation store unavailable", cachedAt: now, err: errors.New("unavailable")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryAuthInvalidationStore{invalidatedAt: map[string]time.Time{}, err: tt.err}
+			if !tt.invalidatedAt.IsZero() {
+				store.invalidatedAt["1"] = tt.invalidatedAt
+			}
+
+			cache := makeTestAuthCache(store)
+			cache.Put(user.Credentials.RefreshToken, user, tt.cachedAt)
+
+			if _, ok := cache.Get(ctx, "1", user.Credentials.RefreshToken); ok != tt.want {
+				t.Errorf("Get() hit = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalidation on another instance", func(t *testing.T) {
+		store := &memoryAuthInvalidationStore{invalidatedAt: map[string]time.Time{}}
+		instance, other := makeTestAuthCache(store), makeTestAuthCache(store)
+
+		// Authentication started before the invalidation but finished after it, so it must not be served
+		authenticatedAt := time.Now()
+		other.Invalidate(ctx, "1")
+		instance.Put(user.Credentials.RefreshToken, user, authenticatedAt)
+
+		if _, ok := instance.Get(ctx, "1", user.Credentials.RefreshToken); ok {
+			t.Error("Get() hit for an identity authenticated before another instance invalidated it")
+		}
+		if entry := instance.local.Get(authCacheKey("1", user.Credentials.RefreshToken)); entry != nil {
+			t.Error("stale entry was kept after the shared invalidation rejected it")
+		}
+	})
+}
+
+func TestLRUAuthCacheEviction(t *testing.T) {
+	lru := MakeLRUAuthCache(2)
+	lru.Put("1", "a", AuthCacheEntry{})
+	lru.Put("1", "b", AuthCacheEntry{})
+
+	// Reading a makes b the least recently used entry
+	lru.Get("a")
+	lru.Put("2", "c", AuthCacheEntry{})
+
+	if lru.Get("b") != nil {
+		t.Error("least recently used entry was not evicted")
+	}
+	if lru.Get("a") == nil || lru.Get("c") == nil {
+		t.Error("recently used entries were evicted")
+	}
+
+	lru.Invalidate("1")
+	if lru.Get("a") != nil {
+		t.Error("entry is cached after its user was invalidated")
+	}
+	if _, ok := lru.byUser["1"]; ok {
+		t.Error("invalidated user is still indexed")
+	}
+	if lru.Get("c") == nil {
+		t.Error("another user's entry was invalidated")
+	}
+}
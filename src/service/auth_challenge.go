@@ -0,0 +1,119 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/cbartram/hearthhub/src/util"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	customChallengeName  = "CUSTOM_CHALLENGE"
+	authChallengeTTL     = 2 * time.Minute
+	challengeNonceParam  = "nonce"
+	challengeExpiryParam = "expires"
+)
+
+var ErrAuthChallengeSecretMissing = errors.New("AUTH_CHALLENGE_SECRET is not set")
+
+// AuthChallengeService implements Cognito's CUSTOM_AUTH flow. Cognito invokes the define, create and verify triggers
+// (RUN_MODE=auth-challenge) and the API answers the challenge with an HMAC of the user's Discord ID, the challenge
+// nonce and its expiry keyed by AUTH_CHALLENGE_SECRET. Only the API holds the secret and it only answers after the
+// caller has proven their Discord identity, so a session can no longer be minted from anything stored on the user.
+type AuthChallengeService struct {
+	secret []byte
+}
+
+// MakeAuthChallengeService creates a new auth challenge service keyed by AUTH_CHALLENGE_SECRET.
+func MakeAuthChallengeService() *AuthChallengeService {
+	return &AuthChallengeService{secret: []byte(os.Getenv("AUTH_CHALLENGE_SECRET"))}
+}
+
+// Answer signs a challenge issued to discordId.
+func (a *AuthChallengeService) Answer(discordId string, params map[string]string) (string, error) {
+	if len(a.secret) == 0 {
+		return "", ErrAuthChallengeSecretMissing
+	}
+
+	nonce, expires := params[challengeNonceParam], params[challengeExpiryParam]
+	if nonce == "" || expires == "" {
+		return "", errors.New("challenge is missing its nonce or expiry")
+	}
+
+	return a.sign(discordId, nonce, expires), nil
+}
+
+// DefineAuthChallenge issues a single custom challenge and tokens once it has been answered. There is no retry since
+// the API always answers correctly, a wrong answer means someone else is trying to answer for the user.
+func (a *AuthChallengeService) DefineAuthChallenge(event *events.CognitoEventUserPoolsDefineAuthChallenge) {
+	session := event.Request.Session
+	switch {
+	case event.Request.UserNotFound:
+		event.Response.FailAuthentication = true
+	case len(session) == 0:
+		event.Response.ChallengeName = customChallengeName
+	case len(session) == 1 && session[0].ChallengeName == customChallengeName && session[0].ChallengeResult:
+		event.Response.IssueTokens = true
+	default:
+		event.Response.FailAuthentication = true
+	}
+}
+
+// CreateAuthChallenge generates the nonce to be signed. It is returned publicly so the API can sign it and kept
+// privately so the verify trigger can check the signature.
+func (a *AuthChallengeService) CreateAuthChallenge(event *events.CognitoEventUserPoolsCreateAuthChallenge) error {
+	nonce, err := util.MakeCrypto().GenerateID(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate challenge nonce: %w", err)
+	}
+
+	params := map[string]string{
+		challengeNonceParam:  nonce,
+		challengeExpiryParam: strconv.FormatInt(time.Now().Add(authChallengeTTL).Unix(), 10),
+	}
+
+	event.Response.PublicChallengeParameters = params
+	event.Response.PrivateChallengeParameters = params
+	event.Response.ChallengeMetadata = "SIGNED_NONCE"
+	return nil
+}
+
+// VerifyAuthChallenge checks the answer is the signature of the challenge for this user and the challenge has not
+// expired.
+func (a *AuthChallengeService) VerifyAuthChallenge(event *events.CognitoEventUserPoolsVerifyAuthChallenge) {
+	event.Response.AnswerCorrect = false
+	if len(a.secret) == 0 {
+		log.Error(ErrAuthChallengeSecretMissing)
+		return
+	}
+
+	answer, _ := event.Request.ChallengeAnswer.(string)
+	nonce := event.Request.PrivateChallengeParameters[challengeNonceParam]
+	expires := event.Request.PrivateChallengeParameters[challengeExpiryParam]
+
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		log.Warnf("auth challenge for user: %s expired or malformed", event.UserName)
+		return
+	}
+
+	expected := a.sign(event.UserName, nonce, expires)
+	event.Response.AnswerCorrect = hmac.Equal([]byte(answer), []byte(expected))
+	if !event.Response.AnswerCorrect {
+		log.Warnf("invalid auth challenge answer for user: %s", event.UserName)
+	}
+}
+
+func (a *AuthChallengeService) sign(discordId, nonce, expires string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(strings.Join([]string{discordId, nonce, expires}, ".")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
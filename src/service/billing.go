@@ -0,0 +1,440 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStripeAPIBase     = "https://api.stripe.com"
+	stripeSignatureTolerance = 5 * time.Minute
+	billingPrefix            = "billing/"
+)
+
+var (
+	ErrInvalidStripeSignature = errors.New("invalid stripe signature")
+	ErrNoBillingAccount       = errors.New("user does not have a billing account, subscribe to a plan first")
+	ErrPlanNotPurchasable     = errors.New("plan must be one of: standard, premium")
+)
+
+// BillingService sells plans through Stripe Checkout and keeps each user's plan in sync with their Stripe
+// subscription through webhooks.
+type BillingService struct {
+	s3            *S3Service
+	entitlements  *EntitlementService
+	apiBase       string
+	secretKey     string
+	webhookSecret string
+	prices        map[string]string
+	successURL    string
+	cancelURL     string
+	returnURL     string
+	httpClient    *http.Client
+}
+
+// MakeBillingService creates a new billing service. STRIPE_API_BASE overrides the Stripe API so it can be pointed at
+// a local stub. STRIPE_PRICE_STANDARD and STRIPE_PRICE_PREMIUM are the Stripe price ids of each plan and
+// BILLING_SUCCESS_URL, BILLING_CANCEL_URL and BILLING_RETURN_URL are the web app pages Stripe sends users back to.
+func MakeBillingService(s3 *S3Service, entitlements *EntitlementService) *BillingService {
+	apiBase := strings.TrimSuffix(os.Getenv("STRIPE_API_BASE"), "/")
+	if apiBase == "" {
+		apiBase = defaultStripeAPIBase
+	}
+
+	prices := make(map[string]string)
+	if price := os.Getenv("STRIPE_PRICE_STANDARD"); price != "" {
+		prices[model.PlanStandard] = price
+	}
+	if price := os.Getenv("STRIPE_PRICE_PREMIUM"); price != "" {
+		prices[model.PlanPremium] = price
+	}
+
+	return &BillingService{
+		s3:            s3,
+		entitlements:  entitlements,
+		apiBase:       apiBase,
+		secretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+		webhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		prices:        prices,
+		successURL:    os.Getenv("BILLING_SUCCESS_URL"),
+		cancelURL:     os.Getenv("BILLING_CANCEL_URL"),
+		returnURL:     os.Getenv("BILLING_RETURN_URL"),
+		httpClient:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// CreateCheckoutSession returns the URL of a Stripe Checkout page where the user subscribes to plan. The user's
+// Discord ID is attached to the subscription so webhooks can be mapped back to them. Users who already have a
+// subscription are sent to the customer portal to change it instead so they are never subscribed twice.
+func (b *BillingService) CreateCheckoutSession(ctx context.Context, user *model.CognitoUser, plan string) (string, error) {
+	price, ok := b.prices[plan]
+	if !ok {
+		return "", ErrPlanNotPurchasable
+	}
+
+	form := url.Values{
+		"mode":                    {"subscription"},
+		"line_items[0][price]":    {price},
+		"line_items[0][quantity]": {"1"},
+		"success_url":             {b.successURL},
+		"cancel_url":              {b.cancelURL},
+		"client_reference_id":     {user.DiscordID},
+		"metadata[discord_id]":    {user.DiscordID},
+		"subscription_data[metadata][discord_id]": {user.DiscordID},
+	}
+
+	account, err := b.GetAccount(ctx, user.DiscordID)
+	if err != nil && !errors.Is(err, ErrNoBillingAccount) {
+		return "", err
+	}
+
+	if account != nil && account.SubscriptionID != "" && subscriptionActive(account.SubscriptionStatus) {
+		log.Infof("user: %s already has subscription: %s, sending them to the portal", user.DiscordID, account.SubscriptionID)
+		return b.CreatePortalSession(ctx, user.DiscordID)
+	}
+
+	if account != nil && account.CustomerID != "" {
+		form.Set("customer", account.CustomerID)
+	} else if user.Email != "" {
+		form.Set("customer_email", user.Email)
+	}
+
+	var session struct {
+		URL string `json:"url"`
+	}
+	if err = b.post(ctx, "/v1/checkout/sessions", form, &session); err != nil {
+		return "", err
+	}
+
+	return session.URL, nil
+}
+
+// CreatePortalSession returns the URL of the Stripe customer portal where the user manages their subscription.
+func (b *BillingService) CreatePortalSession(ctx context.Context, discordId string) (string, error) {
+	account, err := b.GetAccount(ctx, discordId)
+	if err != nil {
+		return "", err
+	}
+
+	var session struct {
+		URL string `json:"url"`
+	}
+	err = b.post(ctx, "/v1/billing_portal/sessions", url.Values{
+		"customer":   {account.CustomerID},
+		"return_url": {b.returnURL},
+	}, &session)
+	if err != nil {
+		return "", err
+	}
+
+	return session.URL, nil
+}
+
+// GetAccount returns the billing account of a user or ErrNoBillingAccount when they have never subscribed.
+func (b *BillingService) GetAccount(ctx context.Context, discordId string) (*model.BillingAccount, error) {
+	var account model.BillingAccount
+	err := b.s3.GetJSON(ctx, billingAccountKey(discordId), &account)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, ErrNoBillingAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// CancelSubscription immediately cancels the user's Stripe subscription so they are no longer charged. Users without
+// a subscription, or whose subscription has already ended, are left alone.
+func (b *BillingService) CancelSubscription(ctx context.Context, discordId string) error {
+	account, err := b.GetAccount(ctx, discordId)
+	if errors.Is(err, ErrNoBillingAccount) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if account.SubscriptionID == "" || account.SubscriptionStatus == "canceled" || account.SubscriptionStatus == "incomplete_expired" {
+		return nil
+	}
+
+	var subscription model.StripeSubscription
+	err = b.send(ctx, http.MethodDelete, "/v1/subscriptions/"+url.PathEscape(account.SubscriptionID), url.Values{}, &subscription)
+	if err != nil {
+		return fmt.Errorf("failed to cancel subscription: %s: %w", account.SubscriptionID, err)
+	}
+
+	log.Infof("cancelled subscription: %s for user: %s", account.SubscriptionID, discordId)
+	return nil
+}
+
+// VerifySignature checks the Stripe-Signature header of a webhook. The signature is an HMAC-SHA256 of the timestamp
+// and payload keyed by the webhook secret and the timestamp must be within the tolerance to prevent replays.
+func (b *BillingService) VerifySignature(header string, payload []byte) error {
+	if b.webhookSecret == "" {
+		return fmt.Errorf("%w: webhook secret is not configured", ErrInvalidStripeSignature)
+	}
+
+	var timestamp string
+	signatures := make([]string, 0)
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("%w: malformed header", ErrInvalidStripeSignature)
+	}
+
+	age := time.Since(time.Unix(ts, 0))
+	if age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return fmt.Errorf("%w: timestamp outside of tolerance", ErrInvalidStripeSignature)
+	}
+
+	mac := hmac.New(sha256.New, []byte(b.webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for _, signature := range signatures {
+		given, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(given, expected) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: no matching signature", ErrInvalidStripeSignature)
+}
+
+// HandleEvent applies a verified webhook event. Events which have already been processed are skipped since Stripe
+// delivers events at least once.
+func (b *BillingService) HandleEvent(ctx context.Context, event *model.StripeEvent) error {
+	processed, err := b.s3.ObjectExists(ctx, billingEventKey(event.ID))
+	if err != nil {
+		return err
+	}
+
+	if processed {
+		log.Infof("skipping stripe event: %s which has already been processed", event.ID)
+		return nil
+	}
+
+	switch event.Type {
+	case model.StripeEventSubscriptionCreated, model.StripeEventSubscriptionUpdated, model.StripeEventSubscriptionDeleted:
+		var subscription model.StripeSubscription
+		if err = json.Unmarshal(event.Data.Object, &subscription); err != nil {
+			return fmt.Errorf("failed to decode subscription: %w", err)
+		}
+		err = b.applySubscription(ctx, event, &subscription)
+	case model.StripeEventInvoicePaymentFailed:
+		var invoice model.StripeInvoice
+		if err = json.Unmarshal(event.Data.Object, &invoice); err != nil {
+			return fmt.Errorf("failed to decode invoice: %w", err)
+		}
+		err = b.recordPaymentFailed(ctx, &invoice)
+	default:
+		log.Infof("ignoring stripe event: %s of type: %s", event.ID, event.Type)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return b.s3.PutJSON(ctx, billingEventKey(event.ID), map[string]any{
+		"type":        event.Type,
+		"processedAt": time.Now().UTC(),
+	})
+}
+
+// applySubscription sets the user's plan from the price of their subscription. Active and trialing subscriptions
+// grant the plan, past due subscriptions keep it while Stripe retries the payment and everything else, including a
+// deleted subscription, returns the user to the free plan.
+// Stripe does not guarantee ordering so events older than the last one applied to the account are ignored, as are
+// events for any other subscription while the account's own subscription is active.
+func (b *BillingService) applySubscription(ctx context.Context, event *model.StripeEvent, subscription *model.StripeSubscription) error {
+	discordId, err := b.resolveUser(ctx, subscription.Metadata["discord_id"], subscription.Customer)
+	if err != nil {
+		return err
+	}
+
+	account, err := b.GetAccount(ctx, discordId)
+	if errors.Is(err, ErrNoBillingAccount) {
+		account = &model.BillingAccount{DiscordID: discordId}
+	} else if err != nil {
+		return err
+	}
+
+	if event.Created < account.LastEventCreated {
+		log.Infof("ignoring out of order stripe event: %s for user: %s", event.ID, discordId)
+		return nil
+	}
+
+	// A second subscription must not replace the plan of the one the account is on, for example when an old
+	// subscription is cancelled after the user moved to a new one
+	if account.SubscriptionID != "" && subscription.ID != account.SubscriptionID && subscriptionActive(account.SubscriptionStatus) {
+		log.Infof("ignoring stripe event: %s for subscription: %s of user: %s who is on subscription: %s", event.ID, subscription.ID, discordId, account.SubscriptionID)
+		return nil
+	}
+
+	plan := model.PlanFree
+	if event.Type != model.StripeEventSubscriptionDeleted && subscriptionActive(subscription.Status) {
+		plan = b.planForSubscription(subscription)
+	}
+
+	account.CustomerID = subscription.Customer
+	account.SubscriptionID = subscription.ID
+	account.SubscriptionStatus = subscription.Status
+	account.Plan = plan
+	account.LastEventCreated = event.Created
+	account.UpdatedAt = time.Now().UTC()
+
+	if err = b.saveAccount(ctx, account); err != nil {
+		return err
+	}
+
+	log.Infof("stripe %s: setting plan of user: %s to: %s (status: %s)", event.Type, discordId, plan, subscription.Status)
+	return b.entitlements.SetPlan(ctx, discordId, plan)
+}
+
+// recordPaymentFailed records a failed payment. The plan is left alone since Stripe retries the payment and sends a
+// subscription update or deletion if it keeps failing.
+func (b *BillingService) recordPaymentFailed(ctx context.Context, invoice *model.StripeInvoice) error {
+	discordId, err := b.resolveUser(ctx, "", invoice.Customer)
+	if err != nil {
+		return err
+	}
+
+	account, err := b.GetAccount(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	log.Warnf("payment failed for invoice: %s of user: %s", invoice.ID, discordId)
+	account.LastPaymentFailed = time.Now().UTC()
+	account.UpdatedAt = account.LastPaymentFailed
+	return b.saveAccount(ctx, account)
+}
+
+// subscriptionActive reports whether a subscription with status grants its plan. Past due subscriptions keep it
+// while Stripe retries the payment.
+func subscriptionActive(status string) bool {
+	switch status {
+	case "active", "trialing", "past_due":
+		return true
+	}
+	return false
+}
+
+func (b *BillingService) planForSubscription(subscription *model.StripeSubscription) string {
+	for _, item := range subscription.Items.Data {
+		for plan, price := range b.prices {
+			if item.Price.ID == price {
+				return plan
+			}
+		}
+	}
+
+	log.Warnf("subscription: %s has no price matching a plan", subscription.ID)
+	return model.PlanFree
+}
+
+// resolveUser maps a Stripe customer to a user, preferring the Discord ID attached to the subscription metadata.
+func (b *BillingService) resolveUser(ctx context.Context, discordId, customerId string) (string, error) {
+	if discordId != "" {
+		return discordId, nil
+	}
+
+	var index struct {
+		DiscordID string `json:"discordId"`
+	}
+	if err := b.s3.GetJSON(ctx, billingCustomerKey(customerId), &index); err != nil {
+		return "", fmt.Errorf("failed to find user for stripe customer: %s: %w", customerId, err)
+	}
+
+	return index.DiscordID, nil
+}
+
+func (b *BillingService) saveAccount(ctx context.Context, account *model.BillingAccount) error {
+	if err := b.s3.PutJSON(ctx, billingAccountKey(account.DiscordID), account); err != nil {
+		return err
+	}
+
+	return b.s3.PutJSON(ctx, billingCustomerKey(account.CustomerID), map[string]string{"discordId": account.DiscordID})
+}
+
+// post sends a form encoded request to the Stripe API and decodes the response into v.
+func (b *BillingService) post(ctx context.Context, path string, form url.Values, v any) error {
+	return b.send(ctx, http.MethodPost, path, form, v)
+}
+
+// send sends a form encoded request with method to the Stripe API and decodes the response into v.
+func (b *BillingService) send(ctx context.Context, method, path string, form url.Values, v any) error {
+	if b.secretKey == "" {
+		return fmt.Errorf("missing required environment variable: STRIPE_SECRET_KEY")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, b.apiBase+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create stripe request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+b.secretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call stripe: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read stripe response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var stripeErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(body, &stripeErr)
+		return fmt.Errorf("stripe returned status %d: %s", resp.StatusCode, stripeErr.Error.Message)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func billingAccountKey(discordId string) string {
+	return fmt.Sprintf("%saccounts/%s.json", billingPrefix, discordId)
+}
+
+func billingCustomerKey(customerId string) string {
+	return fmt.Sprintf("%scustomers/%s.json", billingPrefix, customerId)
+}
+
+func billingEventKey(eventId string) string {
+	return fmt.Sprintf("%sevents/%s.json", billingPrefix, eventId)
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func stripeSignature(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp)))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	const secret = "whsec_test"
+	payload := []byte(`{"id":"evt_1","type":"customer.subscription.updated"}`)
+	now := time.Now().Unix()
+	valid := stripeSignature(secret, now, payload)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		wantErr bool
+	}{
+		{name: "valid", secret: secret, header: fmt.Sprintf("t=%d,v1=%s", now, valid)},
+		{name: "valid with spaces", secret: secret, header: fmt.Sprintf("t=%d, v1=%s", now, valid)},
+		{name: "within tolerance", secret: secret, header: fmt.Sprintf("t=%d,v1=%s", now-240, stripeSignature(secret, now-240, payload))},
+		{name: "too old", secret: secret, header: fmt.Sprintf("t=%d,v1=%s", now-600, stripeSignature(secret, now-600, payload)), wantErr: true},
+		{name: "too far in the future", secret: secret, header: fmt.Sprintf("t=%d,v1=%s", now+600, stripeSignature(secret, now+600, payload)), wantErr: true},
+		{name: "matching signature among several", secret: secret, header: fmt.Sprintf("t=%d,v1=%s,v1=%s,v0=%s", now, stripeSignature("whsec_old", now, payload), valid, valid)},
+		{name: "only v0 signatures", secret: secret, header: fmt.Sprintf("t=%d,v0=%s", now, valid), wantErr: true},
+		{name: "wrong secret", secret: secret, header: fmt.Sprintf("t=%d,v1=%s", now, stripeSignature("whsec_other", now, payload)), wantErr: true},
+		{name: "signature for another timestamp", secret: secret, header: fmt.Sprintf("t=%d,v1=%s", now, stripeSignature(secret, now-1, payload)), wantErr: true},
+		{name: "bad hex", secret: secret, header: fmt.Sprintf("t=%d,v1=not-hex", now), wantErr: true},
+		{name: "bad hex before a valid signature", secret: secret, header: fmt.Sprintf("t=%d,v1=zz,v1=%s", now, valid)},
+		{name: "missing timestamp", secret: secret, header: "v1=" + valid, wantErr: true},
+		{name: "malformed timestamp", secret: secret, header: "t=yesterday,v1=" + valid, wantErr: true},
+		{name: "empty header", secret: secret, header: "", wantErr: true},
+		{name: "no webhook secret", secret: "", header: fmt.Sprintf("t=%d,v1=%s", now, valid), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			billing := &BillingService{webhookSecret: tt.secret}
+			err := billing.VerifySignature(tt.header, payload)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidStripeSignature) {
+					t.Errorf("VerifySignature() error = %v, want %v", err, ErrInvalidStripeSignature)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("VerifySignature() error = %v", err)
+			}
+		})
+	}
+}
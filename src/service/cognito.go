@@ -14,6 +14,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // CognitoAuthManager handles AWS Cognito authentication operations
@@ -23,6 +24,11 @@ type CognitoService struct {
 	clientID      string
 	clientSecret  string
 	configPath    string
+	challenges    *AuthChallengeService
+	secrets       *SecretService
+	sessions      *SessionService
+	state         UserStateRepository
+	authCache     *AuthCache
 }
 
 // SessionData represents locally stored session information
@@ -37,13 +43,31 @@ func MakeCognitoService() *CognitoService {
 		log.Errorf("error loading default aws config: %s", err)
 	}
 
-	return &CognitoService{
+	state, err := MakeUserStateRepository()
+	if err != nil {
+		log.Errorf("user state is unavailable: %v", err)
+		state = unavailableUserStateRepository{err: err}
+	}
+
+	cognito := &CognitoService{
 		cognitoClient: cognitoidentityprovider.NewFromConfig(cfg),
 		userPoolID:    os.Getenv("USER_POOL_ID"),
 		clientID:      os.Getenv("COGNITO_CLIENT_ID"),
 		clientSecret:  os.Getenv("COGNITO_CLIENT_SECRET"),
 		configPath:    filepath.Join(os.Getenv("HOME"), ".config", "your-app", "session.json"),
+		challenges:    MakeAuthChallengeService(),
+		state:         state,
+		authCache:     MakeAuthCache(),
+	}
+
+	if s3, err := MakeS3Service("us-east-1"); err == nil {
+		cognito.secrets = MakeSecretService(s3)
+		cognito.sessions = MakeSessionService(s3, cognito.secrets, cognito.revokeToken)
+	} else {
+		log.Errorf("failed to create s3 client for secrets: %v", err)
 	}
+
+	return cognito
 }
 
 func (m *CognitoService) GetUserAttributes(ctx context.Context, accessToken *string) ([]types.AttributeType, error) {
@@ -71,6 +95,74 @@ func (m *CognitoService) UpdateUserAttributes(ctx context.Context, accessToken *
 	return nil
 }
 
+// AdminUpdateUserAttributes Updates attributes of a user without their access token. It is used when a change is
+// initiated by a third party, such as a Steam OpenID callback, rather than the user's own session.
+func (m *CognitoService) AdminUpdateUserAttributes(ctx context.Context, discordId string, attributes []types.AttributeType) error {
+	_, err := m.cognitoClient.AdminUpdateUserAttributes(ctx, &cognitoidentityprovider.AdminUpdateUserAttributesInput{
+		UserPoolId:     aws.String(m.userPoolID),
+		Username:       aws.String(discordId),
+		UserAttributes: attributes,
+	})
+
+	if err != nil {
+		log.Errorf("could not update attributes for user: %s: %s", discordId, err.Error())
+		return fmt.Errorf("could not update attributes for user: %s", discordId)
+	}
+
+	return nil
+}
+
+// AdminDeleteUserAttributes Removes attributes from a user.
+func (m *CognitoService) AdminDeleteUserAttributes(ctx context.Context, discordId string, names []string) error {
+	_, err := m.cognitoClient.AdminDeleteUserAttributes(ctx, &cognitoidentityprovider.AdminDeleteUserAttributesInput{
+		UserPoolId:         aws.String(m.userPoolID),
+		Username:           aws.String(discordId),
+		UserAttributeNames: names,
+	})
+
+	if err != nil {
+		log.Errorf("could not delete attributes for user: %s: %s", discordId, err.Error())
+		return fmt.Errorf("could not delete attributes for user: %s", discordId)
+	}
+
+	return nil
+}
+
+// ListUsers Returns a page of users matching filter, which uses the Cognito ListUsers filter syntax, along with the
+// token for the next page. The token is empty on the last page. Users are returned without credentials.
+func (m *CognitoService) ListUsers(ctx context.Context, filter string, limit int32, paginationToken string) ([]model.CognitoUser, string, error) {
+	input := &cognitoidentityprovider.ListUsersInput{
+		UserPoolId: aws.String(m.userPoolID),
+		Limit:      aws.Int32(limit),
+	}
+
+	if filter != "" {
+		input.Filter = aws.String(filter)
+	}
+
+	if paginationToken != "" {
+		input.PaginationToken = aws.String(paginationToken)
+	}
+
+	output, err := m.cognitoClient.ListUsers(ctx, input)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list users: %w", err)
+	}
+
+	users := make([]model.CognitoUser, 0, len(output.Users))
+	for _, u := range output.Users {
+		user, _, err := DecodeUserAttributes(u.Attributes)
+		if err != nil {
+			log.Warnf("user: %s has invalid attributes: %v", aws.ToString(u.Username), err)
+		}
+
+		user.AccountEnabled = u.Enabled
+		users = append(users, *user)
+	}
+
+	return users, aws.ToString(output.PaginationToken), nil
+}
+
 func (m *CognitoService) GetUser(ctx context.Context, discordId *string) (*model.CognitoUser, error) {
 	user, err := m.cognitoClient.AdminGetUser(ctx, &cognitoidentityprovider.AdminGetUserInput{
 		UserPoolId: aws.String(m.userPoolID),
@@ -78,55 +170,165 @@ func (m *CognitoService) GetUser(ctx context.Context, discordId *string) (*model
 	})
 
 	if err != nil {
-		log.Errorf("no user exists with username: %s", *discordId, err.Error())
+		log.Errorf("no user exists with username: %s: %s", *discordId, err.Error())
 		return nil, errors.New("could not get user with username: " + *discordId)
 	}
 
-	var email, discordID, discordUsername, cognitoID, avatarID, installedModsStr, installedBackupsStr string
-	for _, attr := range user.UserAttributes {
-		switch aws.ToString(attr.Name) {
-		case "email":
-			email = aws.ToString(attr.Value)
-		case "sub":
-			cognitoID = aws.ToString(attr.Value)
-		case "custom:discord_id":
-			discordID = aws.ToString(attr.Value)
-		case "custom:discord_username":
-			discordUsername = aws.ToString(attr.Value)
-		case "custom:avatar_id":
-			avatarID = aws.ToString(attr.Value)
-
-		// installed mods will be a json string stored when the mod is actually persisted to the pvc
-		// by the hearthhub-file manager.
-		case "custom:installed_mods":
-			installedModsStr = aws.ToString(attr.Value)
-		case "custom:installed_backups":
-			installedBackupsStr = aws.ToString(attr.Value)
-		}
+	cognitoUser, err := m.decodeUser(ctx, *discordId, user.UserAttributes)
+	if err != nil {
+		return nil, err
 	}
 
-	var installedMods map[string]bool
-	var installedBackups map[string]bool
-	err = json.Unmarshal([]byte(installedModsStr), &installedMods)
-	err = json.Unmarshal([]byte(installedBackupsStr), &installedBackups)
+	state, err := m.loadUserState(ctx, *discordId, user.UserAttributes)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("failed to unmarshall installed file from str: %s", installedModsStr))
+		return nil, err
 	}
 
 	// Note: This method does not return credentials with the user
-	return &model.CognitoUser{
-		DiscordUsername:  discordUsername,
-		DiscordID:        discordID,
-		Email:            email,
-		CognitoID:        cognitoID,
-		AvatarId:         avatarID,
-		AccountEnabled:   user.Enabled,
-		InstalledMods:    installedMods,
-		InstalledBackups: installedBackups,
-	}, nil
+	cognitoUser.AccountEnabled = user.Enabled
+	cognitoUser.InstalledMods = state.InstalledMods
+	cognitoUser.InstalledBackups = state.InstalledBackups
+	return cognitoUser, nil
+}
+
+// UpdateUser Applies update to the user and writes only the attributes it changed.
+func (m *CognitoService) UpdateUser(ctx context.Context, discordId string, update func(user *model.CognitoUser)) (*model.CognitoUser, error) {
+	before, err := m.GetUser(ctx, &discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	after := *before
+	update(&after)
+
+	set, remove := EncodeUserChanges(before, &after)
+	defer m.authCache.Invalidate(ctx, discordId)
+	if err = m.AdminUpdateUserAttributes(ctx, discordId, set); err != nil {
+		return nil, err
+	}
+
+	if len(remove) > 0 {
+		if err = m.AdminDeleteUserAttributes(ctx, discordId, remove); err != nil {
+			return nil, err
+		}
+	}
+
+	return &after, nil
+}
+
+// SyncDiscordProfile Updates the user's stored Discord username and avatar to match their current Discord profile.
+// It returns the user and the attributes which changed, nothing is written when neither did.
+func (m *CognitoService) SyncDiscordProfile(ctx context.Context, discordId string, profile *UserResponse) (*model.CognitoUser, []string, error) {
+	if profile.ID != discordId {
+		return nil, nil, fmt.Errorf("discord profile: %s does not belong to user: %s", profile.ID, discordId)
+	}
+
+	user, err := m.GetUser(ctx, &discordId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var changed []string
+	if user.DiscordUsername != profile.Username {
+		changed = append(changed, "discord_username")
+	}
+	if user.AvatarId != profile.Avatar {
+		changed = append(changed, "avatar_id")
+	}
+
+	if len(changed) == 0 {
+		return user, nil, nil
+	}
+
+	updated, err := m.UpdateUser(ctx, discordId, func(user *model.CognitoUser) {
+		user.DiscordUsername = profile.Username
+		user.AvatarId = profile.Avatar
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return updated, changed, nil
+}
+
+// decodeUser Decodes a user's attributes and, when they were written by an older schema version, rewrites them in
+// the current format. A failed rewrite is retried on the next read so it does not fail this one.
+func (m *CognitoService) decodeUser(ctx context.Context, discordId string, attributes []types.AttributeType) (*model.CognitoUser, error) {
+	user, upgraded, err := DecodeUserAttributes(attributes)
+	if err != nil {
+		log.Errorf("failed to decode attributes for user: %s: %v", discordId, err)
+		return nil, fmt.Errorf("failed to decode user: %s: %w", discordId, err)
+	}
+
+	if upgraded {
+		set, _ := EncodeUserAttributes(user)
+		err = m.AdminUpdateUserAttributes(ctx, discordId, set)
+		if remove := legacyAttributesToRemove(attributes); err == nil && len(remove) > 0 {
+			err = m.AdminDeleteUserAttributes(ctx, discordId, remove)
+		}
+
+		if err != nil {
+			log.Warnf("failed to upgrade attributes for user: %s: %v", discordId, err)
+		} else {
+			log.Infof("upgraded attributes for user: %s to schema version: %d", discordId, CurrentSchemaVersion)
+		}
+	}
+
+	return user, nil
+}
+
+// GetLinkedSteamID Returns the Steam64 ID a user has linked to their account or an empty string when no Steam
+// account has been linked.
+func (m *CognitoService) GetLinkedSteamID(ctx context.Context, discordId string) (string, error) {
+	user, err := m.cognitoClient.AdminGetUser(ctx, &cognitoidentityprovider.AdminGetUserInput{
+		UserPoolId: aws.String(m.userPoolID),
+		Username:   aws.String(discordId),
+	})
+
+	if err != nil {
+		log.Errorf("could not get user with username: %s: error: %s", discordId, err.Error())
+		return "", errors.New("could not get user with username: " + discordId)
+	}
+
+	cognitoUser, _, err := DecodeUserAttributes(user.UserAttributes)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode user: %s: %w", discordId, err)
+	}
+
+	return cognitoUser.SteamID, nil
+}
+
+// IsAdmin Returns true when the user is a member of the Cognito group named by COGNITO_ADMIN_GROUP which defaults to
+// "admin".
+func (m *CognitoService) IsAdmin(ctx context.Context, discordId string) (bool, error) {
+	group := os.Getenv("COGNITO_ADMIN_GROUP")
+	if group == "" {
+		group = "admin"
+	}
+
+	paginator := cognitoidentityprovider.NewAdminListGroupsForUserPaginator(m.cognitoClient, &cognitoidentityprovider.AdminListGroupsForUserInput{
+		UserPoolId: aws.String(m.userPoolID),
+		Username:   aws.String(discordId),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to list groups for user: %s: %w", discordId, err)
+		}
+
+		for _, g := range page.Groups {
+			if aws.ToString(g.GroupName) == group {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
 }
 
 func (m *CognitoService) EnableUser(ctx context.Context, discordId string) bool {
+	defer m.authCache.Invalidate(ctx, discordId)
 	_, err := m.cognitoClient.AdminEnableUser(ctx, &cognitoidentityprovider.AdminEnableUserInput{
 		UserPoolId: aws.String(m.userPoolID),
 		Username:   aws.String(discordId),
@@ -139,6 +341,7 @@ func (m *CognitoService) EnableUser(ctx context.Context, discordId string) bool
 }
 
 func (m *CognitoService) DisableUser(ctx context.Context, discordId string) bool {
+	defer m.authCache.Invalidate(ctx, discordId)
 	_, err := m.cognitoClient.AdminDisableUser(ctx, &cognitoidentityprovider.AdminDisableUserInput{
 		UserPoolId: aws.String(m.userPoolID),
 		Username:   aws.String(discordId),
@@ -150,6 +353,154 @@ func (m *CognitoService) DisableUser(ctx context.Context, discordId string) bool
 	return true
 }
 
+// SetAdminDisabled Disables or re-enables a user on behalf of an admin. The choice is kept in the user's state so
+// it survives the user signing in again, which otherwise re-enables their account.
+func (m *CognitoService) SetAdminDisabled(ctx context.Context, discordId string, disabled bool) error {
+	_, err := m.UpdateUserState(ctx, discordId, func(state *model.UserState) error {
+		state.AdminDisabled = disabled
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update state for user: %s: %w", discordId, err)
+	}
+
+	if disabled {
+		if !m.DisableUser(ctx, discordId) {
+			return fmt.Errorf("failed to disable user: %s", discordId)
+		}
+	} else if !m.EnableUser(ctx, discordId) {
+		return fmt.Errorf("failed to enable user: %s", discordId)
+	}
+
+	return nil
+}
+
+// IsAdminDisabled Returns true when an admin has disabled the user. Such users must not be re-enabled by signing in.
+func (m *CognitoService) IsAdminDisabled(ctx context.Context, discordId string) (bool, error) {
+	state, err := m.state.Get(ctx, discordId)
+	if errors.Is(err, ErrUserStateNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return state.AdminDisabled, nil
+}
+
+// GlobalSignOut Revokes every refresh token issued to a user forcing all of their sessions to sign in again.
+func (m *CognitoService) GlobalSignOut(ctx context.Context, discordId string) error {
+	defer m.authCache.Invalidate(ctx, discordId)
+	_, err := m.cognitoClient.AdminUserGlobalSignOut(ctx, &cognitoidentityprovider.AdminUserGlobalSignOutInput{
+		UserPoolId: aws.String(m.userPoolID),
+		Username:   aws.String(discordId),
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to sign out user: %s: %w", discordId, err)
+	}
+
+	if m.sessions != nil {
+		return m.sessions.RevokeAll(ctx, discordId)
+	}
+
+	return nil
+}
+
+// ListSessions Returns the user's sessions which have not been revoked.
+func (m *CognitoService) ListSessions(ctx context.Context, discordId string) ([]model.Session, error) {
+	if m.sessions == nil {
+		return nil, errors.New("sessions are unavailable")
+	}
+	return m.sessions.List(ctx, discordId)
+}
+
+// CreateSession Records a refresh token issued to the user along with the device and IP it was issued to.
+func (m *CognitoService) CreateSession(ctx context.Context, discordId, refreshToken string, meta model.RequestMeta) {
+	if m.sessions == nil {
+		return
+	}
+
+	if _, err := m.sessions.Create(ctx, discordId, refreshToken, meta); err != nil {
+		log.Errorf("failed to record session for user: %s: %v", discordId, err)
+	}
+}
+
+// TouchSession Updates when and where the session for refreshToken was last used.
+func (m *CognitoService) TouchSession(ctx context.Context, discordId, refreshToken string, meta model.RequestMeta) {
+	if m.sessions == nil {
+		return
+	}
+
+	if err := m.sessions.Touch(ctx, discordId, refreshToken, meta); err != nil {
+		log.Errorf("failed to update session for user: %s: %v", discordId, err)
+	}
+}
+
+// RevokeRefreshToken Revokes the session refreshToken belongs to, recording it first when it was issued before
+// sessions were recorded.
+func (m *CognitoService) RevokeRefreshToken(ctx context.Context, discordId, refreshToken string, meta model.RequestMeta) error {
+	m.TouchSession(ctx, discordId, refreshToken, meta)
+	return m.RevokeSession(ctx, discordId, SessionID(refreshToken))
+}
+
+// RevokeSession Revokes a single session of the user. It is rejected by AuthUser immediately and its refresh token,
+// along with the access tokens issued from it, is revoked with Cognito. It returns ErrSessionNotFound when the user
+// has no such active session.
+func (m *CognitoService) RevokeSession(ctx context.Context, discordId, sessionId string) error {
+	if m.sessions == nil {
+		return errors.New("sessions are unavailable")
+	}
+
+	defer m.authCache.Invalidate(ctx, discordId)
+	refreshToken, err := m.sessions.Revoke(ctx, discordId, sessionId)
+	if err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		log.Warnf("session: %s of user: %s has no stored token, it is only revoked locally", sessionId, discordId)
+		return nil
+	}
+
+	if err = m.revokeToken(ctx, refreshToken); err != nil {
+		return fmt.Errorf("failed to revoke token for session: %s: %w", sessionId, err)
+	}
+
+	return nil
+}
+
+// revokeToken Revokes a refresh token, along with the access tokens issued from it, with Cognito.
+func (m *CognitoService) revokeToken(ctx context.Context, refreshToken string) error {
+	_, err := m.cognitoClient.RevokeToken(ctx, &cognitoidentityprovider.RevokeTokenInput{
+		ClientId:     aws.String(m.clientID),
+		ClientSecret: aws.String(m.clientSecret),
+		Token:        aws.String(refreshToken),
+	})
+	return err
+}
+
+// DeleteUser Permanently deletes a user from the user pool.
+func (m *CognitoService) DeleteUser(ctx context.Context, discordId string) error {
+	defer m.authCache.Invalidate(ctx, discordId)
+	_, err := m.cognitoClient.AdminDeleteUser(ctx, &cognitoidentityprovider.AdminDeleteUserInput{
+		UserPoolId: aws.String(m.userPoolID),
+		Username:   aws.String(discordId),
+	})
+
+	var notFound *types.UserNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		return fmt.Errorf("failed to delete user: %s: %w", discordId, err)
+	}
+
+	if m.sessions != nil {
+		if err = m.sessions.Delete(ctx, discordId); err != nil {
+			return err
+		}
+	}
+
+	return m.state.Delete(ctx, discordId)
+}
+
 func (m *CognitoService) CreateCognitoUser(ctx context.Context, createUserPayload *model.CognitoCreateUserRequest) (*types.AuthenticationResultType, error) {
 	password, _ := util.MakeCrypto().GeneratePassword(util.PasswordConfig{
 		Length:         15,
@@ -159,44 +510,12 @@ func (m *CognitoService) CreateCognitoUser(ctx context.Context, createUserPayloa
 		RequireSpecial: true,
 	})
 
-	attributes := []types.AttributeType{
-		{
-			Name:  aws.String("email"),
-			Value: aws.String(createUserPayload.DiscordEmail),
-		},
-		{
-			Name:  aws.String("custom:discord_id"),
-			Value: aws.String(createUserPayload.DiscordID),
-		},
-		{
-			Name:  aws.String("custom:discord_username"),
-			Value: aws.String(createUserPayload.DiscordUsername),
-		},
-		{
-			Name:  aws.String("custom:avatar_id"),
-			Value: aws.String(createUserPayload.AvatarId),
-		},
-		{
-			Name:  aws.String("custom:temporary_password"),
-			Value: aws.String(password),
-		},
-		{
-			Name:  aws.String("custom:refresh_token"),
-			Value: aws.String("nil"),
-		},
-		{
-			Name:  aws.String("custom:server_details"),
-			Value: aws.String("nil"),
-		},
-		{
-			Name:  aws.String("custom:installed_mods"),
-			Value: aws.String("{}"),
-		},
-		{
-			Name:  aws.String("custom:installed_backups"),
-			Value: aws.String("{}"),
-		},
-	}
+	attributes, _ := EncodeUserAttributes(&model.CognitoUser{
+		Email:           createUserPayload.DiscordEmail,
+		DiscordID:       createUserPayload.DiscordID,
+		DiscordUsername: createUserPayload.DiscordUsername,
+		AvatarId:        createUserPayload.AvatarId,
+	})
 
 	_, err := m.cognitoClient.AdminCreateUser(ctx, &cognitoidentityprovider.AdminCreateUserInput{
 		UserPoolId:        aws.String(m.userPoolID),
@@ -210,8 +529,9 @@ func (m *CognitoService) CreateCognitoUser(ctx context.Context, createUserPayloa
 		return nil, fmt.Errorf("error creating user: %w", err)
 	}
 
-	// Set permanent password although users will never actually log in with a user/pass combo. The Kraken service will use the Cognito refresh token
-	// to try and get an access token for the user and authenticate with the access token.
+	// Set permanent password although users will never actually log in with a user/pass combo. Sessions are issued
+	// through the custom auth challenge so the password is discarded, it only moves the user out of
+	// FORCE_CHANGE_PASSWORD.
 	_, err = m.cognitoClient.AdminSetUserPassword(ctx, &cognitoidentityprovider.AdminSetUserPasswordInput{
 		UserPoolId: aws.String(m.userPoolID),
 		Username:   aws.String(createUserPayload.DiscordID),
@@ -222,69 +542,83 @@ func (m *CognitoService) CreateCognitoUser(ctx context.Context, createUserPayloa
 		return nil, fmt.Errorf("error setting permanent password: %w", err)
 	}
 
+	// A re-created user must not inherit the state of a previous account with the same discord id
+	if err = m.state.Delete(ctx, createUserPayload.DiscordID); err != nil {
+		return nil, err
+	}
+
 	// Initialize auth session
-	return m.initiateAuthUserPass(ctx, createUserPayload.DiscordID, password)
+	return m.initiateCustomAuth(ctx, createUserPayload.DiscordID)
 }
 
-// initiateAuthUserPass Happens when a user is initially created with the user pool and uses username + generated pass to login
-// The cognito refresh token and access token will be returned in the response along with the discord refresh and access
-// token.
-func (m *CognitoService) initiateAuthUserPass(ctx context.Context, discordID, password string) (*types.AuthenticationResultType, error) {
-	authParams := map[string]string{
-		"USERNAME":    discordID,
-		"PASSWORD":    password,
-		"SECRET_HASH": util.MakeCrypto().MakeCognitoSecretHash(discordID, m.clientID, m.clientSecret),
-	}
-
-	result, err := m.cognitoClient.AdminInitiateAuth(ctx, &cognitoidentityprovider.AdminInitiateAuthInput{
-		UserPoolId:     aws.String(m.userPoolID),
-		ClientId:       aws.String(m.clientID),
-		AuthFlow:       types.AuthFlowTypeAdminUserPasswordAuth,
-		AuthParameters: authParams,
+// initiateCustomAuth Issues a session for a user through the CUSTOM_AUTH flow by answering the signed challenge
+// created by the auth challenge triggers. Callers must have proven the user's Discord identity first. The cognito
+// refresh token and access token will be returned in the response. The app client must allow ALLOW_CUSTOM_AUTH.
+func (m *CognitoService) initiateCustomAuth(ctx context.Context, discordID string) (*types.AuthenticationResultType, error) {
+	secretHash := util.MakeCrypto().MakeCognitoSecretHash(discordID, m.clientID, m.clientSecret)
+	challenge, err := m.cognitoClient.AdminInitiateAuth(ctx, &cognitoidentityprovider.AdminInitiateAuthInput{
+		UserPoolId: aws.String(m.userPoolID),
+		ClientId:   aws.String(m.clientID),
+		AuthFlow:   types.AuthFlowTypeCustomAuth,
+		AuthParameters: map[string]string{
+			"USERNAME":    discordID,
+			"SECRET_HASH": secretHash,
+		},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error initiating admin user/pass auth with user pool: %w", err)
+		return nil, fmt.Errorf("error initiating custom auth with user pool: %w", err)
 	}
 
-	// Add refresh token as custom attribute. This enables admins to get credentials on behalf of a user when
-	// purchasing plugins through the Discord ticket system.
-	attributes := make([]types.AttributeType, 0)
-	attrName := "custom:refresh_token"
-	attributes = append(attributes, types.AttributeType{
-		Name:  &attrName,
-		Value: result.AuthenticationResult.RefreshToken,
-	})
+	if challenge.ChallengeName != types.ChallengeNameTypeCustomChallenge {
+		return nil, fmt.Errorf("unexpected auth challenge: %s", challenge.ChallengeName)
+	}
 
-	err = m.UpdateUserAttributes(ctx, result.AuthenticationResult.AccessToken, attributes)
+	answer, err := m.challenges.Answer(discordID, challenge.ChallengeParameters)
 	if err != nil {
 		return nil, err
 	}
 
-	return result.AuthenticationResult, nil
-}
-
-// RefreshSession This method is called when a refresh token is about to expire and a new one needs to be generated.
-// There is no direct way to get a new refresh token without a users password. Since we do not store the password we set
-// must reset the password and re-auth to get a new refresh token.
-func (m *CognitoService) RefreshSession(ctx context.Context, discordID string) (*model.CognitoCredentials, error) {
-	user, err := m.cognitoClient.AdminGetUser(ctx, &cognitoidentityprovider.AdminGetUserInput{
-		UserPoolId: aws.String(m.userPoolID),
-		Username:   &discordID,
+	result, err := m.cognitoClient.AdminRespondToAuthChallenge(ctx, &cognitoidentityprovider.AdminRespondToAuthChallengeInput{
+		UserPoolId:    aws.String(m.userPoolID),
+		ClientId:      aws.String(m.clientID),
+		ChallengeName: types.ChallengeNameTypeCustomChallenge,
+		Session:       challenge.Session,
+		ChallengeResponses: map[string]string{
+			"USERNAME":    discordID,
+			"ANSWER":      answer,
+			"SECRET_HASH": secretHash,
+		},
 	})
-
 	if err != nil {
-		log.Errorf("error: failed to get user attributes with for discord id: %s", discordID)
-		return nil, errors.New(fmt.Sprintf("error: failed to get user for discord id: %s", discordID))
+		return nil, fmt.Errorf("error responding to auth challenge: %w", err)
 	}
 
-	password := util.GetUserAttributeString(user.UserAttributes, "custom:temporary_password")
+	if result.AuthenticationResult == nil {
+		return nil, fmt.Errorf("auth challenge did not issue tokens, next challenge: %s", result.ChallengeName)
+	}
 
-	log.Infof("auth user: %s with password", discordID)
-	auth, err := m.initiateAuthUserPass(ctx, discordID, password)
+	// Store the encrypted refresh token. This enables admins to get credentials on behalf of a user when purchasing
+	// plugins through the Discord ticket system. The session is still returned if it cannot be stored.
+	if m.secrets != nil {
+		if err = m.secrets.Put(ctx, discordID, SecretRefreshToken, *result.AuthenticationResult.RefreshToken); err != nil {
+			log.Errorf("failed to store refresh token for user: %s: %v", discordID, err)
+		}
+	}
+
+	return result.AuthenticationResult, nil
+}
+
+// RefreshSession This method is called when a refresh token is about to expire and a new one needs to be generated.
+// There is no direct way to get a new refresh token from an old one so a new session is issued through the custom
+// auth challenge. Callers must have proven the user's Discord identity, or hold a valid session, first.
+func (m *CognitoService) RefreshSession(ctx context.Context, discordID string) (*model.CognitoCredentials, error) {
+	log.Infof("issuing session for user: %s with custom auth challenge", discordID)
+	defer m.authCache.Invalidate(ctx, discordID)
+	auth, err := m.initiateCustomAuth(ctx, discordID)
 
 	if err != nil {
-		log.Errorf("error: failed to auth with user/pass for discord id: %s", discordID)
-		return nil, errors.New(fmt.Sprintf("error: failed to auth with user/pass for discord id: %s", discordID))
+		log.Errorf("error: failed custom auth for discord id: %s: %v", discordID, err)
+		return nil, errors.New(fmt.Sprintf("error: failed to issue session for discord id: %s", discordID))
 	}
 
 	return &model.CognitoCredentials{
@@ -293,10 +627,125 @@ func (m *CognitoService) RefreshSession(ctx context.Context, discordID string) (
 		AccessToken:     *auth.AccessToken,
 		IdToken:         *auth.IdToken,
 	}, nil
+}
+
+// MigrateTemporaryPasswords Rotates the password of every user who still has one stored in the
+// custom:temporary_password attribute and clears the attribute. The new password is discarded since sessions are
+// issued through the custom auth challenge. It returns how many users were migrated and is safe to re-run.
+func (m *CognitoService) MigrateTemporaryPasswords(ctx context.Context) (int, error) {
+	migrated := 0
+	paginator := cognitoidentityprovider.NewListUsersPaginator(m.cognitoClient, &cognitoidentityprovider.ListUsersInput{
+		UserPoolId: aws.String(m.userPoolID),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to list users: %w", err)
+		}
 
+		for _, user := range page.Users {
+			if util.GetUserAttributeString(user.Attributes, "custom:temporary_password") == "" {
+				continue
+			}
+
+			password, err := util.MakeCrypto().GeneratePassword(util.PasswordConfig{
+				Length:         32,
+				RequireUpper:   true,
+				RequireLower:   true,
+				RequireNumber:  true,
+				RequireSpecial: true,
+			})
+			if err != nil {
+				return migrated, fmt.Errorf("failed to generate password: %w", err)
+			}
+
+			_, err = m.cognitoClient.AdminSetUserPassword(ctx, &cognitoidentityprovider.AdminSetUserPasswordInput{
+				UserPoolId: aws.String(m.userPoolID),
+				Username:   user.Username,
+				Password:   aws.String(password),
+				Permanent:  true,
+			})
+			if err != nil {
+				return migrated, fmt.Errorf("failed to rotate password for user: %s: %w", *user.Username, err)
+			}
+
+			if err = m.AdminDeleteUserAttributes(ctx, *user.Username, []string{"custom:temporary_password"}); err != nil {
+				return migrated, err
+			}
+
+			migrated++
+			log.Infof("rotated password and cleared temporary_password for user: %s", *user.Username)
+		}
+	}
+
+	return migrated, nil
 }
 
+// MigrateRefreshTokens Moves refresh tokens stored in plaintext in the custom:refresh_token attribute into the
+// encrypted secret store and removes the attribute. It returns how many users were migrated and is safe to re-run.
+func (m *CognitoService) MigrateRefreshTokens(ctx context.Context) (int, error) {
+	if m.secrets == nil {
+		return 0, errors.New("secret store is unavailable")
+	}
+
+	migrated := 0
+	paginator := cognitoidentityprovider.NewListUsersPaginator(m.cognitoClient, &cognitoidentityprovider.ListUsersInput{
+		UserPoolId: aws.String(m.userPoolID),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to list users: %w", err)
+		}
+
+		for _, user := range page.Users {
+			token := util.GetUserAttributeString(user.Attributes, "custom:refresh_token")
+			if token == "" || token == "nil" {
+				continue
+			}
+
+			if err = m.secrets.Put(ctx, *user.Username, SecretRefreshToken, token); err != nil {
+				return migrated, err
+			}
+
+			if err = m.AdminDeleteUserAttributes(ctx, *user.Username, []string{"custom:refresh_token"}); err != nil {
+				return migrated, err
+			}
+
+			migrated++
+			log.Infof("moved refresh token for user: %s into the secret store", *user.Username)
+		}
+	}
+
+	return migrated, nil
+}
+
+// AuthUser Authenticates a user with their refresh token. Revoked sessions are rejected. The resolved user is cached
+// until shortly before the issued access token expires so repeated requests do not reach Cognito. Enabling,
+// disabling, signing out, revoking a session or updating the user invalidates it on every instance once the change
+// is persisted, so a cache hit is never older than the last revocation.
 func (m *CognitoService) AuthUser(ctx context.Context, refreshToken, userId *string) (bool, *model.CognitoUser) {
+	if cached, ok := m.authCache.Get(ctx, *userId, *refreshToken); ok {
+		return true, cached
+	}
+
+	authenticatedAt := time.Now()
+
+	if m.sessions != nil {
+		revoked, err := m.sessions.IsRevoked(ctx, *userId, *refreshToken)
+		if err != nil {
+			log.Errorf("failed to check session of user: %s: %v", *userId, err)
+			return false, nil
+		}
+
+		if revoked {
+			log.Errorf("error auth: user %s presented a revoked session", *userId)
+			return false, nil
+		}
+	}
+
 	auth, err := m.cognitoClient.AdminInitiateAuth(ctx, &cognitoidentityprovider.AdminInitiateAuthInput{
 		UserPoolId: aws.String(m.userPoolID),
 		ClientId:   aws.String(m.clientID),
@@ -322,51 +771,130 @@ func (m *CognitoService) AuthUser(ctx context.Context, refreshToken, userId *str
 		return false, nil
 	}
 
-	var email, discordID, discordUsername, cognitoID, avatarID, installedModsStr, installedBackupsStr string
-	for _, attr := range user.UserAttributes {
-		switch aws.ToString(attr.Name) {
-		case "email":
-			email = aws.ToString(attr.Value)
-		case "sub":
-			cognitoID = aws.ToString(attr.Value)
-		case "custom:discord_id":
-			discordID = aws.ToString(attr.Value)
-		case "custom:discord_username":
-			discordUsername = aws.ToString(attr.Value)
-		case "custom:avatar_id":
-			avatarID = aws.ToString(attr.Value)
-		case "custom:installed_mods":
-			installedModsStr = aws.ToString(attr.Value)
-		case "custom:installed_backups":
-			installedBackupsStr = aws.ToString(attr.Value)
-		}
+	cognitoUser, err := m.decodeUser(ctx, *userId, user.UserAttributes)
+	if err != nil {
+		return false, nil
 	}
 
-	var installedMods map[string]bool
-	var installedBackups map[string]bool
-	err = json.Unmarshal([]byte(installedModsStr), &installedMods)
-	err = json.Unmarshal([]byte(installedBackupsStr), &installedBackups)
+	state, err := m.loadUserState(ctx, *userId, user.UserAttributes)
 	if err != nil {
-		log.Errorf("failed to unmarshall installed files from str: %s", installedModsStr)
+		log.Errorf("failed to load state for user: %s: %v", *userId, err)
 		return false, nil
 	}
 
 	// Note: we still authenticate a disabled user the service side handles updating UI/auth flows
 	// to re-auth with discord.
-	return true, &model.CognitoUser{
-		DiscordUsername:  discordUsername,
-		DiscordID:        discordID,
-		Email:            email,
-		CognitoID:        cognitoID,
-		AccountEnabled:   user.Enabled,
-		AvatarId:         avatarID,
-		InstalledMods:    installedMods,
-		InstalledBackups: installedBackups,
-		Credentials: model.CognitoCredentials{
-			AccessToken:     *auth.AuthenticationResult.AccessToken,
-			RefreshToken:    *refreshToken,
-			TokenExpiration: auth.AuthenticationResult.ExpiresIn,
-			IdToken:         *auth.AuthenticationResult.IdToken,
-		},
+	cognitoUser.AccountEnabled = user.Enabled
+	cognitoUser.InstalledMods = state.InstalledMods
+	cognitoUser.InstalledBackups = state.InstalledBackups
+	cognitoUser.Credentials = model.CognitoCredentials{
+		AccessToken:     *auth.AuthenticationResult.AccessToken,
+		RefreshToken:    *refreshToken,
+		TokenExpiration: auth.AuthenticationResult.ExpiresIn,
+		IdToken:         *auth.AuthenticationResult.IdToken,
 	}
+
+	m.authCache.Put(*refreshToken, cognitoUser, authenticatedAt)
+	return true, cognitoUser
+}
+
+// UpdateUserState Applies update to the user's installed mods, backups, server details and preferences with
+// optimistic concurrency.
+func (m *CognitoService) UpdateUserState(ctx context.Context, discordId string, update func(state *model.UserState) error) (*model.UserState, error) {
+	defer m.authCache.Invalidate(ctx, discordId)
+	return UpdateUserState(ctx, m.state, discordId, update)
+}
+
+// loadUserState Returns the user's state. Users whose state still lives in the legacy custom attributes have it
+// copied across the first time it is read.
+func (m *CognitoService) loadUserState(ctx context.Context, discordId string, attributes []types.AttributeType) (*model.UserState, error) {
+	state, err := m.state.Get(ctx, discordId)
+	if !errors.Is(err, ErrUserStateNotFound) {
+		return state, err
+	}
+
+	state, err = legacyUserState(discordId, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.state.Put(ctx, state)
+	if errors.Is(err, ErrUserStateConflict) {
+		// Another request migrated the user first
+		return m.state.Get(ctx, discordId)
+	}
+
+	return state, err
+}
+
+// MigrateUserState Copies the installed mods, backups and server details of every user out of their custom
+// attributes into the user state repository. Users who already have state are skipped so it is safe to re-run.
+func (m *CognitoService) MigrateUserState(ctx context.Context) (int, error) {
+	migrated := 0
+	paginator := cognitoidentityprovider.NewListUsersPaginator(m.cognitoClient, &cognitoidentityprovider.ListUsersInput{
+		UserPoolId: aws.String(m.userPoolID),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to list users: %w", err)
+		}
+
+		for _, user := range page.Users {
+			username := aws.ToString(user.Username)
+			if _, err = m.state.Get(ctx, username); !errors.Is(err, ErrUserStateNotFound) {
+				if err != nil {
+					return migrated, err
+				}
+				continue
+			}
+
+			state, err := legacyUserState(username, user.Attributes)
+			if err != nil {
+				log.Errorf("skipping user: %s: %v", username, err)
+				continue
+			}
+
+			if err = m.state.Put(ctx, state); err != nil && !errors.Is(err, ErrUserStateConflict) {
+				return migrated, err
+			}
+
+			migrated++
+		}
+	}
+
+	return migrated, nil
+}
+
+// legacyUserState Builds a user's state from the custom:installed_mods, custom:installed_backups and
+// custom:server_details attributes. Missing attributes and the "nil" placeholder are treated as empty.
+func legacyUserState(discordId string, attributes []types.AttributeType) (*model.UserState, error) {
+	state := NewUserState(discordId)
+
+	if raw := util.GetUserAttributeString(attributes, "custom:installed_mods"); raw != "" && raw != "nil" {
+		if err := json.Unmarshal([]byte(raw), &state.InstalledMods); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal custom:installed_mods: %w", err)
+		}
+	}
+
+	if raw := util.GetUserAttributeString(attributes, "custom:installed_backups"); raw != "" && raw != "nil" {
+		if err := json.Unmarshal([]byte(raw), &state.InstalledBackups); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal custom:installed_backups: %w", err)
+		}
+	}
+
+	if raw := util.GetUserAttributeString(attributes, "custom:server_details"); raw != "" && raw != "nil" && json.Valid([]byte(raw)) {
+		state.ServerDetails = json.RawMessage(raw)
+	}
+
+	// A stored "null" unmarshals to a nil map
+	if state.InstalledMods == nil {
+		state.InstalledMods = make(map[string]bool)
+	}
+	if state.InstalledBackups == nil {
+		state.InstalledBackups = make(map[string]bool)
+	}
+
+	return state, nil
 }
@@ -1,6 +1,10 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/cbartram/hearthhub/src/model"
@@ -9,7 +13,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -20,10 +26,13 @@ const (
 
 // DiscordClient handles OAuth2 authentication and API calls
 type DiscordService struct {
-	clientID     string
-	clientSecret string
-	redirectURI  string
-	httpClient   *http.Client
+	clientID      string
+	clientSecret  string
+	redirectURI   string
+	applicationID string
+	botToken      string
+	publicKey     ed25519.PublicKey
+	httpClient    *http.Client
 }
 
 // UserResponse represents the Discord user information
@@ -36,6 +45,12 @@ type UserResponse struct {
 	Verified      bool   `json:"verified"`
 }
 
+// GuildMember represents a user's membership in a Discord guild
+type GuildMember struct {
+	User  UserResponse `json:"user"`
+	Roles []string     `json:"roles"`
+}
+
 // MakeDiscordService creates a new Discord service
 func MakeDiscordService() (*DiscordService, error) {
 	clientID := os.Getenv("DISCORD_CLIENT_ID")
@@ -45,10 +60,20 @@ func MakeDiscordService() (*DiscordService, error) {
 		return nil, fmt.Errorf("missing required environment variables: CLIENT_ID, CLIENT_SECRET")
 	}
 
+	// The application id, bot token and public key are only needed for slash command interactions so they are optional
+	publicKey, err := hex.DecodeString(os.Getenv("DISCORD_PUBLIC_KEY"))
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		log.Warnf("DISCORD_PUBLIC_KEY is missing or invalid, discord interactions will be rejected")
+		publicKey = nil
+	}
+
 	return &DiscordService{
-		clientID:     clientID,
-		clientSecret: clientSecret,
-		httpClient:   &http.Client{},
+		clientID:      clientID,
+		clientSecret:  clientSecret,
+		applicationID: os.Getenv("DISCORD_APPLICATION_ID"),
+		botToken:      os.Getenv("DISCORD_BOT_TOKEN"),
+		publicKey:     publicKey,
+		httpClient:    &http.Client{},
 	}, nil
 }
 
@@ -123,3 +148,120 @@ func (c *DiscordService) GetUserInfo(accessToken string) (*UserResponse, error)
 
 	return &userResp, nil
 }
+
+// VerifyInteraction checks the X-Signature-Ed25519 and X-Signature-Timestamp headers Discord sends with every
+// interaction against the application's public key. Stale timestamps are rejected to limit replays.
+func (c *DiscordService) VerifyInteraction(signature, timestamp string, body []byte) bool {
+	if c.publicKey == nil || signature == "" || timestamp == "" {
+		return false
+	}
+
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > 5*time.Minute {
+		return false
+	}
+
+	return ed25519.Verify(c.publicKey, append([]byte(timestamp), body...), sig)
+}
+
+// RegisterCommands overwrites the application's global slash commands with the given definitions.
+func (c *DiscordService) RegisterCommands(ctx context.Context, commands []model.ApplicationCommand) error {
+	if c.applicationID == "" || c.botToken == "" {
+		return fmt.Errorf("missing required environment variables: DISCORD_APPLICATION_ID, DISCORD_BOT_TOKEN")
+	}
+
+	endpoint := fmt.Sprintf("%s/applications/%s/commands", discordAPIEndpoint, c.applicationID)
+	return c.send(ctx, http.MethodPut, endpoint, "Bot "+c.botToken, commands)
+}
+
+// EditOriginalResponse replaces the message of a deferred interaction response once a slow command has finished.
+func (c *DiscordService) EditOriginalResponse(ctx context.Context, interactionToken string, data model.InteractionResponseData) error {
+	endpoint := fmt.Sprintf("%s/webhooks/%s/%s/messages/@original", discordAPIEndpoint, c.applicationID, interactionToken)
+	return c.send(ctx, http.MethodPatch, endpoint, "", data)
+}
+
+func (c *DiscordService) send(ctx context.Context, method, endpoint, authorization string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	log.Infof("making %s request to: %s", method, endpoint)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("discord API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// GetGuildMember returns the authenticated user's membership in a guild using their OAuth access token which must
+// have been granted the guilds.members.read scope. A nil member is returned when the user is not in the guild.
+func (c *DiscordService) GetGuildMember(ctx context.Context, accessToken, guildId string) (*GuildMember, error) {
+	endpoint := fmt.Sprintf("%s/users/@me/guilds/%s/member", discordAPIEndpoint, guildId)
+	return c.getGuildMember(ctx, endpoint, "Bearer "+accessToken)
+}
+
+// GetGuildMemberAsBot returns a user's membership in a guild using the bot token. This allows membership to be
+// rechecked without the user's OAuth token but requires the bot to be a member of the guild.
+func (c *DiscordService) GetGuildMemberAsBot(ctx context.Context, guildId, userId string) (*GuildMember, error) {
+	if c.botToken == "" {
+		return nil, fmt.Errorf("missing required environment variable: DISCORD_BOT_TOKEN")
+	}
+
+	endpoint := fmt.Sprintf("%s/guilds/%s/members/%s", discordAPIEndpoint, guildId, userId)
+	return c.getGuildMember(ctx, endpoint, "Bot "+c.botToken)
+}
+
+func (c *DiscordService) getGuildMember(ctx context.Context, endpoint, authorization string) (*GuildMember, error) {
+	log.Infof("fetching guild member from: %s", endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating guild member request: %w", err)
+	}
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing guild member request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Discord returns 404 (Unknown Member / Unknown Guild) when the user is not in the guild
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("guild member request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var member GuildMember
+	if err := json.NewDecoder(resp.Body).Decode(&member); err != nil {
+		return nil, fmt.Errorf("decoding guild member response: %w", err)
+	}
+
+	return &member, nil
+}
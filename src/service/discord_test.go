@@ -0,0 +1,73 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyInteraction(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"type":1}`)
+	issued := time.Now()
+	now := strconv.FormatInt(issued.Unix(), 10)
+	stale := strconv.FormatInt(issued.Add(-10*time.Minute).Unix(), 10)
+	sign := func(key ed25519.PrivateKey, timestamp string, body []byte) string {
+		return hex.EncodeToString(ed25519.Sign(key, append([]byte(timestamp), body...)))
+	}
+	valid := sign(privateKey, now, body)
+
+	tests := []struct {
+		name      string
+		publicKey ed25519.PublicKey
+		signature string
+		timestamp string
+		body      []byte
+		want      bool
+	}{
+		{name: "valid", publicKey: publicKey, signature: valid, timestamp: now, body: body, want: true},
+		{name: "no public key", signature: valid, timestamp: now, body: body},
+		{name: "missing signature", publicKey: publicKey, timestamp: now, body: body},
+		{name: "missing timestamp", publicKey: publicKey, signature: valid, body: body},
+		{name: "signature is not hex", publicKey: publicKey, signature: "not-hex", timestamp: now, body: body},
+		{name: "truncated signature", publicKey: publicKey, signature: valid[:len(valid)-2], timestamp: now, body: body},
+		{name: "signed by another key", publicKey: publicKey, signature: sign(otherKey, now, body), timestamp: now, body: body},
+		{name: "tampered body", publicKey: publicKey, signature: valid, timestamp: now, body: []byte(`{"type":2}`)},
+		{name: "timestamp is not a number", publicKey: publicKey, signature: sign(privateKey, "yesterday", body), timestamp: "yesterday", body: body},
+		{
+			name:      "stale timestamp",
+			publicKey: publicKey,
+			signature: sign(privateKey, stale, body),
+			timestamp: stale,
+			body:      body,
+		},
+		{
+			name:      "signature for another timestamp",
+			publicKey: publicKey,
+			signature: sign(privateKey, strconv.FormatInt(issued.Unix()-1, 10), body),
+			timestamp: now,
+			body:      body,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discord := &DiscordService{publicKey: tt.publicKey}
+			if got := discord.VerifyInteraction(tt.signature, tt.timestamp, tt.body); got != tt.want {
+				t.Errorf("VerifyInteraction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/util"
+	"slices"
+	"time"
+)
+
+const (
+	entitlementsFile = "entitlements.json"
+	maxGrantDuration = 366 * 24 * time.Hour
+	gib              = int64(1) << 30
+
+	// serversPerOwner is how many servers each user owns. Servers are keyed by the Discord ID of their owner so it is
+	// currently always one.
+	serversPerOwner = 1
+)
+
+var (
+	// Plans are the limits of each plan ordered from lowest to highest.
+	Plans = map[string]model.PlanLimits{
+		model.PlanFree: {
+			MaxServers:        1,
+			StorageQuotaBytes: 1 * gib,
+			BackupRetention:   model.RetentionPolicy{KeepLast: 3, Hourly: 6, Daily: 7, Weekly: 4},
+		},
+		model.PlanStandard: {
+			MaxServers:        1,
+			StorageQuotaBytes: 10 * gib,
+			BackupRetention:   model.RetentionPolicy{KeepLast: 5, Hourly: 12, Daily: 14, Weekly: 4},
+			CustomModUploads:  true,
+		},
+		model.PlanPremium: {
+			MaxServers:        3,
+			StorageQuotaBytes: 50 * gib,
+			BackupRetention:   model.RetentionPolicy{KeepLast: 10, Hourly: 24, Daily: 14, Weekly: 8},
+			CustomModUploads:  true,
+		},
+	}
+
+	planRank = []string{model.PlanFree, model.PlanStandard, model.PlanPremium}
+
+	ErrGrantNotFound      = errors.New("entitlement grant does not exist")
+	ErrInvalidPlan        = errors.New("plan must be one of: free, standard, premium")
+	ErrFeatureNotEntitled = errors.New("your plan does not include this feature")
+	ErrServerLimitReached = errors.New("your plan does not include any more servers")
+)
+
+// EntitlementService is the single place where what a user is entitled to is decided. Every limit enforced by a
+// handler is read from Entitlements.
+type EntitlementService struct {
+	s3 *S3Service
+}
+
+// MakeEntitlementService creates a new entitlement service.
+func MakeEntitlementService(s3 *S3Service) *EntitlementService {
+	return &EntitlementService{s3: s3}
+}
+
+// Entitlements returns the effective plan and limits of a user. The highest plan of the user's paid plan and their
+// active grants is used and features unlocked by grants are added on top of it.
+func (e *EntitlementService) Entitlements(ctx context.Context, discordId string) (*model.Entitlements, error) {
+	record, err := e.getRecord(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entitlements := &model.Entitlements{
+		DiscordID: discordId,
+		BasePlan:  record.Plan,
+		Plan:      record.Plan,
+		Grants:    make([]model.EntitlementGrant, 0),
+	}
+
+	for _, grant := range record.Grants {
+		if !grant.ExpiresAt.After(now) {
+			continue
+		}
+
+		entitlements.Grants = append(entitlements.Grants, grant)
+		if grant.Plan != "" && slices.Index(planRank, grant.Plan) > slices.Index(planRank, entitlements.Plan) {
+			entitlements.Plan = grant.Plan
+		}
+	}
+
+	entitlements.Limits = Plans[entitlements.Plan]
+	for _, grant := range entitlements.Grants {
+		for _, feature := range grant.Features {
+			if feature == model.FeatureCustomModUploads {
+				entitlements.Limits.CustomModUploads = true
+			}
+		}
+	}
+
+	return entitlements, nil
+}
+
+// Require returns ErrFeatureNotEntitled unless the user is entitled to feature.
+func (e *EntitlementService) Require(ctx context.Context, discordId, feature string) error {
+	entitlements, err := e.Entitlements(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	if !entitlements.HasFeature(feature) {
+		return fmt.Errorf("%w: %s", ErrFeatureNotEntitled, feature)
+	}
+
+	return nil
+}
+
+// RequireServerCapacity returns ErrServerLimitReached when the user owns more servers than their plan allows.
+func (e *EntitlementService) RequireServerCapacity(ctx context.Context, discordId string) error {
+	entitlements, err := e.Entitlements(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	if serversPerOwner > entitlements.Limits.MaxServers {
+		return fmt.Errorf("%w: the %s plan allows %d", ErrServerLimitReached, entitlements.Plan, entitlements.Limits.MaxServers)
+	}
+
+	return nil
+}
+
+// SetPlan changes the plan a user pays for.
+func (e *EntitlementService) SetPlan(ctx context.Context, discordId, plan string) error {
+	if _, ok := Plans[plan]; !ok {
+		return ErrInvalidPlan
+	}
+
+	record, err := e.getRecord(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	record.Plan = plan
+	return e.s3.PutJSON(ctx, entitlementsKey(discordId), record)
+}
+
+// Grant gives a user a plan and/or features until the grant's duration has passed. Expired grants are dropped
+// whenever a new grant is added.
+func (e *EntitlementService) Grant(ctx context.Context, discordId, grantedBy string, req model.GrantEntitlementRequest) (*model.EntitlementGrant, error) {
+	if req.Plan == "" && len(req.Features) == 0 {
+		return nil, errors.New("a plan or at least one feature is required")
+	}
+
+	if _, ok := Plans[req.Plan]; req.Plan != "" && !ok {
+		return nil, ErrInvalidPlan
+	}
+
+	for _, feature := range req.Features {
+		if feature != model.FeatureCustomModUploads {
+			return nil, fmt.Errorf("invalid feature: %s", feature)
+		}
+	}
+
+	duration := time.Duration(req.DurationHours) * time.Hour
+	if duration <= 0 || duration > maxGrantDuration {
+		return nil, fmt.Errorf("durationHours must be between 1 and %d", int(maxGrantDuration.Hours()))
+	}
+
+	id, err := util.MakeCrypto().GenerateID(8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate grant id: %w", err)
+	}
+
+	record, err := e.getRecord(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	grant := model.EntitlementGrant{
+		ID:        id,
+		Plan:      req.Plan,
+		Features:  req.Features,
+		Reason:    req.Reason,
+		GrantedBy: grantedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+
+	record.Grants = slices.DeleteFunc(record.Grants, func(g model.EntitlementGrant) bool { return !g.ExpiresAt.After(now) })
+	record.Grants = append(record.Grants, grant)
+	return &grant, e.s3.PutJSON(ctx, entitlementsKey(discordId), record)
+}
+
+// RevokeGrant removes a grant before it expires.
+func (e *EntitlementService) RevokeGrant(ctx context.Context, discordId, grantId string) error {
+	record, err := e.getRecord(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	idx := slices.IndexFunc(record.Grants, func(g model.EntitlementGrant) bool { return g.ID == grantId })
+	if idx == -1 {
+		return ErrGrantNotFound
+	}
+
+	record.Grants = slices.Delete(record.Grants, idx, idx+1)
+	return e.s3.PutJSON(ctx, entitlementsKey(discordId), record)
+}
+
+func (e *EntitlementService) getRecord(ctx context.Context, discordId string) (*model.EntitlementRecord, error) {
+	record := model.EntitlementRecord{
+		Plan:   model.PlanFree,
+		Grants: make([]model.EntitlementGrant, 0),
+	}
+
+	err := e.s3.GetJSON(ctx, entitlementsKey(discordId), &record)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	if _, ok := Plans[record.Plan]; !ok {
+		record.Plan = model.PlanFree
+	}
+
+	return &record, nil
+}
+
+func entitlementsKey(discordId string) string {
+	return fmt.Sprintf("%s%s/%s", settingsPrefix, discordId, entitlementsFile)
+}
@@ -0,0 +1,355 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/util"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	erasurePrefix             = "erasure/"
+	defaultErasureGracePeriod = 7 * 24 * time.Hour
+	erasureResultOk           = "ok"
+	erasureResultFailed       = "failed"
+)
+
+var (
+	// ErasurePrefixes hold files owned by a user under {prefix}/{discordId}/ and are deleted when they are erased.
+	ErasurePrefixes = []string{"mods", "configs", "backups", "valheim-backups-auto", "exports"}
+
+	ErrErasureNotFound = errors.New("there is no pending account deletion")
+	ErrErasurePending  = errors.New("account deletion has already been requested")
+)
+
+// ErasureService deletes a user's account and every file they own after a grace period. Each step is recorded on
+// the erasure request so there is an audit trail of what was deleted and when.
+type ErasureService struct {
+	s3          *S3Service
+	cognito     *CognitoService
+	memberships *MembershipService
+	billing     *BillingService
+	tokens      *PersonalTokenService
+	gracePeriod time.Duration
+}
+
+// MakeErasureService creates a new erasure service. ACCOUNT_DELETION_GRACE_PERIOD overrides how long users have to
+// cancel a deletion and defaults to 7 days.
+func MakeErasureService(s3 *S3Service, cognito *CognitoService, memberships *MembershipService, billing *BillingService) *ErasureService {
+	grace := defaultErasureGracePeriod
+	if raw := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 0 {
+			log.Warnf("invalid ACCOUNT_DELETION_GRACE_PERIOD: %s, using default: %s", raw, defaultErasureGracePeriod)
+		} else {
+			grace = parsed
+		}
+	}
+
+	return &ErasureService{
+		s3:          s3,
+		cognito:     cognito,
+		memberships: memberships,
+		billing:     billing,
+		tokens:      MakePersonalTokenService(s3, nil),
+		gracePeriod: grace,
+	}
+}
+
+// RequestErasure disables a user's account and schedules it to be erased once the grace period has passed.
+func (e *ErasureService) RequestErasure(ctx context.Context, discordId string) (*model.ErasureRequest, error) {
+	pending, err := e.Pending(ctx, discordId)
+	if err != nil && !errors.Is(err, ErrErasureNotFound) {
+		return nil, err
+	}
+	if pending != nil {
+		return pending, ErrErasurePending
+	}
+
+	id, err := util.MakeCrypto().GenerateID(8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate erasure id: %w", err)
+	}
+
+	now := time.Now().UTC()
+	request := &model.ErasureRequest{
+		ID:           id,
+		DiscordID:    discordId,
+		Status:       model.ErasureStatusPending,
+		RequestedAt:  now,
+		ScheduledFor: now.Add(e.gracePeriod),
+		Steps:        make([]model.ErasureStep, 0),
+	}
+
+	// The request is only persisted once the account is disabled so a user is never left scheduled for deletion
+	// with an account that still works
+	if !e.cognito.DisableUser(ctx, discordId) {
+		return nil, fmt.Errorf("failed to disable user: %s", discordId)
+	}
+
+	e.record(request, "request", "", nil)
+	e.record(request, "disable-account", discordId, nil)
+	if err = e.save(ctx, request); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// Cancel cancels a pending erasure and re-enables the user's account unless an admin has disabled it.
+func (e *ErasureService) Cancel(ctx context.Context, discordId string) (*model.ErasureRequest, error) {
+	request, err := e.Pending(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	adminDisabled, err := e.cognito.IsAdminDisabled(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	request.Status = model.ErasureStatusCancelled
+	request.CancelledAt = &now
+	e.record(request, "cancel", "", nil)
+	if !adminDisabled {
+		e.record(request, "enable-account", discordId, boolError(e.cognito.EnableUser(ctx, discordId), "failed to enable user"))
+	}
+
+	return request, e.save(ctx, request)
+}
+
+// Pending returns the user's pending erasure request or ErrErasureNotFound.
+func (e *ErasureService) Pending(ctx context.Context, discordId string) (*model.ErasureRequest, error) {
+	requests, err := e.list(ctx, fmt.Sprintf("%s%s/", erasurePrefix, discordId))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, request := range requests {
+		if request.Status == model.ErasureStatusPending {
+			return request, nil
+		}
+	}
+
+	return nil, ErrErasureNotFound
+}
+
+// RunDue erases every user whose grace period has passed. A request which fails part way is marked failed and left
+// for an operator since some of the user's data may already be gone.
+func (e *ErasureService) RunDue(ctx context.Context, now time.Time) error {
+	requests, err := e.list(ctx, erasurePrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, request := range requests {
+		if request.Status != model.ErasureStatusPending || now.Before(request.ScheduledFor) {
+			continue
+		}
+
+		log.Infof("erasing user: %s for erasure request: %s", request.DiscordID, request.ID)
+		if err = e.erase(ctx, request); err != nil {
+			log.Errorf("failed to erase user: %s: %v", request.DiscordID, err)
+		}
+	}
+
+	return nil
+}
+
+// Start runs RunDue every interval until ctx is cancelled.
+func (e *ErasureService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Infof("starting account eraser with interval: %s", interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := e.RunDue(ctx, now); err != nil {
+				log.Errorf("account erasure failed: %v", err)
+			}
+		}
+	}
+}
+
+// erase cancels the user's subscription and then deletes their files, their server memberships and settings and
+// finally their Cognito user. Nothing is deleted unless the subscription was cancelled so an erased user is never
+// left being charged. Files are deleted before the account so a failure never leaves files behind without an account
+// to trace them to.
+func (e *ErasureService) erase(ctx context.Context, request *model.ErasureRequest) error {
+	discordId := request.DiscordID
+	var failed error
+
+	err := e.billing.CancelSubscription(ctx, discordId)
+	e.record(request, "cancel-subscription", billingAccountKey(discordId), err)
+	if err != nil {
+		request.Status = model.ErasureStatusFailed
+		return errors.Join(err, e.save(ctx, request))
+	}
+
+	for _, prefix := range ErasurePrefixes {
+		target := fmt.Sprintf("%s/%s/", prefix, discordId)
+		err := e.s3.DeleteObjectsWithPrefix(ctx, target)
+		e.record(request, "delete-files", target, err)
+		failed = errors.Join(failed, err)
+	}
+
+	access, err := e.memberships.GetUserAccess(ctx, discordId)
+	if err == nil {
+		for _, membership := range access.Servers {
+			err = e.memberships.RemoveMember(ctx, membership.ServerID, discordId)
+			if errors.Is(err, ErrMemberNotFound) {
+				err = nil
+			}
+			e.record(request, "remove-membership", membership.ServerID, err)
+			failed = errors.Join(failed, err)
+		}
+	} else {
+		e.record(request, "list-memberships", discordId, err)
+		failed = errors.Join(failed, err)
+	}
+
+	// Members of the user's own server lose access to it since the server no longer exists
+	serverAccess, err := e.memberships.GetServerAccess(ctx, discordId)
+	if err == nil {
+		for _, member := range serverAccess.Members {
+			err = e.memberships.RemoveMember(ctx, discordId, member.DiscordID)
+			e.record(request, "remove-member", member.DiscordID, err)
+			failed = errors.Join(failed, err)
+		}
+	} else {
+		e.record(request, "list-members", discordId, err)
+		failed = errors.Join(failed, err)
+	}
+
+	err = e.s3.DeleteObjects(ctx, []string{serverAccessKey(discordId), userAccessKey(discordId)})
+	e.record(request, "delete-memberships", discordId, err)
+	failed = errors.Join(failed, err)
+
+	// Schedules are removed so the scheduler stops starting, stopping and backing up a server which no longer has
+	// an owner
+	for _, prefix := range []string{schedulePrefix, restorePrefix} {
+		target := fmt.Sprintf("%s%s/", prefix, discordId)
+		err = e.s3.DeleteObjectsWithPrefix(ctx, target)
+		e.record(request, "delete-"+strings.TrimSuffix(prefix, "/"), target, err)
+		failed = errors.Join(failed, err)
+	}
+
+	err = e.deleteBillingAccount(ctx, discordId)
+	e.record(request, "delete-billing", billingAccountKey(discordId), err)
+	failed = errors.Join(failed, err)
+
+	err = e.s3.DeleteObjectsWithPrefix(ctx, fmt.Sprintf("%s%s/", settingsPrefix, discordId))
+	e.record(request, "delete-settings", settingsPrefix+discordId+"/", err)
+	failed = errors.Join(failed, err)
+
+	err = e.s3.DeleteObject(ctx, secretsKey(discordId))
+	e.record(request, "delete-secrets", secretsKey(discordId), err)
+	failed = errors.Join(failed, err)
+
+	err = e.tokens.DeleteAll(ctx, discordId)
+	e.record(request, "delete-tokens", personalTokensKey(discordId), err)
+	failed = errors.Join(failed, err)
+
+	if failed == nil {
+		err = e.cognito.DeleteUser(ctx, discordId)
+		e.record(request, "delete-account", discordId, err)
+		failed = err
+	}
+
+	now := time.Now().UTC()
+	request.Status = model.ErasureStatusCompleted
+	request.CompletedAt = &now
+	if failed != nil {
+		request.Status = model.ErasureStatusFailed
+		request.CompletedAt = nil
+	}
+
+	return errors.Join(failed, e.save(ctx, request))
+}
+
+// deleteBillingAccount removes the user's billing account along with the index from its Stripe customer back to
+// the user.
+func (e *ErasureService) deleteBillingAccount(ctx context.Context, discordId string) error {
+	var account model.BillingAccount
+	err := e.s3.GetJSON(ctx, billingAccountKey(discordId), &account)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	keys := []string{billingAccountKey(discordId)}
+	if account.CustomerID != "" {
+		keys = append(keys, billingCustomerKey(account.CustomerID))
+	}
+
+	return e.s3.DeleteObjects(ctx, keys)
+}
+
+// record appends a step to the request and writes it to the log so erasures can be audited from either.
+func (e *ErasureService) record(request *model.ErasureRequest, action, target string, err error) {
+	step := model.ErasureStep{
+		Action: action,
+		Target: target,
+		Result: erasureResultOk,
+		At:     time.Now().UTC(),
+	}
+
+	if err != nil {
+		step.Result = erasureResultFailed
+		step.Error = err.Error()
+	}
+
+	log.WithFields(log.Fields{
+		"erasureId": request.ID,
+		"discordId": request.DiscordID,
+		"action":    step.Action,
+		"target":    step.Target,
+		"result":    step.Result,
+	}).Info("erasure step")
+	request.Steps = append(request.Steps, step)
+}
+
+func (e *ErasureService) list(ctx context.Context, prefix string) ([]*model.ErasureRequest, error) {
+	objects, err := e.s3.ListObjects(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list erasure requests: %w", err)
+	}
+
+	requests := make([]*model.ErasureRequest, 0)
+	for _, obj := range objects {
+		if !strings.HasSuffix(*obj.Key, ".json") {
+			continue
+		}
+
+		var request model.ErasureRequest
+		if err = e.s3.GetJSON(ctx, *obj.Key, &request); err != nil {
+			log.Errorf("failed to read erasure request: %s: %v", *obj.Key, err)
+			continue
+		}
+		requests = append(requests, &request)
+	}
+
+	return requests, nil
+}
+
+func (e *ErasureService) save(ctx context.Context, request *model.ErasureRequest) error {
+	return e.s3.PutJSON(ctx, fmt.Sprintf("%s%s/%s.json", erasurePrefix, request.DiscordID, request.ID), request)
+}
+
+func boolError(ok bool, message string) error {
+	if ok {
+		return nil
+	}
+	return errors.New(message)
+}
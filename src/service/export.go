@@ -0,0 +1,410 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	exportPrefix      = "exports/"
+	exportLinkTTL     = time.Hour
+	exportJobTimeout  = 30 * time.Minute
+	exportStaleAfter  = 10 * time.Minute
+	exportProfileFile = "profile.json"
+)
+
+var (
+	// exportFilePrefixes are copied into the export under files/{prefix}/.
+	exportFilePrefixes = []string{"configs", "mods", "backups", "valheim-backups-auto"}
+
+	ErrExportNotFound   = errors.New("export does not exist")
+	ErrExportInProgress = errors.New("an export is already in progress")
+
+	// errExportClaimLost is returned when another worker has claimed an export while it was being built.
+	errExportClaimLost = errors.New("export was claimed by another worker")
+)
+
+// ExportService builds a zip archive of a user's profile, files and server history. The archive is streamed from the
+// source objects straight into a multipart upload so memory use does not grow with the size of the export. Exports
+// are queued in S3 and only built by the worker which claimed them, which is invoked asynchronously when
+// EXPORTS_FUNCTION_NAME names its lambda.
+type ExportService struct {
+	s3             *S3Service
+	cognito        *CognitoService
+	worker         *lambda.Client
+	workerFunction string
+}
+
+// MakeExportService creates a new export service.
+func MakeExportService(s3 *S3Service, cognito *CognitoService) *ExportService {
+	exports := &ExportService{s3: s3, cognito: cognito}
+
+	if function := os.Getenv("EXPORTS_FUNCTION_NAME"); function != "" {
+		cfg, err := config.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			log.Errorf("error loading default aws config, exports are left for the scheduled worker: %v", err)
+		} else {
+			exports.worker = lambda.NewFromConfig(cfg)
+			exports.workerFunction = function
+		}
+	}
+
+	return exports
+}
+
+// StartExport queues an export for a user and dispatches it to a worker. The job is persisted first so its progress
+// can be polled with GetExport and exports which are never dispatched are picked up by RunPending.
+func (e *ExportService) StartExport(ctx context.Context, discordId string) (*model.ExportJob, error) {
+	jobs, err := e.listJobs(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		if job.Status == model.ExportStatusPending || job.Status == model.ExportStatusRunning {
+			return job, ErrExportInProgress
+		}
+	}
+
+	id, err := util.MakeCrypto().GenerateID(8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate export id: %w", err)
+	}
+
+	now := time.Now().UTC()
+	job := &model.ExportJob{
+		ID:        id,
+		DiscordID: discordId,
+		Status:    model.ExportStatusPending,
+		Key:       fmt.Sprintf("%s%s/%s.zip", exportPrefix, discordId, id),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if _, err = e.s3.PutJSONIfMatch(ctx, exportJobKey(discordId, id), job, ""); err != nil {
+		return nil, err
+	}
+
+	if err = e.dispatch(ctx, discordId, id); err != nil {
+		log.Errorf("failed to dispatch export: %s, it is left for the scheduled worker: %v", id, err)
+	}
+
+	return job, nil
+}
+
+// dispatch hands a queued export to the exports worker lambda with an asynchronous invoke. The long-running server
+// is not frozen once a response is written so it builds the export in process instead. Either way the worker reads
+// and claims the job itself.
+func (e *ExportService) dispatch(ctx context.Context, discordId, id string) error {
+	if e.worker == nil {
+		if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+			return errors.New("EXPORTS_FUNCTION_NAME is not set")
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), exportJobTimeout)
+			defer cancel()
+			if err := e.RunJob(ctx, discordId, id); err != nil {
+				log.Errorf("failed to run export: %s: %v", id, err)
+			}
+		}()
+		return nil
+	}
+
+	payload, err := json.Marshal(model.ExportJobEvent{DiscordID: discordId, ID: id})
+	if err != nil {
+		return err
+	}
+
+	_, err = e.worker.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(e.workerFunction),
+		InvocationType: types.InvocationTypeEvent,
+		Payload:        payload,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invoke exports worker: %w", err)
+	}
+
+	return nil
+}
+
+// GetExport returns an export job. Completed exports include a presigned download link.
+func (e *ExportService) GetExport(ctx context.Context, discordId, exportId string) (*model.ExportJob, error) {
+	var job model.ExportJob
+	err := e.s3.GetJSON(ctx, exportJobKey(discordId, exportId), &job)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Status == model.ExportStatusCompleted {
+		job.DownloadURL, err = e.s3.PresignGetObject(ctx, job.Key, exportLinkTTL)
+		if err != nil {
+			return nil, err
+		}
+		expires := time.Now().UTC().Add(exportLinkTTL)
+		job.DownloadExpiresAt = &expires
+	}
+
+	return &job, nil
+}
+
+// RunJob claims an export and builds it. Exports which have finished are skipped, as are running exports unless they
+// have stopped making progress.
+func (e *ExportService) RunJob(ctx context.Context, discordId, id string) error {
+	var job model.ExportJob
+	etag, err := e.s3.GetJSONWithETag(ctx, exportJobKey(discordId, id), &job)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if job.Status != model.ExportStatusPending && job.Status != model.ExportStatusRunning {
+		return nil
+	}
+
+	if job.Status == model.ExportStatusRunning && time.Since(job.UpdatedAt) < exportStaleAfter {
+		return nil
+	}
+
+	// Claiming the job stops two workers from building the same export
+	job.Status = model.ExportStatusRunning
+	job.Files = 0
+	job.Size = 0
+	job.UpdatedAt = time.Now().UTC()
+	etag, err = e.s3.PutJSONIfMatch(ctx, exportJobKey(discordId, id), &job, etag)
+	if errors.Is(err, ErrObjectModified) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim export: %s: %w", id, err)
+	}
+
+	log.Infof("building export: %s for user: %s", job.ID, job.DiscordID)
+	e.run(ctx, &job, etag)
+	return nil
+}
+
+// RunPending builds every export which is still pending or has stopped making progress. It recovers exports whose
+// dispatch failed or whose worker stopped.
+func (e *ExportService) RunPending(ctx context.Context) error {
+	objects, err := e.s3.ListObjects(exportPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list exports: %w", err)
+	}
+
+	var errs []error
+	for _, obj := range objects {
+		discordId, id, ok := strings.Cut(strings.TrimPrefix(*obj.Key, exportPrefix), "/")
+		if !ok {
+			continue
+		}
+
+		id, ok = strings.CutSuffix(id, ".json")
+		if !ok {
+			continue
+		}
+
+		if err = e.RunJob(ctx, discordId, id); err != nil {
+			log.Errorf("failed to run export: %s: %v", id, err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// run builds the archive of a claimed export and records the outcome on the job. Progress is written conditionally
+// on etag so the export stops as soon as another worker claims it.
+func (e *ExportService) run(ctx context.Context, job *model.ExportJob, etag string) {
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	var archiveErr error
+	go func() {
+		defer close(done)
+		archiveErr = e.writeArchive(ctx, job, &etag, writer)
+		writer.CloseWithError(archiveErr)
+	}()
+
+	err := e.s3.UploadStream(ctx, job.Key, reader)
+	// Unblocks the archive writer if the upload failed part way and waits for it so the job is not shared
+	reader.CloseWithError(err)
+	<-done
+
+	if errors.Is(archiveErr, errExportClaimLost) {
+		log.Warnf("stopping export: %s for user: %s: %v", job.ID, job.DiscordID, archiveErr)
+		return
+	}
+
+	if err != nil {
+		log.Errorf("export: %s for user: %s failed: %v", job.ID, job.DiscordID, err)
+		job.Status = model.ExportStatusFailed
+		job.Error = err.Error()
+		if err = e.save(ctx, job, &etag); err != nil {
+			log.Warnf("failed to record failure of export: %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	now := time.Now().UTC()
+	job.Status = model.ExportStatusCompleted
+	job.CompletedAt = &now
+	if err = e.save(ctx, job, &etag); err != nil {
+		log.Warnf("failed to record completion of export: %s: %v", job.ID, err)
+		return
+	}
+	log.Infof("export: %s for user: %s completed with %d files (%d bytes)", job.ID, job.DiscordID, job.Files, job.Size)
+}
+
+// writeArchive writes the user's profile followed by every file they own and their server history to w.
+func (e *ExportService) writeArchive(ctx context.Context, job *model.ExportJob, etag *string, w io.Writer) error {
+	archive := zip.NewWriter(w)
+	discordId := job.DiscordID
+
+	user, err := e.cognito.GetUser(ctx, &discordId)
+	if err != nil {
+		return fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	// Credentials are never part of an export
+	user.Credentials = model.CognitoCredentials{}
+	profile, err := json.MarshalIndent(user, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal profile: %w", err)
+	}
+
+	entry, err := archive.Create(exportProfileFile)
+	if err != nil {
+		return err
+	}
+	if _, err = entry.Write(profile); err != nil {
+		return err
+	}
+
+	for _, prefix := range exportFilePrefixes {
+		root := fmt.Sprintf("%s/%s/", prefix, discordId)
+		if err = e.copyPrefix(ctx, archive, job, etag, root, path.Join("files", prefix)); err != nil {
+			return err
+		}
+	}
+
+	if err = e.copyPrefix(ctx, archive, job, etag, fmt.Sprintf("%s%s/", schedulePrefix, discordId), "history/schedules"); err != nil {
+		return err
+	}
+
+	if err = e.copyPrefix(ctx, archive, job, etag, fmt.Sprintf("%s%s/", restorePrefix, discordId), "history/restores"); err != nil {
+		return err
+	}
+
+	if err = e.copyPrefix(ctx, archive, job, etag, fmt.Sprintf("%s%s/", settingsPrefix, discordId), "settings"); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// copyPrefix streams every object under root into the archive under dir.
+func (e *ExportService) copyPrefix(ctx context.Context, archive *zip.Writer, job *model.ExportJob, etag *string, root, dir string) error {
+	objects, err := e.s3.ListObjects(root)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", root, err)
+	}
+
+	for _, obj := range objects {
+		name := strings.TrimPrefix(*obj.Key, root)
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		body, err := e.s3.GetObjectStream(ctx, *obj.Key)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		entry, err := archive.Create(path.Join(dir, name))
+		if err == nil {
+			var written int64
+			written, err = io.Copy(entry, body)
+			job.Size += written
+		}
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to add %s to export: %w", *obj.Key, err)
+		}
+
+		job.Files++
+		if job.Files%50 == 0 {
+			if err = e.save(ctx, job, etag); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *ExportService) listJobs(ctx context.Context, discordId string) ([]*model.ExportJob, error) {
+	objects, err := e.s3.ListObjects(fmt.Sprintf("%s%s/", exportPrefix, discordId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exports: %w", err)
+	}
+
+	jobs := make([]*model.ExportJob, 0)
+	for _, obj := range objects {
+		if !strings.HasSuffix(*obj.Key, ".json") {
+			continue
+		}
+
+		var job model.ExportJob
+		if err = e.s3.GetJSON(ctx, *obj.Key, &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &job)
+	}
+
+	return jobs, nil
+}
+
+// save persists the job if it is still claimed by this worker and returns errExportClaimLost otherwise. Any other
+// failure to record progress does not abort the export so it is only logged.
+func (e *ExportService) save(ctx context.Context, job *model.ExportJob, etag *string) error {
+	job.UpdatedAt = time.Now().UTC()
+	next, err := e.s3.PutJSONIfMatch(ctx, exportJobKey(job.DiscordID, job.ID), job, *etag)
+	if errors.Is(err, ErrObjectModified) {
+		return errExportClaimLost
+	}
+
+	if err != nil {
+		log.Errorf("failed to save export: %s: %v", job.ID, err)
+		return nil
+	}
+
+	*etag = next
+	return nil
+}
+
+func exportJobKey(discordId, exportId string) string {
+	return fmt.Sprintf("%s%s/%s.json", exportPrefix, discordId, exportId)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// ValheimConfigDir is the directory on the server's volume where Valheim reads its admin, ban and permitted lists.
+	ValheimConfigDir = "/root/.config/unity3d/IronGate/Valheim"
+
+	// ValheimWorldsDir is the directory on the server's volume Valheim loads world .fwl and .db files from.
+	ValheimWorldsDir = ValheimConfigDir + "/worlds_local"
+)
+
+// FileManagerService talks to the hearthhub-file-manager which is responsible for copying files between S3 and
+// the persistent volume attached to a user's Valheim server.
+type FileManagerService struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// FileSyncRequest describes a single file the file manager should copy from S3 onto the server's volume.
+type FileSyncRequest struct {
+	DiscordID   string `json:"discord_id"`
+	Prefix      string `json:"prefix"`
+	Destination string `json:"destination"`
+	Operation   string `json:"operation"`
+}
+
+// MakeFileManagerService creates a new file manager client from the FILE_MANAGER_URL environment variable.
+func MakeFileManagerService() (*FileManagerService, error) {
+	baseURL := os.Getenv("FILE_MANAGER_URL")
+	if baseURL == "" {
+		return nil, fmt.Errorf("missing required environment variable: FILE_MANAGER_URL")
+	}
+
+	return &FileManagerService{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// SyncFile asks the file manager to write the S3 object at key into the given destination directory on the
+// server owned by discordId.
+func (f *FileManagerService) SyncFile(ctx context.Context, discordId, key, destination string) error {
+	payload, err := json.Marshal(FileSyncRequest{
+		DiscordID:   discordId,
+		Prefix:      key,
+		Destination: destination,
+		Operation:   "write",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal file sync request: %w", err)
+	}
+
+	endpoint := f.baseURL + "/api/v1/file"
+	log.Infof("requesting file sync of: %s to: %s", key, destination)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create file sync request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send file sync request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("file manager returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
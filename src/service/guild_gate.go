@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGuildCheckTTL = 15 * time.Minute
+	guildCheckFile       = "guild.json"
+	reasonNotInGuild     = "not a member of the required discord guild"
+	reasonMissingRole    = "missing a required discord role"
+)
+
+var (
+	ErrGuildAccessDenied  = errors.New("discord account is not allowed to use hearthhub")
+	ErrGuildCheckRequired = errors.New("discord guild membership must be verified, sign in again")
+)
+
+// GuildGateService restricts HearthHub to members of a Discord guild who hold one of a set of roles. Membership is
+// checked at login with the user's OAuth token, which requires the guilds.members.read scope, and the result is
+// cached for DISCORD_GUILD_CHECK_TTL. Once the cached result expires it is rechecked with the bot token so users who
+// leave the guild or lose the role have their account disabled without having to log in again.
+type GuildGateService struct {
+	discord *DiscordService
+	cognito *CognitoService
+	s3      *S3Service
+	guildID string
+	roleIDs []string
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	cache map[string]model.GuildCheck
+}
+
+// MakeGuildGateService creates a new gate. Gating is disabled unless DISCORD_REQUIRED_GUILD_ID is set.
+// DISCORD_REQUIRED_ROLE_IDS is an optional comma separated list of role ids of which the user must hold at least one.
+func MakeGuildGateService(discord *DiscordService, cognito *CognitoService, s3 *S3Service) *GuildGateService {
+	ttl := defaultGuildCheckTTL
+	if raw := os.Getenv("DISCORD_GUILD_CHECK_TTL"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			log.Warnf("invalid DISCORD_GUILD_CHECK_TTL: %s, using default: %s", raw, defaultGuildCheckTTL)
+		} else {
+			ttl = parsed
+		}
+	}
+
+	roleIDs := make([]string, 0)
+	for _, id := range strings.Split(os.Getenv("DISCORD_REQUIRED_ROLE_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			roleIDs = append(roleIDs, id)
+		}
+	}
+
+	return &GuildGateService{
+		discord: discord,
+		cognito: cognito,
+		s3:      s3,
+		guildID: os.Getenv("DISCORD_REQUIRED_GUILD_ID"),
+		roleIDs: roleIDs,
+		ttl:     ttl,
+		cache:   make(map[string]model.GuildCheck),
+	}
+}
+
+// Enabled returns true when a guild is required.
+func (g *GuildGateService) Enabled() bool {
+	return g != nil && g.guildID != "" && g.discord != nil
+}
+
+// CheckLogin checks a user's membership with the OAuth access token they logged in with and caches the result. It
+// does not disable the user since they may not have an account yet.
+func (g *GuildGateService) CheckLogin(ctx context.Context, discordId, accessToken string) (*model.GuildCheck, error) {
+	if !g.Enabled() {
+		return &model.GuildCheck{Allowed: true, CheckedAt: time.Now().UTC()}, nil
+	}
+
+	if accessToken == "" {
+		return nil, errors.New("a discord access token with the guilds.members.read scope is required")
+	}
+
+	member, err := g.discord.GetGuildMember(ctx, accessToken, g.guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check guild membership: %w", err)
+	}
+
+	check := g.evaluate(member)
+	g.save(ctx, discordId, check)
+	return &check, nil
+}
+
+// Enforce returns nil when the user may use HearthHub. The cached check is used while it is fresh, otherwise the user
+// is rechecked with the bot token. Users who are no longer allowed are disabled and ErrGuildAccessDenied is
+// returned. When a recheck fails the last known result is kept so a Discord outage does not lock everyone out.
+func (g *GuildGateService) Enforce(ctx context.Context, user *model.CognitoUser) error {
+	if !g.Enabled() {
+		return nil
+	}
+
+	check, found := g.load(ctx, user.DiscordID)
+	if !found || time.Since(check.CheckedAt) > g.ttl {
+		member, err := g.discord.GetGuildMemberAsBot(ctx, g.guildID, user.DiscordID)
+		switch {
+		case err == nil:
+			check = g.evaluate(member)
+			g.save(ctx, user.DiscordID, check)
+		case !found:
+			log.Errorf("failed to check guild membership for user: %s and no previous check exists: %v", user.DiscordID, err)
+			return ErrGuildCheckRequired
+		default:
+			log.Warnf("failed to recheck guild membership for user: %s, using check from: %s: %v", user.DiscordID, check.CheckedAt, err)
+		}
+	}
+
+	if check.Allowed {
+		return nil
+	}
+
+	if user.AccountEnabled {
+		log.Infof("disabling user: %s: %s", user.DiscordID, check.Reason)
+		if g.cognito.DisableUser(ctx, user.DiscordID) {
+			user.AccountEnabled = false
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrGuildAccessDenied, check.Reason)
+}
+
+func (g *GuildGateService) evaluate(member *GuildMember) model.GuildCheck {
+	check := model.GuildCheck{Allowed: true, CheckedAt: time.Now().UTC()}
+	switch {
+	case member == nil:
+		check.Allowed = false
+		check.Reason = reasonNotInGuild
+	case len(g.roleIDs) > 0 && !slices.ContainsFunc(member.Roles, func(role string) bool { return slices.Contains(g.roleIDs, role) }):
+		check.Allowed = false
+		check.Reason = reasonMissingRole
+	}
+	return check
+}
+
+// load returns the cached check from memory falling back to S3 so checks are shared between lambda instances.
+func (g *GuildGateService) load(ctx context.Context, discordId string) (model.GuildCheck, bool) {
+	g.mu.Lock()
+	check, ok := g.cache[discordId]
+	g.mu.Unlock()
+	if ok {
+		return check, true
+	}
+
+	if err := g.s3.GetJSON(ctx, guildCheckKey(discordId), &check); err != nil {
+		if !errors.Is(err, ErrObjectNotFound) {
+			log.Errorf("failed to load guild check for user: %s: %v", discordId, err)
+		}
+		return check, false
+	}
+
+	g.mu.Lock()
+	g.cache[discordId] = check
+	g.mu.Unlock()
+	return check, true
+}
+
+func (g *GuildGateService) save(ctx context.Context, discordId string, check model.GuildCheck) {
+	g.mu.Lock()
+	g.cache[discordId] = check
+	g.mu.Unlock()
+
+	if err := g.s3.PutJSON(ctx, guildCheckKey(discordId), check); err != nil {
+		log.Errorf("failed to save guild check for user: %s: %v", discordId, err)
+	}
+}
+
+func guildCheckKey(discordId string) string {
+	return fmt.Sprintf("%s%s/%s", settingsPrefix, discordId, guildCheckFile)
+}
@@ -0,0 +1,360 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	valheimCommand      = "valheim"
+	serverCommandOption = "server"
+	deferredTimeout     = 2 * time.Minute
+	interactionsPrefix  = "interactions/"
+
+	// interactionTokenTTL is how long Discord accepts edits to a deferred response for.
+	interactionTokenTTL = 15 * time.Minute
+)
+
+// ValheimCommands are the slash command definitions registered with Discord by RegisterCommands. Each sub command
+// accepts an optional server id so members can control servers they do not own.
+var ValheimCommands = []model.ApplicationCommand{
+	{
+		Name:        valheimCommand,
+		Description: "Control your HearthHub Valheim server",
+		Options: []model.ApplicationCommandOption{
+			subCommand("start", "Start the server"),
+			subCommand("status", "Show whether the server is running"),
+			subCommand("players", "List the players connected to the server"),
+			subCommand("backup", "Back up the world now"),
+		},
+	},
+}
+
+// InteractionService dispatches Discord slash commands to server operations on behalf of the HearthHub user linked
+// to the invoking Discord account. Slow commands are queued as jobs in S3 at interactions/{interactionId}.json and
+// carried out by the interactions worker, which is invoked asynchronously when INTERACTIONS_FUNCTION_NAME names its
+// lambda.
+type InteractionService struct {
+	s3             *S3Service
+	discord        *DiscordService
+	server         *ServerService
+	memberships    *MembershipService
+	cognito        *CognitoService
+	gate           *GuildGateService
+	worker         *lambda.Client
+	workerFunction string
+}
+
+// MakeInteractionService creates a new interaction service.
+func MakeInteractionService(s3 *S3Service, discord *DiscordService, server *ServerService, memberships *MembershipService, cognito *CognitoService, gate *GuildGateService) *InteractionService {
+	interactions := &InteractionService{
+		s3:          s3,
+		discord:     discord,
+		server:      server,
+		memberships: memberships,
+		cognito:     cognito,
+		gate:        gate,
+	}
+
+	if function := os.Getenv("INTERACTIONS_FUNCTION_NAME"); function != "" {
+		cfg, err := config.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			log.Errorf("error loading default aws config, interactions are left for the scheduled worker: %v", err)
+		} else {
+			interactions.worker = lambda.NewFromConfig(cfg)
+			interactions.workerFunction = function
+		}
+	}
+
+	return interactions
+}
+
+// RegisterCommands publishes ValheimCommands to Discord so the command definitions live in code.
+func (i *InteractionService) RegisterCommands(ctx context.Context) error {
+	return i.discord.RegisterCommands(ctx, ValheimCommands)
+}
+
+// Handle returns the immediate response for an interaction. Slow commands are queued for the interactions worker
+// and acknowledged with a deferred response which the worker edits once the command has finished.
+func (i *InteractionService) Handle(ctx context.Context, interaction *model.DiscordInteraction) *model.InteractionResponse {
+	if interaction.Type == model.InteractionTypePing {
+		return &model.InteractionResponse{Type: model.InteractionResponsePong}
+	}
+
+	if interaction.Type != model.InteractionTypeApplicationCommand || interaction.Data.Name != valheimCommand || len(interaction.Data.Options) == 0 {
+		return message("Unknown command.")
+	}
+
+	discordId := invokingUser(interaction)
+	user, err := i.cognito.GetUser(ctx, &discordId)
+	if err != nil {
+		return message("Your Discord account is not linked to HearthHub. Sign in to the web app first.")
+	}
+
+	if !user.AccountEnabled {
+		return message("Your HearthHub account is disabled.")
+	}
+
+	if err = i.gate.Enforce(ctx, user); err != nil {
+		return message("You no longer have access to HearthHub.")
+	}
+
+	sub := interaction.Data.Options[0]
+	serverId := discordId
+	for _, opt := range sub.Options {
+		if value, ok := opt.Value.(string); ok && opt.Name == serverCommandOption && value != "" {
+			serverId = value
+		}
+	}
+
+	permission := model.PermissionServerRead
+	if sub.Name == "start" || sub.Name == "backup" {
+		permission = model.PermissionServerControl
+	}
+
+	if _, err = i.memberships.Authorize(ctx, discordId, serverId, permission); err != nil {
+		if errors.Is(err, ErrForbidden) {
+			return message("You do not have permission to do that on this server.")
+		}
+		log.Errorf("failed to authorize discord user: %s on server: %s: %v", discordId, serverId, err)
+		return message("Something went wrong, try again later.")
+	}
+
+	if i.server == nil {
+		return message("Server controls are not available right now.")
+	}
+
+	log.Infof("discord user: %s invoked /%s %s on server: %s", discordId, valheimCommand, sub.Name, serverId)
+	switch sub.Name {
+	case "status":
+		status, err := i.server.Status(ctx, serverId)
+		if err != nil {
+			return message(fmt.Sprintf("Could not get server status: %v", err))
+		}
+		return message(fmt.Sprintf("Server is **%s** with %d player(s) online.", status.State, len(status.Players)))
+	case "players":
+		status, err := i.server.Status(ctx, serverId)
+		if err != nil {
+			return message(fmt.Sprintf("Could not get players: %v", err))
+		}
+		if len(status.Players) == 0 {
+			return message("Nobody is online.")
+		}
+		return message("Online: " + strings.Join(status.Players, ", "))
+	case "start", "backup":
+		return i.deferred(ctx, interaction, discordId, serverId, sub.Name)
+	}
+
+	return message("Unknown command.")
+}
+
+// deferred queues command as a job and acknowledges the interaction straight away since Discord only waits 3
+// seconds for a response. The job is persisted before the response is written so it is not lost when a lambda is
+// frozen afterwards.
+func (i *InteractionService) deferred(ctx context.Context, interaction *model.DiscordInteraction, discordId, serverId, command string) *model.InteractionResponse {
+	now := time.Now().UTC()
+	job := &model.InteractionJob{
+		ID:        interaction.ID,
+		Command:   command,
+		DiscordID: discordId,
+		ServerID:  serverId,
+		Token:     interaction.Token,
+		Status:    model.InteractionJobPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// Discord may deliver an interaction more than once, it is only queued the first time
+	_, err := i.s3.PutJSONIfMatch(ctx, interactionJobKey(job.ID), job, "")
+	if errors.Is(err, ErrObjectModified) {
+		return deferredMessage()
+	}
+
+	if err != nil {
+		log.Errorf("failed to queue interaction: %s: %v", interaction.ID, err)
+		return message("Something went wrong, try again later.")
+	}
+
+	if err = i.dispatch(ctx, job.ID); err != nil {
+		log.Errorf("failed to dispatch interaction: %s, it is left for the scheduled worker: %v", job.ID, err)
+	}
+
+	return deferredMessage()
+}
+
+// dispatch hands a queued job to the interactions worker lambda with an asynchronous invoke. The long-running
+// server is not frozen once a response is written so it runs the job in process instead.
+func (i *InteractionService) dispatch(ctx context.Context, id string) error {
+	if i.worker == nil {
+		if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+			return errors.New("INTERACTIONS_FUNCTION_NAME is not set")
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), deferredTimeout)
+			defer cancel()
+			if err := i.RunJob(ctx, id); err != nil {
+				log.Errorf("failed to run interaction: %s: %v", id, err)
+			}
+		}()
+		return nil
+	}
+
+	payload, err := json.Marshal(model.InteractionJobEvent{ID: id})
+	if err != nil {
+		return err
+	}
+
+	_, err = i.worker.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(i.workerFunction),
+		InvocationType: types.InvocationTypeEvent,
+		Payload:        payload,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invoke interactions worker: %w", err)
+	}
+
+	return nil
+}
+
+// RunJob claims a queued interaction job, carries out its command and edits the deferred response with the
+// outcome. Jobs which another worker is running are skipped unless they have stopped making progress, and jobs
+// whose interaction has expired are dropped.
+func (i *InteractionService) RunJob(ctx context.Context, id string) error {
+	var job model.InteractionJob
+	etag, err := i.s3.GetJSONWithETag(ctx, interactionJobKey(id), &job)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if job.Status == model.InteractionJobRunning && time.Since(job.UpdatedAt) < deferredTimeout {
+		return nil
+	}
+
+	if time.Since(job.CreatedAt) > interactionTokenTTL {
+		log.Warnf("dropping interaction: %s which expired before it was run", job.ID)
+		return i.s3.DeleteObject(ctx, interactionJobKey(job.ID))
+	}
+
+	job.Status = model.InteractionJobRunning
+	job.UpdatedAt = time.Now().UTC()
+	_, err = i.s3.PutJSONIfMatch(ctx, interactionJobKey(job.ID), &job, etag)
+	if errors.Is(err, ErrObjectModified) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	content, err := i.perform(ctx, &job)
+	if err != nil {
+		content = fmt.Sprintf("Command failed: %v", err)
+	}
+
+	err = i.discord.EditOriginalResponse(ctx, job.Token, model.InteractionResponseData{Content: content})
+	if err != nil {
+		log.Errorf("failed to edit deferred response for interaction: %s: %v", job.ID, err)
+	}
+
+	return i.s3.DeleteObject(ctx, interactionJobKey(job.ID))
+}
+
+// RunPending runs every queued interaction job. It recovers jobs whose dispatch failed or whose worker stopped.
+func (i *InteractionService) RunPending(ctx context.Context) error {
+	objects, err := i.s3.ListObjects(interactionsPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list interactions: %w", err)
+	}
+
+	for _, obj := range objects {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(*obj.Key, interactionsPrefix), ".json")
+		if !ok {
+			continue
+		}
+
+		if err = i.RunJob(ctx, id); err != nil {
+			log.Errorf("failed to run interaction: %s: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+// perform carries out the command of a job and returns the message to reply with.
+func (i *InteractionService) perform(ctx context.Context, job *model.InteractionJob) (string, error) {
+	if i.server == nil {
+		return "Server controls are not available right now.", nil
+	}
+
+	log.Infof("running /%s %s for discord user: %s on server: %s", valheimCommand, job.Command, job.DiscordID, job.ServerID)
+	switch job.Command {
+	case "start":
+		return "Server is starting.", i.server.Start(ctx, job.ServerID)
+	case "backup":
+		return "Backup complete.", i.server.Backup(ctx, job.ServerID)
+	}
+
+	return "", fmt.Errorf("unknown command: %s", job.Command)
+}
+
+// invokingUser returns the Discord id of the user who invoked the interaction. Member is set in guilds and User in
+// direct messages.
+func invokingUser(interaction *model.DiscordInteraction) string {
+	if interaction.Member != nil {
+		return interaction.Member.User.ID
+	}
+	if interaction.User != nil {
+		return interaction.User.ID
+	}
+	return ""
+}
+
+func deferredMessage() *model.InteractionResponse {
+	return &model.InteractionResponse{
+		Type: model.InteractionResponseDeferredChannelMessage,
+		Data: &model.InteractionResponseData{Flags: model.MessageFlagEphemeral},
+	}
+}
+
+func interactionJobKey(id string) string {
+	return fmt.Sprintf("%s%s.json", interactionsPrefix, id)
+}
+
+func message(content string) *model.InteractionResponse {
+	return &model.InteractionResponse{
+		Type: model.InteractionResponseChannelMessage,
+		Data: &model.InteractionResponseData{
+			Content: content,
+			Flags:   model.MessageFlagEphemeral,
+		},
+	}
+}
+
+func subCommand(name, description string) model.ApplicationCommandOption {
+	return model.ApplicationCommandOption{
+		Type:        model.CommandOptionTypeSubCommand,
+		Name:        name,
+		Description: description,
+		Options: []model.ApplicationCommandOption{
+			{
+				Type:        model.CommandOptionTypeString,
+				Name:        serverCommandOption,
+				Description: "Server id, defaults to your own server",
+			},
+		},
+	}
+}
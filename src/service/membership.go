@@ -0,0 +1,386 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/util"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	membershipPrefix      = "memberships/"
+	defaultInviteLifetime = 7 * 24 * time.Hour
+	maxInviteLifetime     = 30 * 24 * time.Hour
+)
+
+var (
+	// RolePermissions lists what each role is allowed to do on a server.
+	RolePermissions = map[string][]string{
+		model.RoleOwner: {
+			model.PermissionServerRead,
+			model.PermissionServerControl,
+			model.PermissionFilesRead,
+			model.PermissionFilesWrite,
+			model.PermissionManage,
+		},
+		model.RoleAdmin: {
+			model.PermissionServerRead,
+			model.PermissionServerControl,
+			model.PermissionFilesRead,
+			model.PermissionFilesWrite,
+		},
+		model.RoleViewer: {
+			model.PermissionServerRead,
+		},
+	}
+
+	ErrForbidden       = errors.New("forbidden: you do not have access to this server")
+	ErrInviteNotFound  = errors.New("invite does not exist or has expired")
+	ErrMemberNotFound  = errors.New("member does not exist")
+	ErrInvalidRole     = errors.New("role must be one of: admin, viewer")
+	ErrAlreadyHasRole  = errors.New("user already has access to this server")
+	ErrInviteNotForYou = errors.New("invite was sent to a different user")
+)
+
+// MembershipService stores the members and invites of each server and is the single place where a user's access
+// to a server is decided. A server is owned by the user whose Discord ID it is keyed by, every other user needs a
+// membership.
+type MembershipService struct {
+	s3            *S3Service
+	inviteBaseURL string
+}
+
+// MakeMembershipService creates a new membership service. Shareable invite links are built from INVITE_BASE_URL.
+func MakeMembershipService(s3 *S3Service) *MembershipService {
+	return &MembershipService{
+		s3:            s3,
+		inviteBaseURL: strings.TrimSuffix(os.Getenv("INVITE_BASE_URL"), "/"),
+	}
+}
+
+// Role returns the role discordId holds on serverId or an empty string when they have no access.
+func (m *MembershipService) Role(ctx context.Context, discordId, serverId string) (string, error) {
+	if discordId == serverId {
+		return model.RoleOwner, nil
+	}
+
+	access, err := m.GetServerAccess(ctx, serverId)
+	if err != nil {
+		return "", err
+	}
+
+	for _, member := range access.Members {
+		if member.DiscordID == discordId {
+			return member.Role, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Authorize returns the role of discordId on serverId when that role grants permission, otherwise ErrForbidden.
+func (m *MembershipService) Authorize(ctx context.Context, discordId, serverId, permission string) (string, error) {
+	role, err := m.Role(ctx, discordId, serverId)
+	if err != nil {
+		return "", err
+	}
+
+	if !slices.Contains(RolePermissions[role], permission) {
+		return "", ErrForbidden
+	}
+
+	return role, nil
+}
+
+// GetServerAccess returns the members and outstanding invites of a server.
+func (m *MembershipService) GetServerAccess(ctx context.Context, serverId string) (*model.ServerAccess, error) {
+	access := model.ServerAccess{
+		Members: []model.ServerMember{},
+		Invites: []model.ServerInvite{},
+	}
+
+	err := m.s3.GetJSON(ctx, serverAccessKey(serverId), &access)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	// Expired invites are dropped on read so they never show up or get accepted
+	now := time.Now()
+	access.Invites = slices.DeleteFunc(access.Invites, func(i model.ServerInvite) bool {
+		return now.After(i.ExpiresAt)
+	})
+
+	return &access, nil
+}
+
+// GetUserAccess returns the servers a user is a member of and the invites sent to them.
+func (m *MembershipService) GetUserAccess(ctx context.Context, discordId string) (*model.UserAccess, error) {
+	access := model.UserAccess{
+		Servers: []model.ServerMembership{},
+		Invites: []string{},
+	}
+
+	err := m.s3.GetJSON(ctx, userAccessKey(discordId), &access)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	return &access, nil
+}
+
+// PendingInvites returns the unexpired invites sent directly to a user.
+func (m *MembershipService) PendingInvites(ctx context.Context, discordId string) ([]model.ServerInvite, error) {
+	userAccess, err := m.GetUserAccess(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	invites := make([]model.ServerInvite, 0)
+	for _, token := range userAccess.Invites {
+		invite, _, err := m.findInvite(ctx, token)
+		if err == nil {
+			invites = append(invites, *invite)
+		}
+	}
+
+	return invites, nil
+}
+
+// CreateInvite creates an invite to serverId. When req.DiscordID is set the invite is sent to that user, otherwise
+// a shareable link is returned.
+func (m *MembershipService) CreateInvite(ctx context.Context, createdBy, serverId string, req model.InviteRequest) (*model.ServerInvite, error) {
+	if err := validateMemberRole(req.Role); err != nil {
+		return nil, err
+	}
+
+	if req.DiscordID == serverId {
+		return nil, ErrAlreadyHasRole
+	}
+
+	lifetime := defaultInviteLifetime
+	if req.ExpiresInHours > 0 {
+		lifetime = min(time.Duration(req.ExpiresInHours)*time.Hour, maxInviteLifetime)
+	}
+
+	secret, err := util.MakeCrypto().GenerateID(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate invite token: %w", err)
+	}
+
+	// The server id is part of the token so an invite can be found without an index of every invite
+	invite := model.ServerInvite{
+		Token:     serverId + "." + secret,
+		ServerID:  serverId,
+		Role:      req.Role,
+		DiscordID: req.DiscordID,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: time.Now().UTC().Add(lifetime),
+	}
+
+	if invite.DiscordID == "" && m.inviteBaseURL != "" {
+		invite.Link = fmt.Sprintf("%s/invite/%s", m.inviteBaseURL, invite.Token)
+	}
+
+	access, err := m.GetServerAccess(ctx, serverId)
+	if err != nil {
+		return nil, err
+	}
+
+	access.Invites = append(access.Invites, invite)
+	if err = m.s3.PutJSON(ctx, serverAccessKey(serverId), access); err != nil {
+		return nil, err
+	}
+
+	if invite.DiscordID != "" {
+		userAccess, err := m.GetUserAccess(ctx, invite.DiscordID)
+		if err != nil {
+			return nil, err
+		}
+
+		userAccess.Invites = append(userAccess.Invites, invite.Token)
+		if err = m.s3.PutJSON(ctx, userAccessKey(invite.DiscordID), userAccess); err != nil {
+			return nil, err
+		}
+	}
+
+	return &invite, nil
+}
+
+// RevokeInvite deletes an outstanding invite from a server.
+func (m *MembershipService) RevokeInvite(ctx context.Context, serverId, token string) error {
+	access, err := m.GetServerAccess(ctx, serverId)
+	if err != nil {
+		return err
+	}
+
+	idx := slices.IndexFunc(access.Invites, func(i model.ServerInvite) bool { return i.Token == token })
+	if idx == -1 {
+		return ErrInviteNotFound
+	}
+
+	invite := access.Invites[idx]
+	access.Invites = slices.Delete(access.Invites, idx, idx+1)
+	if err = m.s3.PutJSON(ctx, serverAccessKey(serverId), access); err != nil {
+		return err
+	}
+
+	return m.removeUserInvite(ctx, invite)
+}
+
+// AcceptInvite adds discordId as a member of the invite's server with the invite's role. Invites sent to a user are
+// single use while shareable links remain valid until they expire or are revoked.
+func (m *MembershipService) AcceptInvite(ctx context.Context, discordId, token string) (*model.ServerMembership, error) {
+	invite, access, err := m.findInvite(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if invite.DiscordID != "" && invite.DiscordID != discordId {
+		return nil, ErrInviteNotForYou
+	}
+
+	role, err := m.Role(ctx, discordId, invite.ServerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if role != "" {
+		return nil, ErrAlreadyHasRole
+	}
+
+	if invite.DiscordID != "" {
+		access.Invites = slices.DeleteFunc(access.Invites, func(i model.ServerInvite) bool { return i.Token == token })
+	}
+
+	access.Members = append(access.Members, model.ServerMember{
+		DiscordID: discordId,
+		Role:      invite.Role,
+		AddedBy:   invite.CreatedBy,
+		AddedAt:   time.Now().UTC(),
+	})
+
+	if err = m.s3.PutJSON(ctx, serverAccessKey(invite.ServerID), access); err != nil {
+		return nil, err
+	}
+
+	membership := model.ServerMembership{ServerID: invite.ServerID, Role: invite.Role}
+	userAccess, err := m.GetUserAccess(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	userAccess.Servers = append(userAccess.Servers, membership)
+	userAccess.Invites = slices.DeleteFunc(userAccess.Invites, func(t string) bool { return t == token })
+	if err = m.s3.PutJSON(ctx, userAccessKey(discordId), userAccess); err != nil {
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
+// SetMemberRole changes the role of an existing member of a server.
+func (m *MembershipService) SetMemberRole(ctx context.Context, serverId, discordId, role string) error {
+	if err := validateMemberRole(role); err != nil {
+		return err
+	}
+
+	return m.updateMember(ctx, serverId, discordId, func(access *model.ServerAccess, idx int) *model.ServerMembership {
+		access.Members[idx].Role = role
+		return &model.ServerMembership{ServerID: serverId, Role: role}
+	})
+}
+
+// RemoveMember revokes a member's access to a server.
+func (m *MembershipService) RemoveMember(ctx context.Context, serverId, discordId string) error {
+	return m.updateMember(ctx, serverId, discordId, func(access *model.ServerAccess, idx int) *model.ServerMembership {
+		access.Members = slices.Delete(access.Members, idx, idx+1)
+		return nil
+	})
+}
+
+// updateMember applies update to a member and keeps the member's user index in sync. update returns the member's
+// new membership or nil when they were removed.
+func (m *MembershipService) updateMember(ctx context.Context, serverId, discordId string, update func(access *model.ServerAccess, idx int) *model.ServerMembership) error {
+	access, err := m.GetServerAccess(ctx, serverId)
+	if err != nil {
+		return err
+	}
+
+	idx := slices.IndexFunc(access.Members, func(member model.ServerMember) bool { return member.DiscordID == discordId })
+	if idx == -1 {
+		return ErrMemberNotFound
+	}
+
+	membership := update(access, idx)
+	if err = m.s3.PutJSON(ctx, serverAccessKey(serverId), access); err != nil {
+		return err
+	}
+
+	userAccess, err := m.GetUserAccess(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	userAccess.Servers = slices.DeleteFunc(userAccess.Servers, func(s model.ServerMembership) bool { return s.ServerID == serverId })
+	if membership != nil {
+		userAccess.Servers = append(userAccess.Servers, *membership)
+	}
+
+	return m.s3.PutJSON(ctx, userAccessKey(discordId), userAccess)
+}
+
+func (m *MembershipService) findInvite(ctx context.Context, token string) (*model.ServerInvite, *model.ServerAccess, error) {
+	serverId, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, nil, ErrInviteNotFound
+	}
+
+	access, err := m.GetServerAccess(ctx, serverId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, invite := range access.Invites {
+		if invite.Token == token {
+			return &invite, access, nil
+		}
+	}
+
+	return nil, nil, ErrInviteNotFound
+}
+
+func (m *MembershipService) removeUserInvite(ctx context.Context, invite model.ServerInvite) error {
+	if invite.DiscordID == "" {
+		return nil
+	}
+
+	userAccess, err := m.GetUserAccess(ctx, invite.DiscordID)
+	if err != nil {
+		return err
+	}
+
+	userAccess.Invites = slices.DeleteFunc(userAccess.Invites, func(t string) bool { return t == invite.Token })
+	return m.s3.PutJSON(ctx, userAccessKey(invite.DiscordID), userAccess)
+}
+
+// validateMemberRole ensures a role can be granted. Ownership cannot be granted or transferred.
+func validateMemberRole(role string) error {
+	if role != model.RoleAdmin && role != model.RoleViewer {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+func serverAccessKey(serverId string) string {
+	return fmt.Sprintf("%sservers/%s.json", membershipPrefix, serverId)
+}
+
+func userAccessKey(discordId string) string {
+	return fmt.Sprintf("%susers/%s.json", membershipPrefix, discordId)
+}
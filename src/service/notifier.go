@@ -0,0 +1,421 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWebhookHost   = "https://discord.com"
+	maxWebhooksPerUser   = 10
+	maxDeliveryAttempts  = 4
+	initialRetryBackoff  = 500 * time.Millisecond
+	maxRetryAfter        = 30 * time.Second
+	maxWebhookNotFound   = 3
+	webhookUsername      = "HearthHub"
+	webhookSettingsFile  = "webhooks.json"
+	webhookDisabled404   = "webhook returned 404 repeatedly and was disabled"
+	webhookEmbedFallback = 0x5865F2
+
+	// webhookTokenMask replaces the token of a webhook URL in settings, the full URL is kept as a secret.
+	webhookTokenMask = "****"
+)
+
+var (
+	// NotificationEvents are the event types webhooks can subscribe to mapped to the colour of their embed.
+	NotificationEvents = map[string]int{
+		model.EventServerStarted:    0x57F287,
+		model.EventServerStopped:    0x95A5A6,
+		model.EventServerCrashed:    0xED4245,
+		model.EventPlayerJoined:     0x3498DB,
+		model.EventPlayerLeft:       0x206694,
+		model.EventBackupCompleted:  0x1ABC9C,
+		model.EventModInstallFailed: 0xE67E22,
+	}
+
+	eventTitles = map[string]string{
+		model.EventServerStarted:    "Server started",
+		model.EventServerStopped:    "Server stopped",
+		model.EventServerCrashed:    "Server crashed",
+		model.EventPlayerJoined:     "Player joined",
+		model.EventPlayerLeft:       "Player left",
+		model.EventBackupCompleted:  "Backup completed",
+		model.EventModInstallFailed: "Mod install failed",
+	}
+
+	ErrWebhookNotFound = errors.New("webhook does not exist")
+	errWebhookGone     = errors.New("webhook returned 404")
+)
+
+// NotifierService stores each user's Discord webhooks and delivers server and account events to the webhooks
+// subscribed to them as rich embeds. A webhook URL carries the token which allows posting to it so the full URL is
+// stored through the SecretService and settings only hold a masked form.
+type NotifierService struct {
+	s3          *S3Service
+	secrets     *SecretService
+	webhookHost string
+	httpClient  *http.Client
+}
+
+// MakeNotifierService creates a new notifier. Webhook URLs must point at DISCORD_WEBHOOK_HOST which defaults to
+// https://discord.com and can be pointed at a local HTTP server for testing.
+func MakeNotifierService(s3 *S3Service) *NotifierService {
+	host := strings.TrimSuffix(os.Getenv("DISCORD_WEBHOOK_HOST"), "/")
+	if host == "" {
+		host = defaultWebhookHost
+	}
+
+	return &NotifierService{
+		s3:          s3,
+		secrets:     MakeSecretService(s3),
+		webhookHost: host,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ValidateWebhook ensures the webhook URL points at the configured Discord host and every event is known.
+func (n *NotifierService) ValidateWebhook(webhook *model.Webhook) error {
+	if !strings.HasPrefix(webhook.URL, n.webhookHost+"/api/webhooks/") {
+		return fmt.Errorf("url must be a discord webhook starting with: %s/api/webhooks/", n.webhookHost)
+	}
+
+	if len(webhook.Events) == 0 {
+		return errors.New("at least one event is required")
+	}
+
+	for _, event := range webhook.Events {
+		if _, ok := NotificationEvents[event]; !ok {
+			return fmt.Errorf("invalid event: %s", event)
+		}
+	}
+
+	return nil
+}
+
+// ListWebhooks returns every webhook configured by a user with their URLs masked. Webhooks saved before URLs were
+// kept as secrets have their URL moved into the secret store the first time they are read.
+func (n *NotifierService) ListWebhooks(ctx context.Context, discordId string) ([]model.Webhook, error) {
+	webhooks := make([]model.Webhook, 0)
+	err := n.s3.GetJSON(ctx, webhooksKey(discordId), &webhooks)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	migrated := false
+	for i := range webhooks {
+		if isMaskedWebhookURL(webhooks[i].URL) {
+			continue
+		}
+
+		if err = n.secrets.Put(ctx, discordId, webhookSecret(webhooks[i].ID), webhooks[i].URL); err != nil {
+			return nil, err
+		}
+		webhooks[i].URL = maskWebhookURL(webhooks[i].URL)
+		migrated = true
+	}
+
+	if migrated {
+		if err = n.s3.PutJSON(ctx, webhooksKey(discordId), webhooks); err != nil {
+			return nil, err
+		}
+	}
+
+	return webhooks, nil
+}
+
+// PutWebhook creates the webhook when its ID is empty, otherwise it replaces the webhook with that ID. Re-enabling
+// a webhook resets its 404 count. When replacing a webhook its masked URL may be sent back to keep the URL.
+func (n *NotifierService) PutWebhook(ctx context.Context, discordId string, webhook model.Webhook) (*model.Webhook, error) {
+	if err := n.ValidateWebhook(&webhook); err != nil {
+		return nil, err
+	}
+
+	webhooks, err := n.ListWebhooks(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := -1
+	if webhook.ID == "" {
+		if len(webhooks) >= maxWebhooksPerUser {
+			return nil, fmt.Errorf("a maximum of %d webhooks can be configured", maxWebhooksPerUser)
+		}
+
+		webhook.ID, err = util.MakeCrypto().GenerateID(8)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate webhook id: %w", err)
+		}
+		webhook.CreatedAt = time.Now().UTC()
+	} else {
+		idx = slices.IndexFunc(webhooks, func(w model.Webhook) bool { return w.ID == webhook.ID })
+		if idx == -1 {
+			return nil, ErrWebhookNotFound
+		}
+
+		webhook.CreatedAt = webhooks[idx].CreatedAt
+		webhook.LastDelivered = webhooks[idx].LastDelivered
+	}
+
+	if idx == -1 || webhook.URL != webhooks[idx].URL {
+		if isMaskedWebhookURL(webhook.URL) {
+			return nil, errors.New("url must be the full discord webhook url")
+		}
+
+		if err = n.secrets.Put(ctx, discordId, webhookSecret(webhook.ID), webhook.URL); err != nil {
+			return nil, err
+		}
+		webhook.URL = maskWebhookURL(webhook.URL)
+	}
+
+	if idx == -1 {
+		webhooks = append(webhooks, webhook)
+	} else {
+		webhooks[idx] = webhook
+	}
+
+	return &webhook, n.s3.PutJSON(ctx, webhooksKey(discordId), webhooks)
+}
+
+// DeleteWebhook removes a webhook and its URL.
+func (n *NotifierService) DeleteWebhook(ctx context.Context, discordId, webhookId string) error {
+	webhooks, err := n.ListWebhooks(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	idx := slices.IndexFunc(webhooks, func(w model.Webhook) bool { return w.ID == webhookId })
+	if idx == -1 {
+		return ErrWebhookNotFound
+	}
+
+	if err = n.s3.PutJSON(ctx, webhooksKey(discordId), slices.Delete(webhooks, idx, idx+1)); err != nil {
+		return err
+	}
+
+	return n.secrets.Remove(ctx, discordId, webhookSecret(webhookId))
+}
+
+// Test sends a test embed to a single webhook regardless of its subscriptions.
+func (n *NotifierService) Test(ctx context.Context, discordId, webhookId string) error {
+	webhooks, err := n.ListWebhooks(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	idx := slices.IndexFunc(webhooks, func(w model.Webhook) bool { return w.ID == webhookId })
+	if idx == -1 {
+		return ErrWebhookNotFound
+	}
+
+	url, err := n.secrets.Get(ctx, discordId, webhookSecret(webhookId))
+	if err != nil {
+		return fmt.Errorf("failed to read url of webhook: %s: %w", webhookId, err)
+	}
+
+	return n.deliver(ctx, url, model.DiscordWebhookMessage{
+		Username: webhookUsername,
+		Embeds: []model.DiscordEmbed{{
+			Title:       "Test notification",
+			Description: "Your HearthHub webhook is working.",
+			Color:       webhookEmbedFallback,
+			Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		}},
+	})
+}
+
+// Notify delivers event to every enabled webhook of the user subscribed to it. Delivery failures are logged rather
+// than returned so a broken webhook never fails the operation which raised the event. Webhooks which keep
+// returning 404 are disabled.
+func (n *NotifierService) Notify(ctx context.Context, event model.NotificationEvent) error {
+	if _, ok := NotificationEvents[event.Type]; !ok {
+		return fmt.Errorf("invalid event: %s", event.Type)
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	webhooks, err := n.ListWebhooks(ctx, event.DiscordID)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	message := BuildEventMessage(event)
+	for i := range webhooks {
+		webhook := &webhooks[i]
+		if !webhook.Enabled || !slices.Contains(webhook.Events, event.Type) {
+			continue
+		}
+
+		url, err := n.secrets.Get(ctx, event.DiscordID, webhookSecret(webhook.ID))
+		if err != nil {
+			log.Errorf("failed to read url of webhook: %s for user: %s: %v", webhook.ID, event.DiscordID, err)
+			continue
+		}
+
+		err = n.deliver(ctx, url, message)
+		switch {
+		case err == nil:
+			webhook.NotFoundCount = 0
+			webhook.LastDelivered = time.Now().UTC()
+			changed = true
+		case errors.Is(err, errWebhookGone):
+			webhook.NotFoundCount++
+			if webhook.NotFoundCount >= maxWebhookNotFound {
+				log.Warnf("disabling webhook: %s for user: %s after %d not found responses", webhook.ID, event.DiscordID, webhook.NotFoundCount)
+				webhook.Enabled = false
+				webhook.DisabledReason = webhookDisabled404
+			}
+			changed = true
+		default:
+			log.Errorf("failed to deliver %s to webhook: %s for user: %s: %v", event.Type, webhook.ID, event.DiscordID, err)
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return n.s3.PutJSON(ctx, webhooksKey(event.DiscordID), webhooks)
+}
+
+// deliver posts message to a webhook retrying server errors and rate limits with exponential backoff. A 429 waits
+// for the retry_after Discord returns instead of the backoff.
+func (n *NotifierService) deliver(ctx context.Context, url string, message model.DiscordWebhookMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook message: %w", err)
+	}
+
+	backoff := initialRetryBackoff
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("failed to create webhook request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		wait := backoff
+		resp, err := n.httpClient.Do(req)
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			switch {
+			case resp.StatusCode < 300:
+				return nil
+			case resp.StatusCode == http.StatusNotFound:
+				return errWebhookGone
+			case resp.StatusCode == http.StatusTooManyRequests:
+				wait = retryAfter(resp, body, backoff)
+				err = fmt.Errorf("rate limited by discord")
+			case resp.StatusCode >= 500:
+				err = fmt.Errorf("discord returned status %d", resp.StatusCode)
+			default:
+				return fmt.Errorf("discord returned status %d: %s", resp.StatusCode, string(body))
+			}
+		}
+
+		if attempt >= maxDeliveryAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		log.Warnf("webhook delivery attempt %d failed: %v, retrying in %s", attempt, err, wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// BuildEventMessage renders an event as a Discord embed.
+func BuildEventMessage(event model.NotificationEvent) model.DiscordWebhookMessage {
+	color, ok := NotificationEvents[event.Type]
+	if !ok {
+		color = webhookEmbedFallback
+	}
+
+	embed := model.DiscordEmbed{
+		Title:       eventTitles[event.Type],
+		Description: event.Message,
+		Color:       color,
+		Timestamp:   event.Timestamp.UTC().Format(time.RFC3339),
+		Footer:      &model.DiscordEmbedFooter{Text: webhookUsername},
+	}
+
+	if event.ServerID != "" {
+		embed.Fields = append(embed.Fields, model.DiscordEmbedField{Name: "Server", Value: event.ServerID, Inline: true})
+	}
+
+	// Map iteration order is random so fields are sorted to keep embeds stable
+	names := make([]string, 0, len(event.Fields))
+	for name := range event.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		embed.Fields = append(embed.Fields, model.DiscordEmbedField{Name: name, Value: event.Fields[name], Inline: true})
+	}
+
+	return model.DiscordWebhookMessage{
+		Username: webhookUsername,
+		Embeds:   []model.DiscordEmbed{embed},
+	}
+}
+
+// retryAfter reads how long Discord asked us to wait from the retry_after body field or Retry-After header.
+func retryAfter(resp *http.Response, body []byte, fallback time.Duration) time.Duration {
+	var rateLimit struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+
+	wait := fallback
+	if json.Unmarshal(body, &rateLimit) == nil && rateLimit.RetryAfter > 0 {
+		wait = time.Duration(rateLimit.RetryAfter * float64(time.Second))
+	} else if seconds, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil {
+		wait = time.Duration(seconds * float64(time.Second))
+	}
+
+	return min(wait, maxRetryAfter)
+}
+
+// maskWebhookURL replaces the token of a webhook URL, keeping its last 4 characters so users can tell webhooks apart.
+func maskWebhookURL(url string) string {
+	idx := strings.LastIndex(url, "/")
+	token := url[idx+1:]
+	if len(token) > 4 {
+		token = token[len(token)-4:]
+	} else {
+		token = ""
+	}
+	return url[:idx+1] + webhookTokenMask + token
+}
+
+func isMaskedWebhookURL(url string) bool {
+	return strings.Contains(url, webhookTokenMask)
+}
+
+func webhookSecret(webhookId string) string {
+	return "webhook:" + webhookId
+}
+
+func webhooksKey(discordId string) string {
+	return fmt.Sprintf("%s%s/%s", settingsPrefix, discordId, webhookSettingsFile)
+}
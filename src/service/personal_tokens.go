@@ -0,0 +1,282 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/util"
+	log "github.com/sirupsen/logrus"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	personalTokensPrefix     = "tokens/"
+	personalTokenIndexPrefix = "tokens/index/"
+	personalTokenPrefix      = "hhpat_"
+	maxPersonalTokensPerUser = 20
+	maxPersonalTokenName     = 64
+
+	defaultPersonalTokenExpiryDays = 30
+	maxPersonalTokenExpiryDays     = 365
+
+	// tokenTouchInterval is how often a token's last used time is written as it is used.
+	tokenTouchInterval = 5 * time.Minute
+)
+
+var (
+	// PersonalTokenScopes are the scopes a personal access token can be granted.
+	PersonalTokenScopes = []string{model.ScopeFilesRead, model.ScopeFilesWrite, model.ScopeServersControl}
+
+	// tokenTouches records when each token was last written by this process so a request does not need to write
+	// its token every time.
+	tokenTouches sync.Map
+
+	ErrPersonalTokenNotFound = errors.New("personal access token does not exist")
+	ErrInvalidPersonalToken  = errors.New("invalid or expired personal access token")
+)
+
+// personalTokenOwner is stored at tokens/index/{tokenId}.json so a token can be resolved to its owner.
+type personalTokenOwner struct {
+	DiscordID string `json:"discordId"`
+}
+
+// PersonalTokenService manages personal access tokens which let automation such as CI call the API without a
+// Cognito refresh token. A user's tokens are stored at tokens/{discordId}.json. Tokens have the form
+// hhpat_{id}_{secret} and the id is indexed to its owner so only the SHA-256 of the token needs to be stored.
+type PersonalTokenService struct {
+	s3    *S3Service
+	audit *AuditService
+}
+
+// MakePersonalTokenService creates a new personal token service. Every authenticated use of a token is written to
+// audit when it is not nil.
+func MakePersonalTokenService(s3 *S3Service, audit *AuditService) *PersonalTokenService {
+	return &PersonalTokenService{s3: s3, audit: audit}
+}
+
+// IsPersonalToken returns true when token looks like a personal access token rather than a Cognito token.
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, personalTokenPrefix)
+}
+
+// List returns the user's tokens without their hashes.
+func (p *PersonalTokenService) List(ctx context.Context, discordId string) ([]model.PersonalAccessToken, error) {
+	tokens, err := p.load(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tokens {
+		tokens[i].Hash = ""
+	}
+	return tokens, nil
+}
+
+// Create issues a new token for the user. The token is only returned here.
+func (p *PersonalTokenService) Create(ctx context.Context, discordId string, req model.PersonalAccessTokenRequest) (*model.CreatedPersonalAccessToken, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxPersonalTokenName {
+		return nil, fmt.Errorf("name is required and must be at most %d characters", maxPersonalTokenName)
+	}
+
+	if len(req.Scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	for _, scope := range req.Scopes {
+		if !slices.Contains(PersonalTokenScopes, scope) {
+			return nil, fmt.Errorf("invalid scope: %s", scope)
+		}
+	}
+
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = defaultPersonalTokenExpiryDays
+	}
+	if days < 1 || days > maxPersonalTokenExpiryDays {
+		return nil, fmt.Errorf("expiresInDays must be between 1 and %d", maxPersonalTokenExpiryDays)
+	}
+
+	tokens, err := p.load(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expired tokens are dropped here so they do not count towards the limit
+	now := time.Now().UTC()
+	var expired []string
+	tokens = slices.DeleteFunc(tokens, func(t model.PersonalAccessToken) bool {
+		if now.After(t.ExpiresAt) {
+			expired = append(expired, personalTokenIndexKey(t.ID))
+			return true
+		}
+		return false
+	})
+	if len(tokens) >= maxPersonalTokensPerUser {
+		return nil, fmt.Errorf("a maximum of %d personal access tokens can be created", maxPersonalTokensPerUser)
+	}
+
+	id, err := util.MakeCrypto().GenerateID(8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	secret, err := util.MakeCrypto().GenerateID(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	raw := fmt.Sprintf("%s%s_%s", personalTokenPrefix, id, secret)
+	token := model.PersonalAccessToken{
+		ID:        id,
+		Name:      name,
+		Prefix:    personalTokenPrefix + id,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		Hash:      hashPersonalToken(raw),
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, days),
+	}
+
+	if err = p.s3.PutJSON(ctx, personalTokenIndexKey(id), personalTokenOwner{DiscordID: discordId}); err != nil {
+		return nil, err
+	}
+
+	if err = p.save(ctx, discordId, append(tokens, token)); err != nil {
+		return nil, err
+	}
+
+	if len(expired) > 0 {
+		if err = p.s3.DeleteObjects(ctx, expired); err != nil {
+			log.Warnf("failed to delete expired tokens of user: %s: %v", discordId, err)
+		}
+	}
+
+	token.Hash = ""
+	return &model.CreatedPersonalAccessToken{PersonalAccessToken: token, Token: raw}, nil
+}
+
+// Revoke deletes one of the user's tokens.
+func (p *PersonalTokenService) Revoke(ctx context.Context, discordId, tokenId string) error {
+	tokens, err := p.load(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	idx := slices.IndexFunc(tokens, func(t model.PersonalAccessToken) bool { return t.ID == tokenId })
+	if idx == -1 {
+		return ErrPersonalTokenNotFound
+	}
+
+	if err = p.save(ctx, discordId, slices.Delete(tokens, idx, idx+1)); err != nil {
+		return err
+	}
+
+	return p.s3.DeleteObject(ctx, personalTokenIndexKey(tokenId))
+}
+
+// Authenticate resolves a token to the Discord id of its owner. It returns ErrInvalidPersonalToken when the token is
+// malformed, unknown, revoked or expired. The token's last used time and IP are updated and the use is written to
+// the audit log under the given route.
+func (p *PersonalTokenService) Authenticate(ctx context.Context, raw, route string, meta model.RequestMeta) (string, *model.PersonalAccessToken, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(raw, personalTokenPrefix), "_")
+	if !IsPersonalToken(raw) || !ok || id == "" {
+		return "", nil, ErrInvalidPersonalToken
+	}
+
+	var owner personalTokenOwner
+	err := p.s3.GetJSON(ctx, personalTokenIndexKey(id), &owner)
+	if errors.Is(err, ErrObjectNotFound) {
+		return "", nil, ErrInvalidPersonalToken
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	tokens, err := p.load(ctx, owner.DiscordID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	idx := slices.IndexFunc(tokens, func(t model.PersonalAccessToken) bool { return t.ID == id })
+	if idx == -1 {
+		return "", nil, ErrInvalidPersonalToken
+	}
+
+	token := tokens[idx]
+	if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashPersonalToken(raw))) != 1 || time.Now().After(token.ExpiresAt) {
+		return "", nil, ErrInvalidPersonalToken
+	}
+
+	if last, ok := tokenTouches.Load(id); !ok || time.Since(last.(time.Time)) >= tokenTouchInterval {
+		now := time.Now().UTC()
+		tokens[idx].LastUsedAt = &now
+		tokens[idx].LastUsedIP = meta.IP
+		if err = p.save(ctx, owner.DiscordID, tokens); err != nil {
+			log.Errorf("failed to update last use of token: %s: %v", token.Prefix, err)
+		} else {
+			tokenTouches.Store(id, now)
+		}
+	}
+
+	if p.audit != nil {
+		p.audit.Record(ctx, model.AuditRecord{
+			RequestMeta: meta,
+			Actor:       owner.DiscordID,
+			Subject:     owner.DiscordID,
+			Action:      model.AuditActionTokenUse,
+			Target:      fmt.Sprintf("%s %s", token.Prefix, route),
+			Result:      model.AuditResultSuccess,
+		})
+	}
+
+	token.Hash = ""
+	return owner.DiscordID, &token, nil
+}
+
+// DeleteAll removes every token belonging to the user.
+func (p *PersonalTokenService) DeleteAll(ctx context.Context, discordId string) error {
+	tokens, err := p.load(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{personalTokensKey(discordId)}
+	for _, token := range tokens {
+		keys = append(keys, personalTokenIndexKey(token.ID))
+	}
+
+	return p.s3.DeleteObjects(ctx, keys)
+}
+
+func (p *PersonalTokenService) load(ctx context.Context, discordId string) ([]model.PersonalAccessToken, error) {
+	tokens := make([]model.PersonalAccessToken, 0)
+	err := p.s3.GetJSON(ctx, personalTokensKey(discordId), &tokens)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (p *PersonalTokenService) save(ctx context.Context, discordId string, tokens []model.PersonalAccessToken) error {
+	return p.s3.PutJSON(ctx, personalTokensKey(discordId), tokens)
+}
+
+func hashPersonalToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func personalTokensKey(discordId string) string {
+	return fmt.Sprintf("%s%s.json", personalTokensPrefix, discordId)
+}
+
+func personalTokenIndexKey(tokenId string) string {
+	return fmt.Sprintf("%s%s.json", personalTokenIndexPrefix, tokenId)
+}
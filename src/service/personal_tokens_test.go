@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/cbartram/hearthhub/src/model"
+	"strings"
+	"testing"
+)
+
+func TestHashPersonalToken(t *testing.T) {
+	raw := personalTokenPrefix + "abc_secret"
+	hash := hashPersonalToken(raw)
+
+	if hash != hashPersonalToken(raw) {
+		t.Error("hashing the same token twice gave different hashes")
+	}
+	if len(hash) != 64 || strings.Contains(hash, "secret") {
+		t.Errorf("hashPersonalToken() = %s, want a hex encoded sha-256", hash)
+	}
+	if hash == hashPersonalToken(personalTokenPrefix+"abc_secreT") {
+		t.Error("tokens differing by one character have the same hash")
+	}
+}
+
+func TestIsPersonalToken(t *testing.T) {
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{token: personalTokenPrefix + "abc_secret", want: true},
+		{token: "eyJhbGciOiJSUzI1NiJ9.payload.signature"},
+		{token: "HHPAT_abc_secret"},
+		{token: ""},
+	}
+
+	for _, tt := range tests {
+		if got := IsPersonalToken(tt.token); got != tt.want {
+			t.Errorf("IsPersonalToken(%q) = %v, want %v", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestCreatePersonalTokenValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		req  model.PersonalAccessTokenRequest
+	}{
+		{name: "missing name", req: model.PersonalAccessTokenRequest{Name: "  ", Scopes: []string{model.ScopeFilesRead}}},
+		{name: "name too long", req: model.PersonalAccessTokenRequest{Name: strings.Repeat("a", maxPersonalTokenName+1), Scopes: []string{model.ScopeFilesRead}}},
+		{name: "no scopes", req: model.PersonalAccessTokenRequest{Name: "ci"}},
+		{name: "unknown scope", req: model.PersonalAccessTokenRequest{Name: "ci", Scopes: []string{model.ScopeFilesRead, "admin"}}},
+		{name: "negative expiry", req: model.PersonalAccessTokenRequest{Name: "ci", Scopes: []string{model.ScopeFilesRead}, ExpiresInDays: -1}},
+		{name: "expiry too long", req: model.PersonalAccessTokenRequest{Name: "ci", Scopes: []string{model.ScopeFilesRead}, ExpiresInDays: maxPersonalTokenExpiryDays + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Requests are validated before anything is read from s3 so the service needs no bucket
+			tokens := &PersonalTokenService{}
+			if _, err := tokens.Create(context.Background(), "1", tt.req); err == nil {
+				t.Error("Create() error = nil, want the request rejected")
+			}
+		})
+	}
+}
+
+func TestAuthenticateMalformedPersonalToken(t *testing.T) {
+	tests := []string{
+		"",
+		"abc_secret",
+		personalTokenPrefix,
+		personalTokenPrefix + "abc",
+		personalTokenPrefix + "_secret",
+	}
+
+	for _, raw := range tests {
+		// Malformed tokens are rejected before anything is read from s3 so the service needs no bucket
+		tokens := &PersonalTokenService{}
+		if _, _, err := tokens.Authenticate(context.Background(), raw, "/test", model.RequestMeta{}); !errors.Is(err, ErrInvalidPersonalToken) {
+			t.Errorf("Authenticate(%q) error = %v, want ErrInvalidPersonalToken", raw, err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const usageFile = "usage.json"
+
+// QuotaPrefixes are the prefixes whose objects count towards a user's storage quota.
+var QuotaPrefixes = []string{"mods", "configs", "backups"}
+
+// QuotaExceededError is returned when storing more bytes would take a user over the storage quota of their plan.
+type QuotaExceededError struct {
+	Plan           string
+	QuotaBytes     int64
+	UsedBytes      int64
+	RequestedBytes int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %d of %d bytes used, %d more requested", e.UsedBytes, e.QuotaBytes, e.RequestedBytes)
+}
+
+// QuotaService keeps a running total of the bytes and objects each user stores under QuotaPrefixes. Totals are
+// updated incrementally on upload and delete and periodically reconciled against a listing of the bucket to correct
+// any drift, such as files written by the file manager or lost concurrent updates.
+type QuotaService struct {
+	s3           *S3Service
+	entitlements *EntitlementService
+	mu           sync.Mutex
+}
+
+// MakeQuotaService creates a new quota service.
+func MakeQuotaService(s3 *S3Service, entitlements *EntitlementService) *QuotaService {
+	return &QuotaService{s3: s3, entitlements: entitlements}
+}
+
+// Usage returns a user's storage usage along with the quota of their plan. Usage is reconciled the first time it is
+// requested for a user.
+func (q *QuotaService) Usage(ctx context.Context, discordId string) (*model.StorageUsage, error) {
+	usage, err := q.getUsage(ctx, discordId)
+	if errors.Is(err, ErrObjectNotFound) {
+		usage, err = q.Reconcile(ctx, discordId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entitlements, err := q.entitlements.Entitlements(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	usage.QuotaBytes = entitlements.Limits.StorageQuotaBytes
+	return usage, nil
+}
+
+// CheckQuota returns a *QuotaExceededError when storing additionalBytes more would exceed the user's quota.
+func (q *QuotaService) CheckQuota(ctx context.Context, discordId string, additionalBytes int64) error {
+	usage, err := q.Usage(ctx, discordId)
+	if err != nil {
+		return err
+	}
+
+	if additionalBytes > 0 && usage.TotalBytes+additionalBytes > usage.QuotaBytes {
+		entitlements, err := q.entitlements.Entitlements(ctx, discordId)
+		if err != nil {
+			return err
+		}
+
+		return &QuotaExceededError{
+			Plan:           entitlements.Plan,
+			QuotaBytes:     usage.QuotaBytes,
+			UsedBytes:      usage.TotalBytes,
+			RequestedBytes: additionalBytes,
+		}
+	}
+
+	return nil
+}
+
+// Record adds deltaBytes and deltaObjects, which are negative for deletes, to the usage of prefix.
+func (q *QuotaService) Record(ctx context.Context, discordId, prefix string, deltaBytes, deltaObjects int64) error {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !slices.Contains(QuotaPrefixes, prefix) {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage, err := q.getUsage(ctx, discordId)
+	if errors.Is(err, ErrObjectNotFound) {
+		// The reconcile listing already includes this change
+		_, err = q.reconcile(ctx, discordId)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	current := usage.Prefixes[prefix]
+	current.Bytes = max(current.Bytes+deltaBytes, 0)
+	current.Objects = max(current.Objects+deltaObjects, 0)
+	usage.Prefixes[prefix] = current
+	usage.UpdatedAt = time.Now().UTC()
+	totalUsage(usage)
+
+	return q.s3.PutJSON(ctx, usageKey(discordId), usage)
+}
+
+// Reconcile recomputes a user's usage from a listing of the bucket.
+func (q *QuotaService) Reconcile(ctx context.Context, discordId string) (*model.StorageUsage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.reconcile(ctx, discordId)
+}
+
+func (q *QuotaService) reconcile(ctx context.Context, discordId string) (*model.StorageUsage, error) {
+	now := time.Now().UTC()
+	usage := &model.StorageUsage{
+		DiscordID:    discordId,
+		Prefixes:     make(map[string]model.PrefixUsage),
+		ReconciledAt: now,
+		UpdatedAt:    now,
+	}
+
+	for _, prefix := range QuotaPrefixes {
+		objects, err := q.s3.ListObjects(fmt.Sprintf("%s/%s/", prefix, discordId))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s for user: %s: %w", prefix, discordId, err)
+		}
+
+		var prefixUsage model.PrefixUsage
+		for _, obj := range objects {
+			if obj.Size != nil {
+				prefixUsage.Bytes += *obj.Size
+			}
+			prefixUsage.Objects++
+		}
+		usage.Prefixes[prefix] = prefixUsage
+	}
+
+	totalUsage(usage)
+	return usage, q.s3.PutJSON(ctx, usageKey(discordId), usage)
+}
+
+// ReconcileAll reconciles the usage of every user with objects under QuotaPrefixes. Failures for one user do not
+// stop the others.
+func (q *QuotaService) ReconcileAll(ctx context.Context) error {
+	users := make(map[string]bool)
+	for _, prefix := range QuotaPrefixes {
+		objects, err := q.s3.ListObjects(prefix + "/")
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+
+		for _, obj := range objects {
+			parts := strings.SplitN(strings.TrimPrefix(*obj.Key, prefix+"/"), "/", 2)
+			// mods/general/ holds the default mods every user can install and is not owned by anyone
+			if len(parts) == 2 && parts[0] != "" && parts[0] != "general" {
+				users[parts[0]] = true
+			}
+		}
+	}
+
+	for discordId := range users {
+		if _, err := q.Reconcile(ctx, discordId); err != nil {
+			log.Errorf("failed to reconcile storage usage for user: %s: %v", discordId, err)
+		}
+	}
+
+	log.Infof("reconciled storage usage for %d users", len(users))
+	return nil
+}
+
+// Start runs ReconcileAll every interval until ctx is cancelled.
+func (q *QuotaService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Infof("starting storage usage reconciler with interval: %s", interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.ReconcileAll(ctx); err != nil {
+				log.Errorf("storage usage reconcile failed: %v", err)
+			}
+		}
+	}
+}
+
+func (q *QuotaService) getUsage(ctx context.Context, discordId string) (*model.StorageUsage, error) {
+	var usage model.StorageUsage
+	if err := q.s3.GetJSON(ctx, usageKey(discordId), &usage); err != nil {
+		return nil, err
+	}
+
+	if usage.Prefixes == nil {
+		usage.Prefixes = make(map[string]model.PrefixUsage)
+	}
+	return &usage, nil
+}
+
+func totalUsage(usage *model.StorageUsage) {
+	usage.TotalBytes = 0
+	usage.TotalObjects = 0
+	for _, prefixUsage := range usage.Prefixes {
+		usage.TotalBytes += prefixUsage.Bytes
+		usage.TotalObjects += prefixUsage.Objects
+	}
+}
+
+func usageKey(discordId string) string {
+	return fmt.Sprintf("%s%s/%s", settingsPrefix, discordId, usageFile)
+}
@@ -0,0 +1,332 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	maxRateLimitRetries = 5
+
+	// lockoutThreshold is how many consecutive failed sign ins a Discord id is allowed before it is locked out.
+	lockoutThreshold = 5
+
+	// lockoutBase is the first lockout, each further failure doubles it up to lockoutMax.
+	lockoutBase = 30 * time.Second
+	lockoutMax  = time.Hour
+
+	// lockoutFailureWindow is how long failures are remembered for after the last one or the end of a lockout.
+	lockoutFailureWindow = 15 * time.Minute
+)
+
+var (
+	sharedMemoryRateLimit     *MemoryRateLimitStore
+	sharedMemoryRateLimitOnce sync.Once
+
+	ErrRateLimitConflict      = errors.New("rate limit state was modified concurrently")
+	ErrRateLimitStateNotFound = errors.New("rate limit state does not exist")
+)
+
+// RateLimit is a token bucket holding Burst tokens which refills completely every Period.
+type RateLimit struct {
+	Burst  float64
+	Period time.Duration
+}
+
+// RateLimitPolicy are the buckets a route is throttled with. A request must take a token from the bucket for the
+// client IP, the Discord id it acts as and the route as a whole. A zero limit is not enforced.
+type RateLimitPolicy struct {
+	Route   string
+	PerIP   RateLimit
+	PerUser RateLimit
+	Total   RateLimit
+}
+
+var (
+	AuthRateLimit = RateLimitPolicy{
+		Route:   "cognito.auth",
+		PerIP:   RateLimit{Burst: 20, Period: time.Minute},
+		PerUser: RateLimit{Burst: 10, Period: time.Minute},
+		Total:   RateLimit{Burst: 600, Period: time.Minute},
+	}
+
+	RefreshSessionRateLimit = RateLimitPolicy{
+		Route:   "cognito.refresh_session",
+		PerIP:   RateLimit{Burst: 10, Period: time.Minute},
+		PerUser: RateLimit{Burst: 5, Period: time.Minute},
+		Total:   RateLimit{Burst: 300, Period: time.Minute},
+	}
+
+	FileUploadRateLimit = RateLimitPolicy{
+		Route:   "file.upload",
+		PerIP:   RateLimit{Burst: 60, Period: time.Minute},
+		PerUser: RateLimit{Burst: 30, Period: time.Minute},
+		Total:   RateLimit{Burst: 1200, Period: time.Minute},
+	}
+)
+
+// RateLimitStore stores token buckets and sign in failures by key. State should be forgotten once it expires.
+type RateLimitStore interface {
+	// Get returns the state stored under key or ErrRateLimitStateNotFound.
+	Get(ctx context.Context, key string) (*model.RateLimitState, error)
+
+	// Put writes state only if the stored version still equals state.Version, or nothing is stored when it is zero,
+	// otherwise it returns ErrRateLimitConflict. On success state.Version is incremented.
+	Put(ctx context.Context, state *model.RateLimitState) error
+}
+
+// RateLimiter throttles requests with token buckets and locks out Discord ids after repeated failed sign ins so a
+// leaked Discord id cannot be used to guess refresh tokens. Store errors are logged and the request is allowed
+// rather than taking the API down with the store.
+type RateLimiter struct {
+	store RateLimitStore
+	audit *AuditService
+}
+
+// MakeRateLimiter creates a rate limiter backed by the DynamoDB table named by RATE_LIMIT_TABLE_NAME so limits are
+// shared across instances, or by process memory when it is not set.
+func MakeRateLimiter(audit *AuditService) *RateLimiter {
+	return &RateLimiter{
+		store: MakeRateLimitStore(),
+		audit: audit,
+	}
+}
+
+// MakeRateLimitStore returns the DynamoDB store for the table named by RATE_LIMIT_TABLE_NAME, or the process wide
+// in-memory store when it is not set.
+func MakeRateLimitStore() RateLimitStore {
+	if table := os.Getenv("RATE_LIMIT_TABLE_NAME"); table != "" {
+		store, err := MakeDynamoRateLimitStore(table)
+		if err == nil {
+			return store
+		}
+		log.Errorf("failed to create dynamodb rate limit store, falling back to memory: %v", err)
+	}
+
+	sharedMemoryRateLimitOnce.Do(func() {
+		log.Warnf("RATE_LIMIT_TABLE_NAME is not set, rate limits will only apply to this instance")
+		sharedMemoryRateLimit = MakeMemoryRateLimitStore()
+	})
+	return sharedMemoryRateLimit
+}
+
+// Allow takes a token from every bucket of the policy which applies to the request. When any bucket is empty it
+// returns how long the caller should wait before retrying, otherwise zero. An empty discordId skips the per user
+// bucket.
+func (r *RateLimiter) Allow(ctx context.Context, policy RateLimitPolicy, ip, discordId string) time.Duration {
+	buckets := map[string]RateLimit{
+		fmt.Sprintf("%s:route", policy.Route):     policy.Total,
+		fmt.Sprintf("%s:ip:%s", policy.Route, ip): policy.PerIP,
+	}
+	if discordId != "" {
+		buckets[fmt.Sprintf("%s:user:%s", policy.Route, discordId)] = policy.PerUser
+	}
+
+	var wait time.Duration
+	for key, limit := range buckets {
+		if limit.Burst <= 0 {
+			continue
+		}
+
+		retryAfter, err := r.take(ctx, key, limit)
+		if err != nil {
+			log.Errorf("failed to check rate limit: %s: %v", key, err)
+			continue
+		}
+		wait = max(wait, retryAfter)
+	}
+
+	return wait
+}
+
+// take removes a token from the bucket after refilling it for the time since it was last used.
+func (r *RateLimiter) take(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	var wait time.Duration
+	rate := limit.Burst / limit.Period.Seconds()
+
+	err := r.update(ctx, key, func(state *model.RateLimitState, now time.Time) {
+		if state.Version == 0 {
+			state.Tokens = limit.Burst
+		} else {
+			state.Tokens = math.Min(limit.Burst, state.Tokens+now.Sub(state.UpdatedAt).Seconds()*rate)
+		}
+
+		wait = 0
+		if state.Tokens >= 1 {
+			state.Tokens--
+		} else {
+			wait = time.Duration((1 - state.Tokens) / rate * float64(time.Second))
+		}
+
+		state.UpdatedAt = now
+		state.ExpiresAt = now.Add(limit.Period)
+	})
+
+	return wait, err
+}
+
+// LockedOut returns how long the Discord id remains locked out for after repeated failed sign ins, otherwise zero.
+func (r *RateLimiter) LockedOut(ctx context.Context, discordId string) time.Duration {
+	state, err := r.store.Get(ctx, lockoutKey(discordId))
+	if errors.Is(err, ErrRateLimitStateNotFound) {
+		return 0
+	}
+	if err != nil {
+		log.Errorf("failed to check lockout for user: %s: %v", discordId, err)
+		return 0
+	}
+
+	return max(0, time.Until(state.LockedUntil))
+}
+
+// RecordAuthFailure counts a failed sign in against the Discord id. Once lockoutThreshold failures are reached the id
+// is locked out for lockoutBase, doubling with every further failure, and the lockout is written to the audit log.
+func (r *RateLimiter) RecordAuthFailure(ctx context.Context, meta model.RequestMeta, discordId string) {
+	var lockedFor time.Duration
+	err := r.update(ctx, lockoutKey(discordId), func(state *model.RateLimitState, now time.Time) {
+		lastActive := state.UpdatedAt
+		if state.LockedUntil.After(lastActive) {
+			lastActive = state.LockedUntil
+		}
+		if now.Sub(lastActive) > lockoutFailureWindow {
+			state.Failures = 0
+		}
+
+		state.Failures++
+		lockedFor = 0
+		if state.Failures >= lockoutThreshold {
+			lockedFor = lockoutDuration(state.Failures)
+			state.LockedUntil = now.Add(lockedFor)
+		}
+
+		state.UpdatedAt = now
+		state.ExpiresAt = now.Add(lockedFor + lockoutFailureWindow)
+	})
+
+	if err != nil {
+		log.Errorf("failed to record auth failure for user: %s: %v", discordId, err)
+		return
+	}
+
+	if lockedFor > 0 {
+		log.Warnf("user: %s locked out for %s after repeated auth failures", discordId, lockedFor)
+		r.audit.Record(ctx, model.AuditRecord{
+			RequestMeta: meta,
+			Actor:       discordId,
+			Subject:     discordId,
+			Action:      model.AuditActionLockout,
+			Result:      model.AuditResultFailure,
+			Error:       fmt.Sprintf("locked out for %s after repeated auth failures", lockedFor),
+		})
+	}
+}
+
+// RecordAuthSuccess clears the failed sign ins counted against the Discord id.
+func (r *RateLimiter) RecordAuthSuccess(ctx context.Context, discordId string) {
+	state, err := r.store.Get(ctx, lockoutKey(discordId))
+	if errors.Is(err, ErrRateLimitStateNotFound) || (err == nil && state.Failures == 0) {
+		return
+	}
+
+	err = r.update(ctx, lockoutKey(discordId), func(state *model.RateLimitState, now time.Time) {
+		state.Failures = 0
+		state.UpdatedAt = now
+	})
+	if err != nil {
+		log.Errorf("failed to reset auth failures for user: %s: %v", discordId, err)
+	}
+}
+
+// update applies fn to the state stored under key and writes it, re-reading and retrying when another instance got
+// there first. A key without state starts from an empty one.
+func (r *RateLimiter) update(ctx context.Context, key string, fn func(state *model.RateLimitState, now time.Time)) error {
+	for attempt := 0; attempt < maxRateLimitRetries; attempt++ {
+		state, err := r.store.Get(ctx, key)
+		if errors.Is(err, ErrRateLimitStateNotFound) {
+			state = &model.RateLimitState{Key: key}
+		} else if err != nil {
+			return err
+		}
+
+		fn(state, time.Now())
+
+		err = r.store.Put(ctx, state)
+		if !errors.Is(err, ErrRateLimitConflict) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w: gave up after %d attempts", ErrRateLimitConflict, maxRateLimitRetries)
+}
+
+func lockoutDuration(failures int) time.Duration {
+	exponent := failures - lockoutThreshold
+	if exponent >= 16 {
+		return lockoutMax
+	}
+	return min(lockoutMax, lockoutBase<<exponent)
+}
+
+func lockoutKey(discordId string) string {
+	return "lockout:" + discordId
+}
+
+// MemoryRateLimitStore keeps rate limit state in process so limits only apply per instance. Expired state is swept
+// as new keys are written.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	states    map[string]model.RateLimitState
+	lastSweep time.Time
+}
+
+// MakeMemoryRateLimitStore creates an empty in-memory store.
+func MakeMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{states: make(map[string]model.RateLimitState)}
+}
+
+func (m *MemoryRateLimitStore) Get(ctx context.Context, key string) (*model.RateLimitState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.states[key]
+	if !ok || time.Now().After(state.ExpiresAt) {
+		return nil, ErrRateLimitStateNotFound
+	}
+
+	return &state, nil
+}
+
+func (m *MemoryRateLimitStore) Put(ctx context.Context, state *model.RateLimitState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	stored, ok := m.states[state.Key]
+	if ok && now.After(stored.ExpiresAt) {
+		stored = model.RateLimitState{}
+	}
+
+	if stored.Version != state.Version {
+		return ErrRateLimitConflict
+	}
+
+	if now.Sub(m.lastSweep) > time.Minute {
+		for key, s := range m.states {
+			if now.After(s.ExpiresAt) {
+				delete(m.states, key)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	state.Version++
+	m.states[state.Key] = *state
+	return nil
+}
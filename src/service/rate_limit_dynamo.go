@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/cbartram/hearthhub/src/model"
+	"strconv"
+	"time"
+)
+
+// DynamoRateLimitStore shares rate limit state between instances through a DynamoDB table with a "key" string
+// partition key. State is stored as a JSON document alongside a numeric version attribute which conditional writes
+// are checked against and an "expiresAt" epoch which should be configured as the table's TTL attribute.
+type DynamoRateLimitStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// MakeDynamoRateLimitStore creates a store which reads and writes table.
+func MakeDynamoRateLimitStore(table string) (*DynamoRateLimitStore, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("error loading default aws config: %w", err)
+	}
+
+	return &DynamoRateLimitStore{
+		client: dynamodb.NewFromConfig(cfg),
+		table:  table,
+	}, nil
+}
+
+func (d *DynamoRateLimitStore) Get(ctx context.Context, key string) (*model.RateLimitState, error) {
+	out, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: key}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rate limit state: %s: %w", key, err)
+	}
+
+	if out.Item == nil {
+		return nil, ErrRateLimitStateNotFound
+	}
+
+	var state model.RateLimitState
+	if err = json.Unmarshal([]byte(stringAttribute(out.Item, "state")), &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rate limit state: %s: %w", key, err)
+	}
+
+	if version, ok := out.Item["version"].(*types.AttributeValueMemberN); ok {
+		state.Version, _ = strconv.ParseInt(version.Value, 10, 64)
+	}
+
+	// DynamoDB removes expired items lazily so they are treated as gone here, the next write replaces them
+	if time.Now().After(state.ExpiresAt) {
+		return nil, ErrRateLimitStateNotFound
+	}
+
+	return &state, nil
+}
+
+func (d *DynamoRateLimitStore) Put(ctx context.Context, state *model.RateLimitState) error {
+	next := *state
+	next.Version = state.Version + 1
+
+	document, err := json.Marshal(next)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rate limit state: %w", err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]types.AttributeValue{
+			"key":       &types.AttributeValueMemberS{Value: state.Key},
+			"state":     &types.AttributeValueMemberS{Value: string(document)},
+			"version":   &types.AttributeValueMemberN{Value: strconv.FormatInt(next.Version, 10)},
+			"expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(next.ExpiresAt.Unix(), 10)},
+		},
+		ExpressionAttributeNames: map[string]string{"#key": "key"},
+		ConditionExpression:      aws.String("attribute_not_exists(#key) OR expiresAt <= :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	}
+
+	if state.Version > 0 {
+		input.ExpressionAttributeNames = nil
+		input.ConditionExpression = aws.String("version = :expected")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":expected": &types.AttributeValueMemberN{Value: strconv.FormatInt(state.Version, 10)},
+		}
+	}
+
+	_, err = d.client.PutItem(ctx, input)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrRateLimitConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put rate limit state: %s: %w", state.Key, err)
+	}
+
+	state.Version = next.Version
+	return nil
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func makeTestRateLimiter(t *testing.T) *RateLimiter {
+	return &RateLimiter{
+		store: MakeMemoryRateLimitStore(),
+		audit: &AuditService{sink: MakeFileAuditSink(filepath.Join(t.TempDir(), "audit.log"))},
+	}
+}
+
+// conflictingRateLimitStore makes the first conflicts writes lose to another instance.
+type conflictingRateLimitStore struct {
+	*MemoryRateLimitStore
+	conflicts int
+	puts      int
+}
+
+func (c *conflictingRateLimitStore) Put(ctx context.Context, state *model.RateLimitState) error {
+	c.puts++
+	if c.puts <= c.conflicts {
+		return ErrRateLimitConflict
+	}
+	return c.MemoryRateLimitStore.Put(ctx, state)
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	ctx := context.Background()
+	policy := RateLimitPolicy{
+		Route:   "test",
+		PerIP:   RateLimit{Burst: 3, Period: time.Minute},
+		PerUser: RateLimit{Burst: 4, Period: time.Minute},
+	}
+
+	t.Run("per ip bucket", func(t *testing.T) {
+		limiter := makeTestRateLimiter(t)
+		for i := 0; i < 3; i++ {
+			if wait := limiter.Allow(ctx, policy, "10.0.0.1", ""); wait != 0 {
+				t.Fatalf("request %d waited %s, want it allowed", i+1, wait)
+			}
+		}
+
+		// The bucket refills at 3 tokens a minute so the next token is 20 seconds away
+		wait := limiter.Allow(ctx, policy, "10.0.0.1", "")
+		if wait <= 19*time.Second || wait > 20*time.Second {
+			t.Errorf("wait = %s, want about 20s", wait)
+		}
+
+		if wait = limiter.Allow(ctx, policy, "10.0.0.2", ""); wait != 0 {
+			t.Errorf("another ip waited %s, want it allowed", wait)
+		}
+	})
+
+	t.Run("per user bucket spans ips", func(t *testing.T) {
+		limiter := makeTestRateLimiter(t)
+		for i := 0; i < 4; i++ {
+			if wait := limiter.Allow(ctx, policy, fmt.Sprintf("10.0.1.%d", i), "1"); wait != 0 {
+				t.Fatalf("request %d waited %s, want it allowed", i+1, wait)
+			}
+		}
+
+		if wait := limiter.Allow(ctx, policy, "10.0.2.1", "1"); wait == 0 {
+			t.Error("fifth request for the same user was allowed")
+		}
+	})
+
+	t.Run("zero limits are not enforced", func(t *testing.T) {
+		limiter := makeTestRateLimiter(t)
+		for i := 0; i < 100; i++ {
+			if wait := limiter.Allow(ctx, RateLimitPolicy{Route: "open"}, "10.0.0.1", "1"); wait != 0 {
+				t.Fatalf("request %d waited %s, want it allowed", i+1, wait)
+			}
+		}
+	})
+
+	t.Run("retries conflicting writes", func(t *testing.T) {
+		store := &conflictingRateLimitStore{MemoryRateLimitStore: MakeMemoryRateLimitStore(), conflicts: maxRateLimitRetries - 1}
+		limiter := &RateLimiter{store: store}
+		limit := RateLimit{Burst: 1, Period: time.Minute}
+
+		if _, err := limiter.take(ctx, "retry", limit); err != nil {
+			t.Fatalf("take() error = %v", err)
+		}
+
+		if wait, err := limiter.take(ctx, "retry", limit); err != nil || wait == 0 {
+			t.Errorf("take() = %s, %v, want the token taken by the retried write to be gone", wait, err)
+		}
+	})
+
+	t.Run("store errors allow the request", func(t *testing.T) {
+		store := &conflictingRateLimitStore{MemoryRateLimitStore: MakeMemoryRateLimitStore(), conflicts: 1000}
+		limiter := &RateLimiter{store: store}
+		if wait := limiter.Allow(ctx, policy, "10.0.0.1", "1"); wait != 0 {
+			t.Errorf("wait = %s, want the request allowed when the store is unavailable", wait)
+		}
+	})
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: lockoutThreshold, want: lockoutBase},
+		{failures: lockoutThreshold + 1, want: 2 * lockoutBase},
+		{failures: lockoutThreshold + 3, want: 8 * lockoutBase},
+		{failures: lockoutThreshold + 7, want: lockoutMax},
+		{failures: 1000, want: lockoutMax},
+	}
+
+	for _, tt := range tests {
+		if got := lockoutDuration(tt.failures); got != tt.want {
+			t.Errorf("lockoutDuration(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRecordAuthFailure(t *testing.T) {
+	ctx := context.Background()
+	limiter := makeTestRateLimiter(t)
+	meta := model.RequestMeta{IP: "10.0.0.1"}
+
+	for i := 1; i < lockoutThreshold; i++ {
+		limiter.RecordAuthFailure(ctx, meta, "1")
+		if locked := limiter.LockedOut(ctx, "1"); locked != 0 {
+			t.Fatalf("locked out for %s after %d failures", locked, i)
+		}
+	}
+
+	limiter.RecordAuthFailure(ctx, meta, "1")
+	if locked := limiter.LockedOut(ctx, "1"); locked <= lockoutBase-time.Second || locked > lockoutBase {
+		t.Fatalf("locked out for %s after %d failures, want %s", locked, lockoutThreshold, lockoutBase)
+	}
+
+	limiter.RecordAuthFailure(ctx, meta, "1")
+	if locked := limiter.LockedOut(ctx, "1"); locked <= 2*lockoutBase-time.Second || locked > 2*lockoutBase {
+		t.Fatalf("locked out for %s after another failure, want %s", locked, 2*lockoutBase)
+	}
+
+	if locked := limiter.LockedOut(ctx, "2"); locked != 0 {
+		t.Errorf("another user is locked out for %s", locked)
+	}
+
+	limiter.RecordAuthSuccess(ctx, "1")
+	state, err := limiter.store.Get(ctx, lockoutKey("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Failures != 0 {
+		t.Errorf("failures = %d after a successful sign in, want 0", state.Failures)
+	}
+}
@@ -0,0 +1,451 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/util"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	restorePrefix       = "restores/"
+	preRestoreLabel     = "pre-restore"
+	restoreStepStop     = "stop"
+	restoreStepSnapshot = "snapshot"
+	restoreStepInstall  = "install"
+	restoreStepStart    = "start"
+	restoreJobTimeout   = 30 * time.Minute
+	restoreStaleAfter   = 10 * time.Minute
+)
+
+var (
+	ErrRestoreNotFound   = errors.New("restore does not exist")
+	ErrRestoreInProgress = errors.New("a restore is already in progress")
+
+	// errRestoreClaimLost is returned when another worker has claimed a restore while it was being run.
+	errRestoreClaimLost = errors.New("restore was claimed by another worker")
+)
+
+// RestoreService makes a backed up world the running world of a server. The current world is snapshot before
+// anything is changed so a failed restore can be rolled back automatically. Restores are queued in S3 and only run
+// by the worker which claimed them, which is invoked asynchronously when RESTORES_FUNCTION_NAME names its lambda.
+type RestoreService struct {
+	s3             *S3Service
+	server         *ServerService
+	fileManager    *FileManagerService
+	cognito        *CognitoService
+	worker         *lambda.Client
+	workerFunction string
+}
+
+// MakeRestoreService creates a new restore service.
+func MakeRestoreService(s3 *S3Service, server *ServerService, fileManager *FileManagerService, cognito *CognitoService) *RestoreService {
+	restores := &RestoreService{
+		s3:          s3,
+		server:      server,
+		fileManager: fileManager,
+		cognito:     cognito,
+	}
+
+	if function := os.Getenv("RESTORES_FUNCTION_NAME"); function != "" {
+		cfg, err := config.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			log.Errorf("error loading default aws config, restores are left for the scheduled worker: %v", err)
+		} else {
+			restores.worker = lambda.NewFromConfig(cfg)
+			restores.workerFunction = function
+		}
+	}
+
+	return restores
+}
+
+// ValidateRestoreRequest ensures the world set is a .fwl and .db pair from one of the backup prefixes of the server
+// owner, whose Discord id is the server id.
+func ValidateRestoreRequest(ownerId string, req model.RestoreRequest) error {
+	if path.Ext(req.Fwl) != ".fwl" || path.Ext(req.Db) != ".db" {
+		return errors.New("a world set requires both a .fwl and a .db file")
+	}
+
+	for _, key := range []string{req.Fwl, req.Db} {
+		if !strings.HasPrefix(key, fmt.Sprintf("backups/%s/", ownerId)) && !strings.HasPrefix(key, fmt.Sprintf("%s%s/", autoBackupPrefix, ownerId)) {
+			return fmt.Errorf("file: %s is not one of the server's backups", key)
+		}
+	}
+
+	return nil
+}
+
+// GetRestore returns the current state of a restore job.
+func (r *RestoreService) GetRestore(ctx context.Context, serverId, restoreId string) (*model.RestoreJob, error) {
+	var job model.RestoreJob
+	err := r.s3.GetJSON(ctx, restoreKey(serverId, restoreId), &job)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, ErrRestoreNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// StartRestore queues a restore which stops the server, snapshots its current world to valheim-backups-auto with a
+// pre-restore label, installs the requested world and starts the server again. The job is persisted before it is
+// dispatched to a worker so its progress can be polled with GetRestore. The world must come from the backups of the
+// server owner, whose installed backups are updated once it is running.
+func (r *RestoreService) StartRestore(ctx context.Context, serverId string, req model.RestoreRequest) (*model.RestoreJob, error) {
+	if err := ValidateRestoreRequest(serverId, req); err != nil {
+		return nil, err
+	}
+
+	if r.server == nil || r.fileManager == nil {
+		return nil, errors.New("restores require both the server api and file manager to be configured")
+	}
+
+	for _, key := range []string{req.Fwl, req.Db} {
+		exists, err := r.s3.ObjectExists(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not read backup file: %s: %w", key, err)
+		}
+
+		if !exists {
+			return nil, fmt.Errorf("backup file: %s does not exist", key)
+		}
+	}
+
+	jobs, err := r.listJobs(ctx, serverId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		if job.Status == model.RestoreStepPending || job.Status == model.RestoreStepRunning {
+			return job, ErrRestoreInProgress
+		}
+	}
+
+	id, err := util.MakeCrypto().GenerateID(8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate restore id: %w", err)
+	}
+
+	now := time.Now().UTC()
+	job := &model.RestoreJob{
+		ID:        id,
+		ServerID:  serverId,
+		DiscordID: serverId,
+		Status:    model.RestoreStepPending,
+		World:     req,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Steps: []model.RestoreStep{
+			{Name: restoreStepStop, Status: model.RestoreStepPending},
+			{Name: restoreStepSnapshot, Status: model.RestoreStepPending},
+			{Name: restoreStepInstall, Status: model.RestoreStepPending},
+			{Name: restoreStepStart, Status: model.RestoreStepPending},
+		},
+	}
+
+	if _, err = r.s3.PutJSONIfMatch(ctx, restoreKey(serverId, id), job, ""); err != nil {
+		return nil, err
+	}
+
+	if err = r.dispatch(ctx, serverId, id); err != nil {
+		log.Errorf("failed to dispatch restore: %s, it is left for the scheduled worker: %v", id, err)
+	}
+
+	return job, nil
+}
+
+// dispatch hands a queued restore to the restores worker lambda with an asynchronous invoke. The long-running server
+// is not frozen once a response is written so it runs the restore in process instead. Either way the worker reads
+// and claims the job itself.
+func (r *RestoreService) dispatch(ctx context.Context, serverId, id string) error {
+	if r.worker == nil {
+		if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+			return errors.New("RESTORES_FUNCTION_NAME is not set")
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), restoreJobTimeout)
+			defer cancel()
+			if err := r.RunJob(ctx, serverId, id); err != nil {
+				log.Errorf("failed to run restore: %s: %v", id, err)
+			}
+		}()
+		return nil
+	}
+
+	payload, err := json.Marshal(model.RestoreJobEvent{ServerID: serverId, ID: id})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.worker.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(r.workerFunction),
+		InvocationType: types.InvocationTypeEvent,
+		Payload:        payload,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invoke restores worker: %w", err)
+	}
+
+	return nil
+}
+
+// RunJob claims a restore and runs every step which has not yet succeeded. Restores which have finished are skipped,
+// as are running restores unless they have stopped making progress.
+func (r *RestoreService) RunJob(ctx context.Context, serverId, id string) error {
+	if r.server == nil || r.fileManager == nil {
+		return errors.New("restores require both the server api and file manager to be configured")
+	}
+
+	var job model.RestoreJob
+	etag, err := r.s3.GetJSONWithETag(ctx, restoreKey(serverId, id), &job)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if job.Status != model.RestoreStepPending && job.Status != model.RestoreStepRunning {
+		return nil
+	}
+
+	if job.Status == model.RestoreStepRunning && time.Since(job.UpdatedAt) < restoreStaleAfter {
+		return nil
+	}
+
+	// Claiming the job stops two workers from running the same restore
+	job.Status = model.RestoreStepRunning
+	job.UpdatedAt = time.Now().UTC()
+	etag, err = r.s3.PutJSONIfMatch(ctx, restoreKey(serverId, id), &job, etag)
+	if errors.Is(err, ErrObjectModified) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim restore: %s: %w", id, err)
+	}
+
+	log.Infof("running restore: %s for server: %s", job.ID, job.ServerID)
+	r.run(ctx, &job, etag)
+	return nil
+}
+
+// RunPending runs every restore which is still pending or has stopped making progress. It recovers restores whose
+// dispatch failed or whose worker stopped.
+func (r *RestoreService) RunPending(ctx context.Context) error {
+	objects, err := r.s3.ListObjects(restorePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list restores: %w", err)
+	}
+
+	var errs []error
+	for _, obj := range objects {
+		serverId, id, ok := strings.Cut(strings.TrimPrefix(*obj.Key, restorePrefix), "/")
+		if !ok {
+			continue
+		}
+
+		id, ok = strings.CutSuffix(id, ".json")
+		if !ok {
+			continue
+		}
+
+		if err = r.RunJob(ctx, serverId, id); err != nil {
+			log.Errorf("failed to run restore: %s: %v", id, err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// run executes every step of a claimed restore which has not yet succeeded. If a step after the snapshot fails the
+// snapshot is reinstalled and the server restarted. Every step transition is written conditionally on etag so the
+// restore stops as soon as another worker claims it.
+func (r *RestoreService) run(ctx context.Context, job *model.RestoreJob, etag string) {
+	serverId := job.ServerID
+	steps := []func() error{
+		func() error { return r.server.Stop(ctx, serverId) },
+		func() error {
+			snapshot, err := r.server.Snapshot(ctx, serverId, preRestoreLabel)
+			if err != nil {
+				return err
+			}
+			job.SnapshotKeys = snapshot.Keys
+			return nil
+		},
+		func() error { return r.install(ctx, serverId, job.World.Fwl, job.World.Db) },
+		func() error { return r.server.Start(ctx, serverId) },
+	}
+
+	for i, step := range steps {
+		if job.Steps[i].Status == model.RestoreStepSucceeded {
+			continue
+		}
+
+		err := r.runStep(ctx, job, &etag, i, step)
+		if errors.Is(err, errRestoreClaimLost) {
+			log.Warnf("stopping restore: %s for server: %s: %v", job.ID, serverId, err)
+			return
+		}
+
+		if err != nil {
+			log.Errorf("restore: %s for server: %s failed at step: %s: %v", job.ID, serverId, job.Steps[i].Name, err)
+			r.rollback(ctx, job, &etag, i)
+			return
+		}
+	}
+
+	job.Status = model.RestoreStepSucceeded
+	if err := r.save(ctx, job, &etag); err != nil {
+		log.Warnf("stopping restore: %s for server: %s: %v", job.ID, serverId, err)
+		return
+	}
+
+	// The world is already running at this point so failing to record it is logged rather than reported
+	_, err := r.cognito.UpdateUserState(ctx, job.DiscordID, func(state *model.UserState) error {
+		state.InstalledBackups = map[string]bool{job.World.Fwl: true, job.World.Db: true}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("failed to update installed backups for user: %s: %v", job.DiscordID, err)
+	}
+}
+
+// runStep executes a single step and records its status on the job.
+func (r *RestoreService) runStep(ctx context.Context, job *model.RestoreJob, etag *string, i int, step func() error) error {
+	job.Steps[i].Status = model.RestoreStepRunning
+	job.Steps[i].StartedAt = time.Now().UTC()
+	if err := r.save(ctx, job, etag); err != nil {
+		return err
+	}
+
+	err := step()
+	job.Steps[i].FinishedAt = time.Now().UTC()
+	if err != nil {
+		job.Steps[i].Status = model.RestoreStepFailed
+		job.Steps[i].Error = err.Error()
+	} else {
+		job.Steps[i].Status = model.RestoreStepSucceeded
+	}
+
+	if saveErr := r.save(ctx, job, etag); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// rollback marks the remaining steps as skipped and, when a snapshot was taken, reinstalls it and starts the
+// server so the user is left with the world they had before the restore.
+func (r *RestoreService) rollback(ctx context.Context, job *model.RestoreJob, etag *string, failedStep int) {
+	for i := failedStep + 1; i < len(job.Steps); i++ {
+		job.Steps[i].Status = model.RestoreStepSkipped
+	}
+	job.Status = model.RestoreStepFailed
+
+	// Nothing has changed on the volume without a snapshot so the server only needs to be started again if it was
+	// successfully stopped.
+	if len(job.SnapshotKeys) == 0 {
+		if failedStep > 0 {
+			if err := r.server.Start(ctx, job.ServerID); err != nil {
+				log.Errorf("failed to start server: %s after failed restore: %s: %v", job.ServerID, job.ID, err)
+			}
+		}
+		r.saveFinal(ctx, job, etag)
+		return
+	}
+
+	log.Infof("rolling back restore: %s for server: %s to snapshot", job.ID, job.ServerID)
+	rollback := model.RestoreStep{Name: "rollback", Status: model.RestoreStepRunning, StartedAt: time.Now().UTC()}
+	err := r.install(ctx, job.ServerID, job.SnapshotKeys...)
+	if err == nil {
+		err = r.server.Start(ctx, job.ServerID)
+	}
+
+	rollback.FinishedAt = time.Now().UTC()
+	if err != nil {
+		log.Errorf("failed to roll back restore: %s for server: %s: %v", job.ID, job.ServerID, err)
+		rollback.Status = model.RestoreStepFailed
+		rollback.Error = err.Error()
+	} else {
+		rollback.Status = model.RestoreStepSucceeded
+		job.RolledBack = true
+	}
+
+	job.Steps = append(job.Steps, rollback)
+	r.saveFinal(ctx, job, etag)
+}
+
+func (r *RestoreService) install(ctx context.Context, serverId string, keys ...string) error {
+	for _, key := range keys {
+		if err := r.fileManager.SyncFile(ctx, serverId, key, ValheimWorldsDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save persists the job if it is still claimed by this worker and returns errRestoreClaimLost otherwise. Any other
+// failure to persist progress must not abort a restore that is half way through so it is only logged.
+func (r *RestoreService) save(ctx context.Context, job *model.RestoreJob, etag *string) error {
+	job.UpdatedAt = time.Now().UTC()
+	next, err := r.s3.PutJSONIfMatch(ctx, restoreKey(job.ServerID, job.ID), job, *etag)
+	if errors.Is(err, ErrObjectModified) {
+		return errRestoreClaimLost
+	}
+
+	if err != nil {
+		log.Errorf("failed to save restore: %s: %v", job.ID, err)
+		return nil
+	}
+
+	*etag = next
+	return nil
+}
+
+// saveFinal persists the outcome of a rollback, which has already happened whether or not the job is still claimed.
+func (r *RestoreService) saveFinal(ctx context.Context, job *model.RestoreJob, etag *string) {
+	if err := r.save(ctx, job, etag); err != nil {
+		log.Warnf("failed to record rollback of restore: %s: %v", job.ID, err)
+	}
+}
+
+func (r *RestoreService) listJobs(ctx context.Context, serverId string) ([]*model.RestoreJob, error) {
+	objects, err := r.s3.ListObjects(fmt.Sprintf("%s%s/", restorePrefix, serverId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list restores: %w", err)
+	}
+
+	jobs := make([]*model.RestoreJob, 0)
+	for _, obj := range objects {
+		if !strings.HasSuffix(*obj.Key, ".json") {
+			continue
+		}
+
+		var job model.RestoreJob
+		if err = r.s3.GetJSON(ctx, *obj.Key, &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &job)
+	}
+
+	return jobs, nil
+}
+
+func restoreKey(serverId, restoreId string) string {
+	return fmt.Sprintf("%s%s/%s.json", restorePrefix, serverId, restoreId)
+}
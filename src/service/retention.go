@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	autoBackupPrefix = "valheim-backups-auto/"
+	settingsPrefix   = "settings/"
+)
+
+var (
+	// DefaultRetentionPolicy applies to users who have not configured their own policy.
+	DefaultRetentionPolicy = model.RetentionPolicy{
+		KeepLast: 3,
+		Hourly:   6,
+		Daily:    7,
+		Weekly:   4,
+	}
+
+	ErrBackupNotFound = errors.New("backup does not exist")
+)
+
+// RetentionService applies grandfather-father-son retention policies to the automatic backups stored under
+// valheim-backups-auto/{discordId}/. Policies are limited by the backup retention of the user's plan.
+type RetentionService struct {
+	s3           *S3Service
+	entitlements *EntitlementService
+}
+
+// MakeRetentionService creates a new retention service.
+func MakeRetentionService(s3 *S3Service, entitlements *EntitlementService) *RetentionService {
+	return &RetentionService{s3: s3, entitlements: entitlements}
+}
+
+// ValidateRetentionPolicy ensures every bucket of the policy is within the given limits.
+func ValidateRetentionPolicy(policy, limits model.RetentionPolicy) error {
+	if policy.KeepLast < 1 {
+		return errors.New("keepLast must be at least 1")
+	}
+
+	if policy.Hourly < 0 || policy.Daily < 0 || policy.Weekly < 0 {
+		return errors.New("retention counts must not be negative")
+	}
+
+	if policy.KeepLast > limits.KeepLast || policy.Hourly > limits.Hourly || policy.Daily > limits.Daily || policy.Weekly > limits.Weekly {
+		return fmt.Errorf("retention policy exceeds plan limits: keepLast: %d, hourly: %d, daily: %d, weekly: %d",
+			limits.KeepLast, limits.Hourly, limits.Daily, limits.Weekly)
+	}
+
+	return nil
+}
+
+// GetSettings returns the backup settings for a user, falling back to the default policy.
+func (r *RetentionService) GetSettings(ctx context.Context, discordId string) (*model.BackupSettings, error) {
+	settings := model.BackupSettings{
+		Retention: DefaultRetentionPolicy,
+		Pinned:    []string{},
+	}
+
+	err := r.s3.GetJSON(ctx, settingsKey(discordId), &settings)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// SetPolicy replaces the retention policy for a user.
+func (r *RetentionService) SetPolicy(ctx context.Context, discordId string, policy model.RetentionPolicy) (*model.BackupSettings, error) {
+	entitlements, err := r.entitlements.Entitlements(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ValidateRetentionPolicy(policy, entitlements.Limits.BackupRetention); err != nil {
+		return nil, err
+	}
+
+	settings, err := r.GetSettings(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	settings.Retention = policy
+	return settings, r.s3.PutJSON(ctx, settingsKey(discordId), settings)
+}
+
+// SetPinned pins or unpins a backup so it is excluded from pruning.
+func (r *RetentionService) SetPinned(ctx context.Context, discordId, name string, pinned bool) (*model.BackupSettings, error) {
+	settings, err := r.GetSettings(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := slices.Index(settings.Pinned, name)
+	if pinned && idx == -1 {
+		backups, err := r.ListBackups(ctx, discordId)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.ContainsFunc(backups, func(b model.Backup) bool { return b.Name == name }) {
+			return nil, ErrBackupNotFound
+		}
+		settings.Pinned = append(settings.Pinned, name)
+	}
+
+	if !pinned && idx != -1 {
+		settings.Pinned = slices.Delete(settings.Pinned, idx, idx+1)
+	}
+
+	return settings, r.s3.PutJSON(ctx, settingsKey(discordId), settings)
+}
+
+// ListBackups returns a user's automatic backups newest first. The .fwl and .db files of a world are grouped into
+// a single backup so they are always kept or removed together.
+func (r *RetentionService) ListBackups(ctx context.Context, discordId string) ([]model.Backup, error) {
+	objects, err := r.s3.ListObjects(fmt.Sprintf("%s%s/", autoBackupPrefix, discordId))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*model.Backup)
+	for _, obj := range objects {
+		key := *obj.Key
+		name := strings.TrimSuffix(path.Base(key), path.Ext(key))
+		backup, ok := groups[name]
+		if !ok {
+			backup = &model.Backup{Name: name}
+			groups[name] = backup
+		}
+
+		backup.Keys = append(backup.Keys, key)
+		backup.Size += *obj.Size
+		if obj.LastModified.After(backup.LastModified) {
+			backup.LastModified = *obj.LastModified
+		}
+	}
+
+	backups := make([]model.Backup, 0, len(groups))
+	for _, backup := range groups {
+		backups = append(backups, *backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].LastModified.After(backups[j].LastModified)
+	})
+
+	return backups, nil
+}
+
+// Prune applies the user's retention policy to their automatic backups. When dryRun is true nothing is deleted and
+// the result only reports what would be removed.
+func (r *RetentionService) Prune(ctx context.Context, discordId string, dryRun bool) (*model.PruneResult, error) {
+	settings, err := r.GetSettings(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := r.ListBackups(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	// A user who moved to a lower plan keeps their policy but it is capped at what the new plan allows
+	entitlements, err := r.entitlements.Entitlements(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := entitlements.Limits.BackupRetention
+	policy := model.RetentionPolicy{
+		KeepLast: min(settings.Retention.KeepLast, limits.KeepLast),
+		Hourly:   min(settings.Retention.Hourly, limits.Hourly),
+		Daily:    min(settings.Retention.Daily, limits.Daily),
+		Weekly:   min(settings.Retention.Weekly, limits.Weekly),
+	}
+
+	result := ApplyRetentionPolicy(backups, policy, settings.Pinned)
+	result.DryRun = dryRun
+	if dryRun || len(result.Removed) == 0 {
+		return result, nil
+	}
+
+	keys := make([]string, 0)
+	for _, backup := range result.Removed {
+		keys = append(keys, backup.Keys...)
+	}
+
+	log.Infof("pruning %d backups (%d objects) for user: %s", len(result.Removed), len(keys), discordId)
+	if err = r.s3.DeleteObjects(ctx, keys); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// PruneAll runs Prune for every user who has automatic backups. Failures for one user do not stop the others.
+func (r *RetentionService) PruneAll(ctx context.Context) error {
+	objects, err := r.s3.ListObjects(autoBackupPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list auto backups: %w", err)
+	}
+
+	users := make(map[string]bool)
+	for _, obj := range objects {
+		parts := strings.SplitN(strings.TrimPrefix(*obj.Key, autoBackupPrefix), "/", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			users[parts[0]] = true
+		}
+	}
+
+	for discordId := range users {
+		if _, err = r.Prune(ctx, discordId, false); err != nil {
+			log.Errorf("failed to prune backups for user: %s: %v", discordId, err)
+		}
+	}
+
+	return nil
+}
+
+// Start runs PruneAll every interval until ctx is cancelled.
+func (r *RetentionService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Infof("starting backup pruner with interval: %s", interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.PruneAll(ctx); err != nil {
+				log.Errorf("backup pruning failed: %v", err)
+			}
+		}
+	}
+}
+
+// ApplyRetentionPolicy splits backups, which must be sorted newest first, into those kept and removed by policy.
+// The newest backup in each hour, day and ISO week is a candidate for that bucket and buckets are filled newest
+// first until their count is reached. Pinned backups are always kept.
+func ApplyRetentionPolicy(backups []model.Backup, policy model.RetentionPolicy, pinned []string) *model.PruneResult {
+	keep := make(map[string]bool)
+	for i, backup := range backups {
+		if i < policy.KeepLast || slices.Contains(pinned, backup.Name) {
+			keep[backup.Name] = true
+		}
+	}
+
+	buckets := []struct {
+		count int
+		key   func(t time.Time) string
+	}{
+		{policy.Hourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{policy.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{policy.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+	}
+
+	for _, bucket := range buckets {
+		seen := make(map[string]bool)
+		for _, backup := range backups {
+			if len(seen) >= bucket.count {
+				break
+			}
+
+			period := bucket.key(backup.LastModified.UTC())
+			if !seen[period] {
+				seen[period] = true
+				keep[backup.Name] = true
+			}
+		}
+	}
+
+	result := &model.PruneResult{
+		Kept:    make([]model.Backup, 0),
+		Removed: make([]model.Backup, 0),
+	}
+
+	for _, backup := range backups {
+		backup.Pinned = slices.Contains(pinned, backup.Name)
+		if keep[backup.Name] {
+			result.Kept = append(result.Kept, backup)
+		} else {
+			result.Removed = append(result.Removed, backup)
+		}
+	}
+
+	return result
+}
+
+func settingsKey(discordId string) string {
+	return fmt.Sprintf("%s%s/backups.json", settingsPrefix, discordId)
+}
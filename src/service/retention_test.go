@@ -0,0 +1,107 @@
+package service
+
+import (
+	"github.com/cbartram/hearthhub/src/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// halfHourlyBackups returns count backups taken every 30 minutes up to newest, sorted newest first.
+func halfHourlyBackups(newest time.Time, count int) []model.Backup {
+	backups := make([]model.Backup, 0, count)
+	for i := 0; i < count; i++ {
+		taken := newest.Add(-time.Duration(i) * 30 * time.Minute)
+		backups = append(backups, model.Backup{Name: taken.Format(time.RFC3339), LastModified: taken})
+	}
+	return backups
+}
+
+func names(backups []model.Backup) []string {
+	result := make([]string, 0, len(backups))
+	for _, backup := range backups {
+		result = append(result, backup.Name)
+	}
+	return result
+}
+
+func TestApplyRetentionPolicy(t *testing.T) {
+	// Two weeks of backups ending on a Wednesday so they span three ISO weeks
+	newest := time.Date(2026, 1, 7, 12, 0, 0, 0, time.UTC)
+	backups := halfHourlyBackups(newest, 14*48)
+
+	at := func(t time.Time) string { return t.Format(time.RFC3339) }
+
+	tests := []struct {
+		name     string
+		policy   model.RetentionPolicy
+		pinned   []string
+		wantKept []string
+	}{
+		{
+			name:     "empty policy removes everything",
+			policy:   model.RetentionPolicy{},
+			wantKept: []string{},
+		},
+		{
+			name:     "keep last",
+			policy:   model.RetentionPolicy{KeepLast: 3},
+			wantKept: []string{at(newest), at(newest.Add(-30 * time.Minute)), at(newest.Add(-time.Hour))},
+		},
+		{
+			name:     "newest backup of each hour",
+			policy:   model.RetentionPolicy{Hourly: 3},
+			wantKept: []string{at(newest), at(newest.Add(-30 * time.Minute)), at(newest.Add(-90 * time.Minute))},
+		},
+		{
+			name:   "newest backup of each day",
+			policy: model.RetentionPolicy{Daily: 3},
+			wantKept: []string{
+				at(newest),
+				at(time.Date(2026, 1, 6, 23, 30, 0, 0, time.UTC)),
+				at(time.Date(2026, 1, 5, 23, 30, 0, 0, time.UTC)),
+			},
+		},
+		{
+			name:   "newest backup of each iso week",
+			policy: model.RetentionPolicy{Weekly: 3},
+			wantKept: []string{
+				at(newest),
+				at(time.Date(2026, 1, 4, 23, 30, 0, 0, time.UTC)),
+				at(time.Date(2025, 12, 28, 23, 30, 0, 0, time.UTC)),
+			},
+		},
+		{
+			name:     "buckets overlap",
+			policy:   model.RetentionPolicy{KeepLast: 1, Hourly: 1, Daily: 1},
+			wantKept: []string{at(newest)},
+		},
+		{
+			name:     "pinned backups are always kept",
+			policy:   model.RetentionPolicy{KeepLast: 1},
+			pinned:   []string{at(backups[len(backups)-1].LastModified)},
+			wantKept: []string{at(newest), at(backups[len(backups)-1].LastModified)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ApplyRetentionPolicy(backups, tt.policy, tt.pinned)
+
+			if got := names(result.Kept); !reflect.DeepEqual(got, tt.wantKept) {
+				t.Errorf("kept = %v, want %v", got, tt.wantKept)
+			}
+
+			if len(result.Kept)+len(result.Removed) != len(backups) {
+				t.Errorf("kept %d and removed %d of %d backups", len(result.Kept), len(result.Removed), len(backups))
+			}
+
+			for _, backup := range result.Kept {
+				wantPinned := len(tt.pinned) > 0 && backup.Name == tt.pinned[0]
+				if backup.Pinned != wantPinned {
+					t.Errorf("backup: %s pinned = %v, want %v", backup.Name, backup.Pinned, wantPinned)
+				}
+			}
+		})
+	}
+}
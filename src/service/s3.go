@@ -1,14 +1,28 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	log "github.com/sirupsen/logrus"
+	"github.com/aws/smithy-go"
+	"io"
 	"os"
+	"time"
+)
+
+var (
+	// ErrObjectNotFound is returned when a requested key does not exist in the bucket.
+	ErrObjectNotFound = errors.New("object not found")
+
+	// ErrObjectModified is returned by a conditional write when the object changed since it was read.
+	ErrObjectModified = errors.New("object was modified")
 )
 
 type S3Service struct {
@@ -50,7 +64,7 @@ func (s *S3Service) ListObjects(prefix string) ([]types.Object, error) {
 
 		page, err := p.NextPage(context.TODO())
 		if err != nil {
-			log.Fatalf("failed to get page %v, %v", i, err)
+			return nil, fmt.Errorf("failed to get page %v: %w", i, err)
 		}
 
 		for _, obj := range page.Contents {
@@ -79,6 +93,206 @@ func (s *S3Service) UploadObject(ctx context.Context, key string) (*s3.PutObject
 	return result, nil
 }
 
+// GetObject retrieves the contents of an object from S3. ErrObjectNotFound is returned when the key does not exist.
+func (s *S3Service) GetObject(ctx context.Context, key string) ([]byte, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get object: %v", err)
+	}
+	defer result.Body.Close()
+
+	body, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object body: %v", err)
+	}
+
+	return body, nil
+}
+
+// GetObjectStream opens an object for reading without buffering it in memory. The caller must close the returned
+// body. ErrObjectNotFound is returned when the key does not exist.
+func (s *S3Service) GetObjectStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get object: %v", err)
+	}
+
+	return result.Body, nil
+}
+
+// UploadStream writes everything read from body to key using a multipart upload so objects of any size can be
+// written while only holding a single part in memory.
+func (s *S3Service) UploadStream(ctx context.Context, key string, body io.Reader) error {
+	uploader := manager.NewUploader(s.client, func(u *manager.Uploader) {
+		u.PartSize = manager.MinUploadPartSize
+		u.Concurrency = 1
+	})
+
+	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %v", err)
+	}
+
+	return nil
+}
+
+// PresignGetObject returns a URL which can be used to download key without credentials until ttl has passed.
+func (s *S3Service) PresignGetObject(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	request, err := s3.NewPresignClient(s.client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object: %v", err)
+	}
+
+	return request.URL, nil
+}
+
+// ObjectExists returns true when an object with the given key exists in the bucket.
+func (s *S3Service) ObjectExists(ctx context.Context, key string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to head object: %v", err)
+	}
+
+	return true, nil
+}
+
+// ObjectSize returns the size in bytes of an object. ErrObjectNotFound is returned when the key does not exist.
+func (s *S3Service) ObjectSize(ctx context.Context, key string) (int64, error) {
+	result, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return 0, ErrObjectNotFound
+		}
+		return 0, fmt.Errorf("failed to head object: %v", err)
+	}
+
+	return aws.ToInt64(result.ContentLength), nil
+}
+
+// PutObject writes the given bytes to S3 under key, replacing any existing object.
+func (s *S3Service) PutObject(ctx context.Context, key string, body []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object: %v", err)
+	}
+
+	return nil
+}
+
+// GetJSON reads the object at key and unmarshals it into v. ErrObjectNotFound is returned when the key does not exist.
+func (s *S3Service) GetJSON(ctx context.Context, key string, v any) error {
+	body, err := s.GetObject(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to unmarshal object %s: %v", key, err)
+	}
+
+	return nil
+}
+
+// PutJSON marshals v and writes it to S3 under key.
+func (s *S3Service) PutJSON(ctx context.Context, key string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal object %s: %v", key, err)
+	}
+
+	return s.PutObject(ctx, key, body)
+}
+
+// GetJSONWithETag reads the object at key into v like GetJSON and returns its ETag for a later PutJSONIfMatch.
+func (s *S3Service) GetJSONWithETag(ctx context.Context, key string, v any) (string, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return "", ErrObjectNotFound
+		}
+		return "", fmt.Errorf("failed to get object: %v", err)
+	}
+	defer result.Body.Close()
+
+	if err = json.NewDecoder(result.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("failed to unmarshal object %s: %v", key, err)
+	}
+
+	return aws.ToString(result.ETag), nil
+}
+
+// PutJSONIfMatch writes v under key only when the object still has the given ETag, or does not exist yet when etag
+// is empty, and returns the ETag of the new object. ErrObjectModified is returned when it was written or created by
+// someone else in the meantime.
+func (s *S3Service) PutJSONIfMatch(ctx context.Context, key string, v any, etag string) (string, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal object %s: %v", key, err)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	}
+	if etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(etag)
+	}
+
+	result, err := s.client.PutObject(ctx, input)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+			return "", ErrObjectModified
+		}
+		return "", fmt.Errorf("failed to put object: %v", err)
+	}
+
+	return aws.ToString(result.ETag), nil
+}
+
 // DeleteObject deletes an object from S3
 func (s *S3Service) DeleteObject(ctx context.Context, key string) error {
 	input := &s3.DeleteObjectInput{
@@ -102,10 +316,19 @@ func (s *S3Service) DeleteObjectsWithPrefix(ctx context.Context, prefix string)
 		return fmt.Errorf("failed to list objects for deletion: %v", err)
 	}
 
+	keys := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		keys = append(keys, *obj.Key)
+	}
+
+	return s.DeleteObjects(ctx, keys)
+}
+
+// DeleteObjects deletes the given keys in batches of 100
+func (s *S3Service) DeleteObjects(ctx context.Context, keys []string) error {
 	// Create delete objects input
 	var objectIds []types.ObjectIdentifier
-	for _, obj := range objects {
-		key := *obj.Key
+	for _, key := range keys {
 		objectIds = append(objectIds, types.ObjectIdentifier{
 			Key: aws.String(key),
 		})
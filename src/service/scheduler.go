@@ -0,0 +1,410 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/util"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+	_ "time/tzdata" // The lambda runtime does not ship a zoneinfo database
+)
+
+const (
+	schedulePrefix    = "schedules/"
+	maxScheduleRuns   = 100
+	maxPreviewRuns    = 25
+	defaultTimeZone   = "UTC"
+	schedulesFileName = "schedules.json"
+	historyFileName   = "history.json"
+)
+
+var (
+	ErrScheduleNotFound = errors.New("schedule does not exist")
+
+	validScheduleActions = map[string]bool{
+		model.ScheduleActionStart:   true,
+		model.ScheduleActionStop:    true,
+		model.ScheduleActionRestart: true,
+		model.ScheduleActionBackup:  true,
+	}
+)
+
+// SchedulerService stores per-server schedules in S3 and runs the actions which are due. It is driven either by an
+// EventBridge rule invoking the lambda or by the in-process ticker started with Start in long-running mode. Schedules
+// of owners who are disabled or no longer pass the guild gate are not run.
+type SchedulerService struct {
+	s3      *S3Service
+	server  *ServerService
+	cognito *CognitoService
+	gate    *GuildGateService
+}
+
+// MakeSchedulerService creates a new scheduler service.
+func MakeSchedulerService(s3 *S3Service, server *ServerService, cognito *CognitoService, gate *GuildGateService) *SchedulerService {
+	return &SchedulerService{
+		s3:      s3,
+		server:  server,
+		cognito: cognito,
+		gate:    gate,
+	}
+}
+
+// ValidateSchedule checks that the cron expression, time zone and action of a schedule are usable.
+func ValidateSchedule(schedule *model.ServerSchedule) error {
+	if _, ok := validScheduleActions[schedule.Action]; !ok {
+		return fmt.Errorf("invalid action: %s, must be one of: start, stop, restart, backup", schedule.Action)
+	}
+
+	if _, err := parseSchedule(schedule); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ListSchedules returns every schedule configured for a server.
+func (s *SchedulerService) ListSchedules(ctx context.Context, serverId string) ([]model.ServerSchedule, error) {
+	schedules := make([]model.ServerSchedule, 0)
+	err := s.s3.GetJSON(ctx, scheduleKey(serverId, schedulesFileName), &schedules)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// GetSchedule returns a single schedule for a server.
+func (s *SchedulerService) GetSchedule(ctx context.Context, serverId, scheduleId string) (*model.ServerSchedule, error) {
+	schedules, err := s.ListSchedules(ctx, serverId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, schedule := range schedules {
+		if schedule.ID == scheduleId {
+			return &schedule, nil
+		}
+	}
+
+	return nil, ErrScheduleNotFound
+}
+
+// PutSchedule creates the schedule when its ID is empty, otherwise it replaces the existing schedule with that ID.
+func (s *SchedulerService) PutSchedule(ctx context.Context, schedule model.ServerSchedule) (*model.ServerSchedule, error) {
+	if schedule.TimeZone == "" {
+		schedule.TimeZone = defaultTimeZone
+	}
+
+	if err := ValidateSchedule(&schedule); err != nil {
+		return nil, err
+	}
+
+	schedules, err := s.ListSchedules(ctx, schedule.ServerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if schedule.ID == "" {
+		schedule.ID, err = util.MakeCrypto().GenerateID(8)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate schedule id: %w", err)
+		}
+		schedule.CreatedAt = time.Now().UTC()
+		schedules = append(schedules, schedule)
+	} else {
+		found := false
+		for i := range schedules {
+			if schedules[i].ID == schedule.ID {
+				schedule.CreatedAt = schedules[i].CreatedAt
+				schedule.LastRunAt = schedules[i].LastRunAt
+				schedules[i] = schedule
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, ErrScheduleNotFound
+		}
+	}
+
+	if err = s.s3.PutJSON(ctx, scheduleKey(schedule.ServerID, schedulesFileName), schedules); err != nil {
+		return nil, err
+	}
+
+	return &schedule, nil
+}
+
+// DeleteSchedule removes a schedule from a server.
+func (s *SchedulerService) DeleteSchedule(ctx context.Context, serverId, scheduleId string) error {
+	schedules, err := s.ListSchedules(ctx, serverId)
+	if err != nil {
+		return err
+	}
+
+	remaining := make([]model.ServerSchedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		if schedule.ID != scheduleId {
+			remaining = append(remaining, schedule)
+		}
+	}
+
+	if len(remaining) == len(schedules) {
+		return ErrScheduleNotFound
+	}
+
+	return s.s3.PutJSON(ctx, scheduleKey(serverId, schedulesFileName), remaining)
+}
+
+// NextRuns previews the next count times a schedule will fire after from.
+func (s *SchedulerService) NextRuns(schedule *model.ServerSchedule, from time.Time, count int) ([]time.Time, error) {
+	parsed, err := parseSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	if count <= 0 || count > maxPreviewRuns {
+		count = maxPreviewRuns
+	}
+
+	loc, _ := time.LoadLocation(schedule.TimeZone)
+	runs := make([]time.Time, 0, count)
+	next := from.In(loc)
+	for i := 0; i < count; i++ {
+		next = parsed.Next(next)
+		if next.IsZero() {
+			break
+		}
+		runs = append(runs, next)
+	}
+
+	return runs, nil
+}
+
+// History returns the most recent schedule runs for a server, newest first.
+func (s *SchedulerService) History(ctx context.Context, serverId string) ([]model.ScheduleRun, error) {
+	runs := make([]model.ScheduleRun, 0)
+	err := s.s3.GetJSON(ctx, scheduleKey(serverId, historyFileName), &runs)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// RunDue executes every enabled schedule whose next fire time since it last ran is at or before now. Runs missed
+// while the scheduler was not ticking are collapsed into a single run. The errors of every server are returned
+// together once all of them have been tried.
+func (s *SchedulerService) RunDue(ctx context.Context, now time.Time) error {
+	objects, err := s.s3.ListObjects(schedulePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list schedules: %w", err)
+	}
+
+	var errs []error
+	for _, obj := range objects {
+		key := *obj.Key
+		if !strings.HasSuffix(key, "/"+schedulesFileName) {
+			continue
+		}
+
+		serverId := strings.TrimSuffix(strings.TrimPrefix(key, schedulePrefix), "/"+schedulesFileName)
+		if err = s.runDueForServer(ctx, serverId, now); err != nil {
+			log.Errorf("failed to run schedules for server: %s: %v", serverId, err)
+			errs = append(errs, fmt.Errorf("server: %s: %w", serverId, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Start runs RunDue every interval until ctx is cancelled. This is used when the API runs as a long-running
+// process instead of a lambda.
+func (s *SchedulerService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Infof("starting scheduler with interval: %s", interval)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("stopping scheduler")
+			return
+		case now := <-ticker.C:
+			if err := s.RunDue(ctx, now); err != nil {
+				log.Errorf("scheduler tick failed: %v", err)
+			}
+		}
+	}
+}
+
+// runDueForServer claims the due schedules of a server by recording their run time with a conditional write before
+// running them, so a schedule is never run twice when two schedulers tick at once. Nothing is run when the write
+// loses to another scheduler or an edit, the schedules are looked at again on the next tick.
+func (s *SchedulerService) runDueForServer(ctx context.Context, serverId string, now time.Time) error {
+	schedules := make([]model.ServerSchedule, 0)
+	etag, err := s.s3.GetJSONWithETag(ctx, scheduleKey(serverId, schedulesFileName), &schedules)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	due := make([]int, 0)
+	for i := range schedules {
+		schedule := &schedules[i]
+		if !schedule.Enabled {
+			continue
+		}
+
+		parsed, err := parseSchedule(schedule)
+		if err != nil {
+			log.Errorf("skipping invalid schedule: %s for server: %s: %v", schedule.ID, serverId, err)
+			continue
+		}
+
+		last := schedule.LastRunAt
+		if last.IsZero() {
+			last = schedule.CreatedAt
+		}
+
+		loc, _ := time.LoadLocation(schedule.TimeZone)
+		if parsed.Next(last.In(loc)).After(now) {
+			continue
+		}
+
+		schedule.LastRunAt = now.UTC()
+		due = append(due, i)
+	}
+
+	if len(due) == 0 {
+		return nil
+	}
+
+	_, err = s.s3.PutJSONIfMatch(ctx, scheduleKey(serverId, schedulesFileName), schedules, etag)
+	if errors.Is(err, ErrObjectModified) {
+		log.Infof("schedules for server: %s changed since they were read, leaving them for the next tick", serverId)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim schedules: %w", err)
+	}
+
+	// Servers are keyed by the Discord ID of their owner
+	ownerErr := s.ownerAllowed(ctx, serverId)
+
+	runs := make([]model.ScheduleRun, 0, len(due))
+	for _, i := range due {
+		schedule := &schedules[i]
+		run := model.ScheduleRun{
+			ScheduleID: schedule.ID,
+			Action:     schedule.Action,
+			StartedAt:  now.UTC(),
+			Success:    true,
+		}
+
+		err = ownerErr
+		if err == nil {
+			log.Infof("running schedule: %s (%s) for server: %s", schedule.ID, schedule.Action, serverId)
+			err = s.execute(ctx, schedule)
+		}
+
+		if err != nil {
+			log.Errorf("schedule: %s for server: %s failed: %v", schedule.ID, serverId, err)
+			run.Success = false
+			run.Error = err.Error()
+		}
+
+		runs = append(runs, run)
+	}
+
+	return s.appendHistory(ctx, serverId, runs)
+}
+
+// ownerAllowed returns an error when the owner of a server may not use HearthHub because an admin disabled them or
+// they no longer pass the guild gate.
+func (s *SchedulerService) ownerAllowed(ctx context.Context, discordId string) error {
+	disabled, err := s.cognito.IsAdminDisabled(ctx, discordId)
+	if err != nil {
+		return fmt.Errorf("failed to check owner: %w", err)
+	}
+	if disabled {
+		return errors.New("skipped: the server owner has been disabled")
+	}
+
+	owner, err := s.cognito.GetUser(ctx, &discordId)
+	if err != nil {
+		return fmt.Errorf("failed to get owner: %w", err)
+	}
+
+	if err = s.gate.Enforce(ctx, owner); err != nil {
+		return fmt.Errorf("skipped: %w", err)
+	}
+
+	if !owner.AccountEnabled {
+		return errors.New("skipped: the server owner's account is disabled")
+	}
+
+	return nil
+}
+
+func (s *SchedulerService) execute(ctx context.Context, schedule *model.ServerSchedule) error {
+	if s.server == nil {
+		return errors.New("no server api configured")
+	}
+
+	switch schedule.Action {
+	case model.ScheduleActionStart:
+		return s.server.Start(ctx, schedule.ServerID)
+	case model.ScheduleActionStop:
+		return s.server.Stop(ctx, schedule.ServerID)
+	case model.ScheduleActionRestart:
+		return s.server.Restart(ctx, schedule.ServerID, RestartOptions{
+			Warning:        schedule.Warning,
+			WarningSeconds: schedule.WarningSeconds,
+		})
+	case model.ScheduleActionBackup:
+		return s.server.Backup(ctx, schedule.ServerID)
+	}
+
+	return fmt.Errorf("unknown action: %s", schedule.Action)
+}
+
+func (s *SchedulerService) appendHistory(ctx context.Context, serverId string, runs []model.ScheduleRun) error {
+	history, err := s.History(ctx, serverId)
+	if err != nil {
+		return err
+	}
+
+	// Newest runs are kept at the front and the history is capped so the object does not grow forever
+	history = append(runs, history...)
+	if len(history) > maxScheduleRuns {
+		history = history[:maxScheduleRuns]
+	}
+
+	return s.s3.PutJSON(ctx, scheduleKey(serverId, historyFileName), history)
+}
+
+func parseSchedule(schedule *model.ServerSchedule) (cron.Schedule, error) {
+	if _, err := time.LoadLocation(schedule.TimeZone); err != nil {
+		return nil, fmt.Errorf("invalid time zone: %s", schedule.TimeZone)
+	}
+
+	// The time zone is configured separately so a CRON_TZ prefix would silently conflict with it
+	if strings.Contains(schedule.Cron, "TZ=") {
+		return nil, fmt.Errorf("invalid cron expression: %s: use the timeZone field instead of CRON_TZ", schedule.Cron)
+	}
+
+	parsed, err := cron.ParseStandard(schedule.Cron)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression: %s: %v", schedule.Cron, err)
+	}
+
+	return parsed, nil
+}
+
+func scheduleKey(serverId, file string) string {
+	return fmt.Sprintf("%s%s/%s", schedulePrefix, serverId, file)
+}
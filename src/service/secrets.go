@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/util"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	secretsPrefix = "secrets/"
+
+	// maxSecretUpdateRetries is how many times a write to a user's secrets is retried when it races another one.
+	maxSecretUpdateRetries = 5
+
+	SecretRefreshToken = "refresh_token"
+)
+
+var errSecretsUnchanged = errors.New("secrets unchanged")
+
+// SecretService stores per-user secrets envelope encrypted in S3 at secrets/{discordId}.json, written conditionally on
+// its ETag so concurrent requests do not overwrite each other. Secrets are kept out of Cognito attributes since
+// those are readable by anyone with AdminGetUser and are limited to 2048 characters, which an encrypted refresh token
+// does not fit in. They are also kept outside settings/ so they are never included in a data export.
+type SecretService struct {
+	s3     *S3Service
+	crypto *util.Crypto
+}
+
+// MakeSecretService creates a new secret service. Secrets cannot be written or read when no key provider is
+// configured.
+func MakeSecretService(s3 *S3Service) *SecretService {
+	keys, err := util.MakeKeyProvider()
+	if err != nil {
+		log.Warnf("secrets will not be stored: %v", err)
+	}
+
+	return &SecretService{s3: s3, crypto: util.MakeEnvelopeCrypto(keys)}
+}
+
+// Put encrypts value under its own data key and stores it as the user's secret called name.
+func (s *SecretService) Put(ctx context.Context, discordId, name, value string) error {
+	ciphertext, err := s.crypto.Encrypt(ctx, []byte(value))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret: %s: %w", name, err)
+	}
+
+	return s.update(ctx, discordId, func(secrets map[string]string) error {
+		secrets[name] = ciphertext
+		return nil
+	})
+}
+
+// Get decrypts the user's secret called name. It returns ErrObjectNotFound when the secret does not exist.
+func (s *SecretService) Get(ctx context.Context, discordId, name string) (string, error) {
+	secrets, err := s.load(ctx, discordId)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, ok := secrets[name]
+	if !ok {
+		return "", ErrObjectNotFound
+	}
+
+	plaintext, err := s.crypto.Decrypt(ctx, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %s: %w", name, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// Remove deletes the user's secret called name if it exists.
+func (s *SecretService) Remove(ctx context.Context, discordId, name string) error {
+	return s.update(ctx, discordId, func(secrets map[string]string) error {
+		if _, ok := secrets[name]; !ok {
+			return errSecretsUnchanged
+		}
+
+		delete(secrets, name)
+		return nil
+	})
+}
+
+// Delete removes every secret belonging to the user.
+func (s *SecretService) Delete(ctx context.Context, discordId string) error {
+	return s.s3.DeleteObject(ctx, secretsKey(discordId))
+}
+
+// RewrapAll re-encrypts every stored secret which is not under the current master key. It returns how many secrets
+// were rewrapped and is safe to re-run after a failure. Each user's secrets are written conditionally so a secret
+// stored while they are being rewrapped is not lost.
+func (s *SecretService) RewrapAll(ctx context.Context) (int, error) {
+	objects, err := s.s3.ListObjects(secretsPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	rewrapped := 0
+	for _, obj := range objects {
+		discordId := strings.TrimSuffix(strings.TrimPrefix(*obj.Key, secretsPrefix), ".json")
+
+		count := 0
+		err = s.update(ctx, discordId, func(secrets map[string]string) error {
+			count = 0
+			for name, ciphertext := range secrets {
+				updated, ok, err := s.crypto.Rewrap(ctx, ciphertext)
+				if err != nil {
+					return fmt.Errorf("failed to rewrap secret: %s for user: %s: %w", name, discordId, err)
+				}
+
+				if ok {
+					secrets[name] = updated
+					count++
+				}
+			}
+
+			if count == 0 {
+				return errSecretsUnchanged
+			}
+			return nil
+		})
+		if err != nil {
+			return rewrapped, err
+		}
+
+		if count > 0 {
+			rewrapped += count
+			log.Infof("rewrapped secrets for user: %s", discordId)
+		}
+	}
+
+	return rewrapped, nil
+}
+
+func (s *SecretService) load(ctx context.Context, discordId string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	err := s.s3.GetJSON(ctx, secretsKey(discordId), &secrets)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// update applies fn to the user's secrets and writes them back only if they were not modified in the meantime,
+// retrying with the latest secrets otherwise. fn may be called more than once and returns errSecretsUnchanged when
+// there is nothing to write.
+func (s *SecretService) update(ctx context.Context, discordId string, fn func(secrets map[string]string) error) error {
+	for attempt := 0; attempt < maxSecretUpdateRetries; attempt++ {
+		secrets := make(map[string]string)
+		etag, err := s.s3.GetJSONWithETag(ctx, secretsKey(discordId), &secrets)
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+
+		err = fn(secrets)
+		if errors.Is(err, errSecretsUnchanged) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = s.s3.PutJSONIfMatch(ctx, secretsKey(discordId), secrets, etag)
+		if !errors.Is(err, ErrObjectModified) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("failed to update secrets of user: %s: gave up after %d attempts", discordId, maxSecretUpdateRetries)
+}
+
+func secretsKey(discordId string) string {
+	return fmt.Sprintf("%s%s.json", secretsPrefix, discordId)
+}
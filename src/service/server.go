@@ -0,0 +1,139 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ServerService talks to the hearthhub server API which owns the lifecycle of the Valheim server deployments.
+// Servers are currently keyed by the Discord ID of the user who owns them.
+type ServerService struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// ServerStatus represents the current state of a Valheim server as reported by the server API.
+type ServerStatus struct {
+	State   string   `json:"state"`
+	Players []string `json:"players"`
+}
+
+// RestartOptions configures an optional in-game broadcast which is sent before a server restarts.
+type RestartOptions struct {
+	Warning        string `json:"warning,omitempty"`
+	WarningSeconds int    `json:"warningSeconds,omitempty"`
+}
+
+// SnapshotResult lists the S3 keys of the world files copied by a snapshot.
+type SnapshotResult struct {
+	Keys []string `json:"keys"`
+}
+
+// MakeServerService creates a new server API client from the SERVER_API_URL environment variable.
+func MakeServerService() (*ServerService, error) {
+	baseURL := os.Getenv("SERVER_API_URL")
+	if baseURL == "" {
+		return nil, fmt.Errorf("missing required environment variable: SERVER_API_URL")
+	}
+
+	return &ServerService{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// Start scales the server up.
+func (s *ServerService) Start(ctx context.Context, serverId string) error {
+	return s.do(ctx, http.MethodPost, serverId, "start", nil, nil)
+}
+
+// Stop scales the server down. The world is saved by Valheim on shutdown.
+func (s *ServerService) Stop(ctx context.Context, serverId string) error {
+	return s.do(ctx, http.MethodPost, serverId, "stop", nil, nil)
+}
+
+// Restart restarts the server, broadcasting opts.Warning to connected players first when it is set.
+func (s *ServerService) Restart(ctx context.Context, serverId string, opts RestartOptions) error {
+	return s.do(ctx, http.MethodPost, serverId, "restart", opts, nil)
+}
+
+// Backup asks the server to save the world and copy it to valheim-backups-auto immediately.
+func (s *ServerService) Backup(ctx context.Context, serverId string) error {
+	return s.do(ctx, http.MethodPost, serverId, "backup", nil, nil)
+}
+
+// Snapshot copies the server's current world files to valheim-backups-auto with label included in their names. The
+// server should be stopped first so the world on disk is consistent.
+func (s *ServerService) Snapshot(ctx context.Context, serverId, label string) (*SnapshotResult, error) {
+	var result SnapshotResult
+	if err := s.do(ctx, http.MethodPost, serverId, "snapshot", map[string]string{"label": label}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Status returns the state of the server and the players currently connected to it.
+func (s *ServerService) Status(ctx context.Context, serverId string) (*ServerStatus, error) {
+	var status ServerStatus
+	if err := s.do(ctx, http.MethodGet, serverId, "status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Logs returns the last tail lines of the server's log output.
+func (s *ServerService) Logs(ctx context.Context, serverId string, tail int) ([]string, error) {
+	var logs struct {
+		Lines []string `json:"lines"`
+	}
+
+	if err := s.do(ctx, http.MethodGet, serverId, fmt.Sprintf("logs?tail=%d", tail), nil, &logs); err != nil {
+		return nil, err
+	}
+	return logs.Lines, nil
+}
+
+func (s *ServerService) do(ctx context.Context, method, serverId, action string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s request: %w", action, err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/server/%s/%s", s.baseURL, serverId, action)
+	log.Infof("making %s request to: %s", method, endpoint)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", action, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send %s request: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server api returned status %d for %s: %s", resp.StatusCode, action, string(respBody))
+	}
+
+	if out != nil {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode %s response: %w", action, err)
+		}
+	}
+
+	return nil
+}
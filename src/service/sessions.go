@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	sessionsPrefix     = "sessions/"
+	maxSessionsPerUser = 50
+
+	// maxSessionUpdateRetries is how many times a write to a user's sessions is retried when it races another one.
+	maxSessionUpdateRetries = 5
+
+	// sessionTouchInterval is how often a session's last used time is written as it is used.
+	sessionTouchInterval = 5 * time.Minute
+
+	// revokedSessionRetention is how long revoked sessions are remembered for. It matches the refresh token validity
+	// of the app client after which Cognito rejects the token anyway.
+	revokedSessionRetention = 30 * 24 * time.Hour
+)
+
+var (
+	// sessionTouches records when each session was last written by this process so a request does not need to
+	// write its session every time.
+	sessionTouches sync.Map
+
+	ErrSessionNotFound = errors.New("session does not exist")
+
+	errSessionsUnchanged = errors.New("sessions unchanged")
+)
+
+// SessionService keeps a record of every refresh token issued to a user with the device and IP it was used from so
+// users can see where they are signed in and revoke individual sessions. Records are stored in S3 at
+// sessions/{discordId}.json, written conditionally on its ETag so concurrent requests do not overwrite each other,
+// and the refresh token of each session is stored as an encrypted secret so it can be revoked with Cognito.
+type SessionService struct {
+	s3      *S3Service
+	secrets *SecretService
+	revoke  func(ctx context.Context, refreshToken string) error
+}
+
+// MakeSessionService creates a new session service. revoke revokes a refresh token with Cognito and is called for
+// sessions which are dropped because the user has too many.
+func MakeSessionService(s3 *S3Service, secrets *SecretService, revoke func(ctx context.Context, refreshToken string) error) *SessionService {
+	return &SessionService{s3: s3, secrets: secrets, revoke: revoke}
+}
+
+// SessionID returns the id of the session a refresh token belongs to.
+func SessionID(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:12])
+}
+
+// List returns the user's sessions which have not been revoked, most recently used first.
+func (s *SessionService) List(ctx context.Context, discordId string) ([]model.Session, error) {
+	sessions, err := s.load(ctx, discordId)
+	if err != nil {
+		return nil, err
+	}
+
+	active := make([]model.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if session.RevokedAt == nil {
+			active = append(active, session)
+		}
+	}
+
+	sort.Slice(active, func(i, j int) bool { return active[i].LastUsedAt.After(active[j].LastUsedAt) })
+	return active, nil
+}
+
+// Create records a newly issued refresh token as a session used from the device and IP in meta.
+func (s *SessionService) Create(ctx context.Context, discordId, refreshToken string, meta model.RequestMeta) (*model.Session, error) {
+	now := time.Now().UTC()
+	session := model.Session{
+		ID:         SessionID(refreshToken),
+		UserAgent:  meta.UserAgent,
+		IP:         meta.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+
+	// Without its token the session can still be revoked here, it just cannot also be revoked with Cognito
+	if err := s.secrets.Put(ctx, discordId, sessionSecret(session.ID), refreshToken); err != nil {
+		log.Errorf("failed to store token for session: %s of user: %s: %v", session.ID, discordId, err)
+	}
+
+	var dropped []string
+	err := s.update(ctx, discordId, func(sessions []model.Session) ([]model.Session, error) {
+		sessions = slices.DeleteFunc(sessions, func(existing model.Session) bool { return existing.ID == session.ID })
+		sessions, dropped = prune(append(sessions, session))
+		return sessions, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range dropped {
+		if err = s.revokePruned(ctx, discordId, id); err != nil {
+			log.Errorf("failed to revoke pruned session: %s of user: %s: %v", id, discordId, err)
+		}
+	}
+
+	sessionTouches.Store(session.ID, now)
+	return &session, nil
+}
+
+// revokePruned revokes the refresh token of a session dropped by prune with Cognito and then removes it. The token
+// is kept when it cannot be revoked so the session is not left signed in with no way to revoke it.
+func (s *SessionService) revokePruned(ctx context.Context, discordId, sessionId string) error {
+	refreshToken, err := s.secrets.Get(ctx, discordId, sessionSecret(sessionId))
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = s.revoke(ctx, refreshToken); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	return s.secrets.Remove(ctx, discordId, sessionSecret(sessionId))
+}
+
+// Touch updates the last used time, device and IP of the session for refreshToken at most once every
+// sessionTouchInterval. Sessions issued before sessions were recorded are created the first time they are used.
+func (s *SessionService) Touch(ctx context.Context, discordId, refreshToken string, meta model.RequestMeta) error {
+	id := SessionID(refreshToken)
+	if last, ok := sessionTouches.Load(id); ok && time.Since(last.(time.Time)) < sessionTouchInterval {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	err := s.update(ctx, discordId, func(sessions []model.Session) ([]model.Session, error) {
+		idx := slices.IndexFunc(sessions, func(session model.Session) bool { return session.ID == id })
+		if idx == -1 {
+			return nil, ErrSessionNotFound
+		}
+
+		if sessions[idx].RevokedAt != nil {
+			return nil, errSessionsUnchanged
+		}
+
+		sessions[idx].LastUsedAt = now
+		sessions[idx].UserAgent = meta.UserAgent
+		sessions[idx].IP = meta.IP
+		return sessions, nil
+	})
+	if errors.Is(err, ErrSessionNotFound) {
+		_, err = s.Create(ctx, discordId, refreshToken, meta)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	sessionTouches.Store(id, now)
+	return nil
+}
+
+// IsRevoked returns true when the session for refreshToken has been revoked.
+func (s *SessionService) IsRevoked(ctx context.Context, discordId, refreshToken string) (bool, error) {
+	sessions, err := s.load(ctx, discordId)
+	if err != nil {
+		return false, err
+	}
+
+	id := SessionID(refreshToken)
+	idx := slices.IndexFunc(sessions, func(session model.Session) bool { return session.ID == id })
+	return idx != -1 && sessions[idx].RevokedAt != nil, nil
+}
+
+// Revoke marks a session as revoked and returns its refresh token so it can be revoked with Cognito. The token is
+// empty when it was never stored. It returns ErrSessionNotFound when the user has no such active session.
+func (s *SessionService) Revoke(ctx context.Context, discordId, sessionId string) (string, error) {
+	err := s.update(ctx, discordId, func(sessions []model.Session) ([]model.Session, error) {
+		idx := slices.IndexFunc(sessions, func(session model.Session) bool { return session.ID == sessionId })
+		if idx == -1 || sessions[idx].RevokedAt != nil {
+			return nil, ErrSessionNotFound
+		}
+
+		now := time.Now().UTC()
+		sessions[idx].RevokedAt = &now
+		return sessions, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	refreshToken, err := s.secrets.Get(ctx, discordId, sessionSecret(sessionId))
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return "", err
+	}
+
+	if err = s.secrets.Remove(ctx, discordId, sessionSecret(sessionId)); err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// RevokeAll marks every session of the user as revoked and discards their refresh tokens.
+func (s *SessionService) RevokeAll(ctx context.Context, discordId string) error {
+	var revoked []string
+	err := s.update(ctx, discordId, func(sessions []model.Session) ([]model.Session, error) {
+		revoked = revoked[:0]
+		now := time.Now().UTC()
+		for i := range sessions {
+			if sessions[i].RevokedAt != nil {
+				continue
+			}
+
+			sessions[i].RevokedAt = &now
+			revoked = append(revoked, sessions[i].ID)
+		}
+
+		if len(revoked) == 0 {
+			return nil, errSessionsUnchanged
+		}
+		return sessions, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range revoked {
+		if err = s.secrets.Remove(ctx, discordId, sessionSecret(id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes every session record belonging to the user.
+func (s *SessionService) Delete(ctx context.Context, discordId string) error {
+	return s.s3.DeleteObject(ctx, sessionsKey(discordId))
+}
+
+// prune drops sessions revoked longer than revokedSessionRetention ago and, once the user has more than
+// maxSessionsPerUser active sessions, the least recently used ones. It returns the ids of the active sessions it
+// dropped so their tokens can be revoked.
+func prune(sessions []model.Session) ([]model.Session, []string) {
+	cutoff := time.Now().Add(-revokedSessionRetention)
+	sessions = slices.DeleteFunc(sessions, func(session model.Session) bool {
+		return session.RevokedAt != nil && session.RevokedAt.Before(cutoff)
+	})
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt) })
+
+	var dropped []string
+	active := 0
+	sessions = slices.DeleteFunc(sessions, func(session model.Session) bool {
+		if session.RevokedAt != nil {
+			return false
+		}
+
+		active++
+		if active <= maxSessionsPerUser {
+			return false
+		}
+
+		dropped = append(dropped, session.ID)
+		return true
+	})
+
+	return sessions, dropped
+}
+
+func (s *SessionService) load(ctx context.Context, discordId string) ([]model.Session, error) {
+	sessions := make([]model.Session, 0)
+	err := s.s3.GetJSON(ctx, sessionsKey(discordId), &sessions)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// update applies fn to the user's sessions and writes them back only if they were not modified in the meantime,
+// retrying with the latest sessions otherwise. fn may be called more than once and returns errSessionsUnchanged
+// when there is nothing to write.
+func (s *SessionService) update(ctx context.Context, discordId string, fn func(sessions []model.Session) ([]model.Session, error)) error {
+	for attempt := 0; attempt < maxSessionUpdateRetries; attempt++ {
+		sessions := make([]model.Session, 0)
+		etag, err := s.s3.GetJSONWithETag(ctx, sessionsKey(discordId), &sessions)
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+
+		sessions, err = fn(sessions)
+		if errors.Is(err, errSessionsUnchanged) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = s.s3.PutJSONIfMatch(ctx, sessionsKey(discordId), sessions, etag)
+		if !errors.Is(err, ErrObjectModified) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("failed to update sessions of user: %s: gave up after %d attempts", discordId, maxSessionUpdateRetries)
+}
+
+func sessionsKey(discordId string) string {
+	return fmt.Sprintf("%s%s.json", sessionsPrefix, discordId)
+}
+
+func sessionSecret(sessionId string) string {
+	return "session:" + sessionId
+}
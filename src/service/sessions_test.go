@@ -0,0 +1,77 @@
+package service
+
+import (
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func sessionIds(sessions []model.Session) []string {
+	result := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, session.ID)
+	}
+	return result
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	recentlyRevoked := now.Add(-time.Hour)
+	longRevoked := now.Add(-revokedSessionRetention - time.Hour)
+
+	t.Run("drops sessions revoked before the retention", func(t *testing.T) {
+		sessions := []model.Session{
+			{ID: "active", LastUsedAt: now},
+			{ID: "recently-revoked", LastUsedAt: now.Add(-time.Minute), RevokedAt: &recentlyRevoked},
+			{ID: "long-revoked", LastUsedAt: now.Add(-2 * time.Minute), RevokedAt: &longRevoked},
+		}
+
+		kept, dropped := prune(sessions)
+		if got, want := sessionIds(kept), []string{"active", "recently-revoked"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("kept = %v, want %v", got, want)
+		}
+		if len(dropped) != 0 {
+			t.Errorf("dropped = %v, want no active sessions dropped", dropped)
+		}
+	})
+
+	t.Run("drops the least recently used active sessions over the limit", func(t *testing.T) {
+		sessions := make([]model.Session, 0, maxSessionsPerUser+3)
+		for i := 0; i < maxSessionsPerUser+2; i++ {
+			sessions = append(sessions, model.Session{ID: fmt.Sprintf("session-%d", i), LastUsedAt: now.Add(-time.Duration(i) * time.Minute)})
+		}
+
+		// Revoked sessions do not count towards the limit even when they are older than every active session
+		sessions = append(sessions, model.Session{ID: "revoked", LastUsedAt: now.Add(-24 * time.Hour), RevokedAt: &recentlyRevoked})
+
+		kept, dropped := prune(sessions)
+		want := []string{fmt.Sprintf("session-%d", maxSessionsPerUser), fmt.Sprintf("session-%d", maxSessionsPerUser+1)}
+		if !reflect.DeepEqual(dropped, want) {
+			t.Errorf("dropped = %v, want %v", dropped, want)
+		}
+
+		if len(kept) != maxSessionsPerUser+1 {
+			t.Fatalf("kept %d sessions, want %d", len(kept), maxSessionsPerUser+1)
+		}
+		if kept[0].ID != "session-0" || kept[len(kept)-1].ID != "revoked" {
+			t.Errorf("kept = %v, want the sessions sorted by last use", sessionIds(kept))
+		}
+	})
+
+	t.Run("sessions under the limit are kept", func(t *testing.T) {
+		sessions := []model.Session{
+			{ID: "older", LastUsedAt: now.Add(-time.Hour)},
+			{ID: "newer", LastUsedAt: now},
+		}
+
+		kept, dropped := prune(sessions)
+		if got, want := sessionIds(kept), []string{"newer", "older"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("kept = %v, want %v", got, want)
+		}
+		if len(dropped) != 0 {
+			t.Errorf("dropped = %v, want none", dropped)
+		}
+	})
+}
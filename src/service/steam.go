@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	"github.com/cbartram/hearthhub/src/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSteamOpenIDEndpoint = "https://steamcommunity.com/openid/login"
+	openIDNamespace            = "http://specs.openid.net/auth/2.0"
+	openIDIdentifierSelect     = "http://specs.openid.net/auth/2.0/identifier_select"
+	steamLinkPrefix            = "steam-link/"
+	steamLinkStateLifetime     = 10 * time.Minute
+)
+
+var (
+	claimedIDPattern = regexp.MustCompile(`/openid/id/(7656119\d{10})$`)
+
+	ErrSteamLinkExpired = errors.New("steam link request does not exist or has expired")
+	ErrSteamAssertion   = errors.New("steam openid assertion is invalid")
+)
+
+// steamLinkState ties an OpenID callback back to the user who started linking since the callback is a browser
+// redirect from Steam and carries none of the user's credentials.
+type steamLinkState struct {
+	DiscordID string    `json:"discordId"`
+	ReturnTo  string    `json:"returnTo"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SteamService links Steam accounts to users with Steam OpenID 2.0 so they do not need to look up their Steam64 ID.
+type SteamService struct {
+	s3          *S3Service
+	cognito     *CognitoService
+	endpoint    string
+	callbackURL string
+	redirectURL string
+	httpClient  *http.Client
+}
+
+// MakeSteamService creates a new Steam service. STEAM_OPENID_ENDPOINT overrides the Steam OpenID provider which is
+// useful when testing locally. STEAM_LINK_CALLBACK_URL overrides the callback URL derived from the request, which is
+// needed behind proxies that rewrite the path, and STEAM_LINK_REDIRECT_URL is the web app page users are sent to
+// once linking has finished.
+func MakeSteamService(s3 *S3Service, cognito *CognitoService) *SteamService {
+	endpoint := os.Getenv("STEAM_OPENID_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultSteamOpenIDEndpoint
+	}
+
+	return &SteamService{
+		s3:          s3,
+		cognito:     cognito,
+		endpoint:    endpoint,
+		callbackURL: os.Getenv("STEAM_LINK_CALLBACK_URL"),
+		redirectURL: os.Getenv("STEAM_LINK_REDIRECT_URL"),
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// StartLink returns the Steam URL the user must be sent to. callbackURL is where Steam redirects back to once the
+// user has signed in unless STEAM_LINK_CALLBACK_URL is set, a single use state parameter is added to it to identify
+// the user.
+func (s *SteamService) StartLink(ctx context.Context, discordId, callbackURL string) (string, error) {
+	if s.callbackURL != "" {
+		callbackURL = s.callbackURL
+	}
+
+	callback, err := url.Parse(callbackURL)
+	if err != nil || callback.Scheme == "" || callback.Host == "" {
+		return "", fmt.Errorf("invalid callback url: %s", callbackURL)
+	}
+
+	state, err := util.MakeCrypto().GenerateID(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate state: %w", err)
+	}
+
+	query := callback.Query()
+	query.Set("state", state)
+	callback.RawQuery = query.Encode()
+	returnTo := callback.String()
+
+	err = s.s3.PutJSON(ctx, steamLinkKey(state), steamLinkState{
+		DiscordID: discordId,
+		ReturnTo:  returnTo,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save link state: %w", err)
+	}
+
+	params := url.Values{
+		"openid.ns":         {openIDNamespace},
+		"openid.mode":       {"checkid_setup"},
+		"openid.return_to":  {returnTo},
+		"openid.realm":      {callback.Scheme + "://" + callback.Host},
+		"openid.identity":   {openIDIdentifierSelect},
+		"openid.claimed_id": {openIDIdentifierSelect},
+	}
+
+	return s.endpoint + "?" + params.Encode(), nil
+}
+
+// CompleteLink verifies the OpenID assertion Steam redirected back with, stores the Steam64 ID from its claimed_id
+// on the user who started linking and returns the user's Discord ID and Steam ID. The assertion is verified directly
+// with Steam using check_authentication so a forged redirect cannot link an arbitrary Steam account.
+func (s *SteamService) CompleteLink(ctx context.Context, params url.Values) (string, string, error) {
+	stateId := params.Get("state")
+	if stateId == "" {
+		return "", "", ErrSteamLinkExpired
+	}
+
+	var state steamLinkState
+	if err := s.s3.GetJSON(ctx, steamLinkKey(stateId), &state); err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return "", "", ErrSteamLinkExpired
+		}
+		return "", "", err
+	}
+
+	// The state is single use regardless of whether the assertion turns out to be valid
+	if err := s.s3.DeleteObjects(ctx, []string{steamLinkKey(stateId)}); err != nil {
+		log.Errorf("failed to delete steam link state: %s: %v", stateId, err)
+	}
+
+	if time.Since(state.CreatedAt) > steamLinkStateLifetime {
+		return "", "", ErrSteamLinkExpired
+	}
+
+	if params.Get("openid.mode") != "id_res" {
+		return "", "", fmt.Errorf("%w: unexpected mode: %s", ErrSteamAssertion, params.Get("openid.mode"))
+	}
+
+	if params.Get("openid.op_endpoint") != s.endpoint {
+		return "", "", fmt.Errorf("%w: unexpected provider: %s", ErrSteamAssertion, params.Get("openid.op_endpoint"))
+	}
+
+	if params.Get("openid.return_to") != state.ReturnTo {
+		return "", "", fmt.Errorf("%w: return_to does not match", ErrSteamAssertion)
+	}
+
+	match := claimedIDPattern.FindStringSubmatch(params.Get("openid.claimed_id"))
+	if match == nil {
+		return "", "", fmt.Errorf("%w: claimed_id is not a steam id", ErrSteamAssertion)
+	}
+	steamId := match[1]
+
+	if err := s.checkAuthentication(ctx, params); err != nil {
+		return "", "", err
+	}
+
+	_, err := s.cognito.UpdateUser(ctx, state.DiscordID, func(user *model.CognitoUser) {
+		user.SteamID = steamId
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	log.Infof("linked steam id: %s to user: %s", steamId, state.DiscordID)
+	return state.DiscordID, steamId, nil
+}
+
+// ResultURL returns the web app URL to redirect the user to after the callback with the outcome of linking in the
+// query string. It is empty when STEAM_LINK_REDIRECT_URL is not set.
+func (s *SteamService) ResultURL(steamId string, linkErr error) string {
+	if s.redirectURL == "" {
+		return ""
+	}
+
+	params := url.Values{"steam": {"linked"}, "steamId": {steamId}}
+	if linkErr != nil {
+		params = url.Values{"steam": {"error"}, "reason": {linkErr.Error()}}
+	}
+
+	separator := "?"
+	if strings.Contains(s.redirectURL, "?") {
+		separator = "&"
+	}
+	return s.redirectURL + separator + params.Encode()
+}
+
+// Unlink removes the Steam ID linked to a user.
+func (s *SteamService) Unlink(ctx context.Context, discordId string) error {
+	_, err := s.cognito.UpdateUser(ctx, discordId, func(user *model.CognitoUser) {
+		user.SteamID = ""
+	})
+	return err
+}
+
+// checkAuthentication asks Steam to confirm it issued the assertion by echoing every openid parameter back with the
+// mode changed to check_authentication.
+func (s *SteamService) checkAuthentication(ctx context.Context, params url.Values) error {
+	form := url.Values{}
+	for key, values := range params {
+		if strings.HasPrefix(key, "openid.") {
+			form[key] = values
+		}
+	}
+	form.Set("openid.mode", "check_authentication")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create check_authentication request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to verify assertion with steam: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return fmt.Errorf("failed to read check_authentication response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("steam returned status %d for check_authentication", resp.StatusCode)
+	}
+
+	// The response is in key-value form encoding, one key:value pair per line
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.TrimSpace(line) == "is_valid:true" {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: steam rejected the assertion", ErrSteamAssertion)
+}
+
+func steamLinkKey(state string) string {
+	return steamLinkPrefix + state + ".json"
+}
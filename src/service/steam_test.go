@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestClaimedIDPattern(t *testing.T) {
+	tests := []struct {
+		claimedId string
+		want      string
+	}{
+		{claimedId: "https://steamcommunity.com/openid/id/76561198000000001", want: "76561198000000001"},
+		{claimedId: "https://steamcommunity.com/openid/id/76561198000000001/"},
+		{claimedId: "https://steamcommunity.com/openid/id/7656119800000000"},
+		{claimedId: "https://steamcommunity.com/openid/id/12345678900000001"},
+		{claimedId: "https://steamcommunity.com/openid/id/76561198000000001?id=1"},
+		{claimedId: openIDIdentifierSelect},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if match := claimedIDPattern.FindStringSubmatch(tt.claimedId); match != nil {
+			got = match[1]
+		}
+		if got != tt.want {
+			t.Errorf("claimed id: %s matched %q, want %q", tt.claimedId, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAuthentication(t *testing.T) {
+	params := url.Values{
+		"state":             {"abc"},
+		"openid.mode":       {"id_res"},
+		"openid.claimed_id": {"https://steamcommunity.com/openid/id/76561198000000001"},
+		"openid.sig":        {"signature"},
+	}
+
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		wantErr   bool
+		assertion bool
+	}{
+		{name: "valid", status: http.StatusOK, body: "ns:http://specs.openid.net/auth/2.0\nis_valid:true\n"},
+		{name: "rejected", status: http.StatusOK, body: "ns:http://specs.openid.net/auth/2.0\nis_valid:false\n", wantErr: true, assertion: true},
+		{name: "valid only in another key", status: http.StatusOK, body: "ns:is_valid:true\n", wantErr: true, assertion: true},
+		{name: "server error", status: http.StatusInternalServerError, body: "is_valid:true\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var form url.Values
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Errorf("failed to parse form: %v", err)
+				}
+				form = r.PostForm
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			steam := &SteamService{endpoint: server.URL, httpClient: server.Client()}
+			err := steam.checkAuthentication(context.Background(), params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkAuthentication() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrSteamAssertion) != tt.assertion {
+				t.Errorf("checkAuthentication() error = %v, want ErrSteamAssertion %v", err, tt.assertion)
+			}
+
+			// Every openid parameter is echoed back to steam with only the mode changed
+			if got := form.Get("openid.mode"); got != "check_authentication" {
+				t.Errorf("openid.mode = %s, want check_authentication", got)
+			}
+			if got := form.Get("openid.sig"); got != "signature" {
+				t.Errorf("openid.sig = %s, want signature", got)
+			}
+			if form.Has("state") {
+				t.Error("non openid parameter was sent to steam")
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/cbartram/hearthhub/src/model"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// CurrentSchemaVersion is written to custom:schema_version whenever a user is created or updated. Bump it and
+	// add an entry to attributeUpgrades whenever the meaning or format of an attribute changes.
+	CurrentSchemaVersion = 2
+	schemaVersionAttr    = "custom:schema_version"
+
+	// legacyNilValue was written at creation by schema version 1 for attributes which had no value yet.
+	legacyNilValue = "nil"
+)
+
+var (
+	snowflakePattern = regexp.MustCompile(`^\d{1,20}$`)
+
+	// userAttributes maps every Cognito attribute to its typed field on model.CognitoUser. Read only attributes are
+	// decoded but never encoded.
+	userAttributes = []userAttribute{
+		{
+			name:     "sub",
+			readOnly: true,
+			get:      func(u *model.CognitoUser) string { return u.CognitoID },
+			set:      func(u *model.CognitoUser, v string) error { u.CognitoID = v; return nil },
+		},
+		{
+			name: "email",
+			get:  func(u *model.CognitoUser) string { return u.Email },
+			set:  func(u *model.CognitoUser, v string) error { u.Email = v; return nil },
+		},
+		{
+			name: "custom:discord_id",
+			get:  func(u *model.CognitoUser) string { return u.DiscordID },
+			set: func(u *model.CognitoUser, v string) error {
+				if !snowflakePattern.MatchString(v) {
+					return errors.New("not a discord snowflake")
+				}
+				u.DiscordID = v
+				return nil
+			},
+		},
+		{
+			name: "custom:discord_username",
+			get:  func(u *model.CognitoUser) string { return u.DiscordUsername },
+			set:  func(u *model.CognitoUser, v string) error { u.DiscordUsername = v; return nil },
+		},
+		{
+			name: "custom:avatar_id",
+			get:  func(u *model.CognitoUser) string { return u.AvatarId },
+			set:  func(u *model.CognitoUser, v string) error { u.AvatarId = v; return nil },
+		},
+		{
+			name: "custom:steam_id",
+			get:  func(u *model.CognitoUser) string { return u.SteamID },
+			set: func(u *model.CognitoUser, v string) error {
+				if !steamIDPattern.MatchString(v) {
+					return errors.New("not a steam64 id")
+				}
+				u.SteamID = v
+				return nil
+			},
+		},
+	}
+
+	// attributeUpgrades migrate attributes written by schema version N to N+1. They run in order on every read of
+	// an older user so decoding only has to understand the current format.
+	attributeUpgrades = map[int]func(attrs map[string]string){
+		// Version 1 wrote "nil" for attributes without a value. Its state and secret attributes are not touched
+		// here, the migrate-user-state and rewrap-secrets run modes move them since they must be copied first.
+		1: func(attrs map[string]string) {
+			for name, value := range attrs {
+				if value == legacyNilValue {
+					delete(attrs, name)
+				}
+			}
+		},
+	}
+)
+
+type userAttribute struct {
+	name     string
+	readOnly bool
+	get      func(u *model.CognitoUser) string
+	set      func(u *model.CognitoUser, value string) error
+}
+
+// AttributeDecodeError is returned when a single attribute could not be decoded into its field.
+type AttributeDecodeError struct {
+	Attribute string
+	Err       error
+}
+
+func (e *AttributeDecodeError) Error() string {
+	return fmt.Sprintf("invalid %s: %v", e.Attribute, e.Err)
+}
+
+func (e *AttributeDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeUserAttributes maps Cognito attributes onto a CognitoUser, upgrading them from older schema versions first.
+// Every attribute which fails to decode is reported as an *AttributeDecodeError joined into the returned error, the
+// user is still returned with every other field set. Upgraded reports whether the stored attributes are out of date
+// and should be re-encoded.
+func DecodeUserAttributes(attributes []types.AttributeType) (user *model.CognitoUser, upgraded bool, err error) {
+	attrs := make(map[string]string, len(attributes))
+	for _, attr := range attributes {
+		attrs[aws.ToString(attr.Name)] = aws.ToString(attr.Value)
+	}
+
+	user = &model.CognitoUser{SchemaVersion: 1}
+	if raw, ok := attrs[schemaVersionAttr]; ok {
+		version, convErr := strconv.Atoi(raw)
+		if convErr != nil || version < 1 || version > CurrentSchemaVersion {
+			return user, false, &AttributeDecodeError{Attribute: schemaVersionAttr, Err: fmt.Errorf("unsupported version: %q", raw)}
+		}
+		user.SchemaVersion = version
+	}
+
+	for version := user.SchemaVersion; version < CurrentSchemaVersion; version++ {
+		attributeUpgrades[version](attrs)
+		upgraded = true
+	}
+	user.SchemaVersion = CurrentSchemaVersion
+
+	var errs []error
+	for _, field := range userAttributes {
+		value, ok := attrs[field.name]
+		if !ok || value == "" {
+			continue
+		}
+
+		if setErr := field.set(user, value); setErr != nil {
+			errs = append(errs, &AttributeDecodeError{Attribute: field.name, Err: setErr})
+		}
+	}
+
+	return user, upgraded, errors.Join(errs...)
+}
+
+// EncodeUserAttributes maps a CognitoUser onto the attributes to write, always including custom:schema_version.
+// Fields without a value are returned in remove so they can be deleted rather than written as placeholders.
+func EncodeUserAttributes(user *model.CognitoUser) (set []types.AttributeType, remove []string) {
+	set = []types.AttributeType{
+		{Name: aws.String(schemaVersionAttr), Value: aws.String(strconv.Itoa(CurrentSchemaVersion))},
+	}
+
+	for _, field := range userAttributes {
+		if field.readOnly {
+			continue
+		}
+
+		if value := field.get(user); value != "" {
+			set = append(set, types.AttributeType{Name: aws.String(field.name), Value: aws.String(value)})
+		} else {
+			remove = append(remove, field.name)
+		}
+	}
+
+	return set, remove
+}
+
+// EncodeUserChanges returns only the attributes which differ between before and after so concurrent updates to
+// other attributes are not overwritten.
+func EncodeUserChanges(before, after *model.CognitoUser) (set []types.AttributeType, remove []string) {
+	set = []types.AttributeType{
+		{Name: aws.String(schemaVersionAttr), Value: aws.String(strconv.Itoa(CurrentSchemaVersion))},
+	}
+
+	for _, field := range userAttributes {
+		if field.readOnly || field.get(before) == field.get(after) {
+			continue
+		}
+
+		if value := field.get(after); value != "" {
+			set = append(set, types.AttributeType{Name: aws.String(field.name), Value: aws.String(value)})
+		} else {
+			remove = append(remove, field.name)
+		}
+	}
+
+	return set, remove
+}
+
+// legacyAttributesToRemove are the attributes version 1 users may still have with a "nil" placeholder which the
+// upgrade drops.
+func legacyAttributesToRemove(attributes []types.AttributeType) []string {
+	var names []string
+	for _, attr := range attributes {
+		name := aws.ToString(attr.Name)
+		if strings.HasPrefix(name, "custom:") && aws.ToString(attr.Value) == legacyNilValue {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/cbartram/hearthhub/src/model"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDecodeUserAttributes(t *testing.T) {
+	attributes := func(pairs ...string) []types.AttributeType {
+		attrs := make([]types.AttributeType, 0, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			attrs = append(attrs, types.AttributeType{Name: aws.String(pairs[i]), Value: aws.String(pairs[i+1])})
+		}
+		return attrs
+	}
+
+	tests := []struct {
+		name         string
+		attributes   []types.AttributeType
+		want         *model.CognitoUser
+		wantUpgraded bool
+		wantInvalid  []string
+	}{
+		{
+			name: "current version",
+			attributes: attributes(
+				"sub", "c0ffee",
+				"email", "viking@example.com",
+				"custom:schema_version", "2",
+				"custom:discord_id", "123456789012345678",
+				"custom:discord_username", "ragnar",
+				"custom:avatar_id", "a_1",
+				"custom:steam_id", "76561198000000001",
+			),
+			want: &model.CognitoUser{
+				CognitoID:       "c0ffee",
+				Email:           "viking@example.com",
+				DiscordID:       "123456789012345678",
+				DiscordUsername: "ragnar",
+				AvatarId:        "a_1",
+				SteamID:         "76561198000000001",
+				SchemaVersion:   CurrentSchemaVersion,
+			},
+		},
+		{
+			name: "version 1 without a schema version",
+			attributes: attributes(
+				"custom:discord_id", "123456789012345678",
+				"custom:discord_username", "ragnar",
+			),
+			want:         &model.CognitoUser{DiscordID: "123456789012345678", DiscordUsername: "ragnar", SchemaVersion: CurrentSchemaVersion},
+			wantUpgraded: true,
+		},
+		{
+			name: "version 1 nil placeholders are dropped",
+			attributes: attributes(
+				"custom:schema_version", "1",
+				"custom:discord_id", "123456789012345678",
+				"custom:steam_id", "nil",
+				"custom:avatar_id", "nil",
+			),
+			want:         &model.CognitoUser{DiscordID: "123456789012345678", SchemaVersion: CurrentSchemaVersion},
+			wantUpgraded: true,
+		},
+		{
+			name: "current version keeps a literal nil",
+			attributes: attributes(
+				"custom:schema_version", "2",
+				"custom:discord_username", "nil",
+			),
+			want: &model.CognitoUser{DiscordUsername: "nil", SchemaVersion: CurrentSchemaVersion},
+		},
+		{
+			name: "each invalid field is reported and the rest still decoded",
+			attributes: attributes(
+				"custom:schema_version", "2",
+				"custom:discord_id", "ragnar",
+				"custom:discord_username", "ragnar",
+				"custom:steam_id", "12345",
+			),
+			want:        &model.CognitoUser{DiscordUsername: "ragnar", SchemaVersion: CurrentSchemaVersion},
+			wantInvalid: []string{"custom:discord_id", "custom:steam_id"},
+		},
+		{
+			name:        "unsupported schema version",
+			attributes:  attributes("custom:schema_version", "3", "custom:discord_username", "ragnar"),
+			want:        &model.CognitoUser{SchemaVersion: 1},
+			wantInvalid: []string{"custom:schema_version"},
+		},
+		{
+			name:        "malformed schema version",
+			attributes:  attributes("custom:schema_version", "two"),
+			want:        &model.CognitoUser{SchemaVersion: 1},
+			wantInvalid: []string{"custom:schema_version"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, upgraded, err := DecodeUserAttributes(tt.attributes)
+
+			if !reflect.DeepEqual(user, tt.want) {
+				t.Errorf("DecodeUserAttributes() user = %+v, want %+v", user, tt.want)
+			}
+
+			if upgraded != tt.wantUpgraded {
+				t.Errorf("DecodeUserAttributes() upgraded = %v, want %v", upgraded, tt.wantUpgraded)
+			}
+
+			var invalid []string
+			if err != nil {
+				var errs []error
+				if joined, ok := err.(interface{ Unwrap() []error }); ok {
+					errs = joined.Unwrap()
+				} else {
+					errs = []error{err}
+				}
+
+				for _, e := range errs {
+					var decodeErr *AttributeDecodeError
+					if !errors.As(e, &decodeErr) {
+						t.Fatalf("DecodeUserAttributes() error = %v, want only *AttributeDecodeError", e)
+					}
+					invalid = append(invalid, decodeErr.Attribute)
+				}
+			}
+
+			sort.Strings(invalid)
+			if !reflect.DeepEqual(invalid, tt.wantInvalid) {
+				t.Errorf("DecodeUserAttributes() invalid attributes = %v, want %v", invalid, tt.wantInvalid)
+			}
+		})
+	}
+}
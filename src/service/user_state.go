@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cbartram/hearthhub/src/model"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sync"
+	"time"
+)
+
+const maxUserStateRetries = 5
+
+var (
+	// Services are created per request so they share one repository
+	userState     UserStateRepository
+	userStateErr  error
+	userStateOnce sync.Once
+
+	ErrUserStateConflict      = errors.New("user state was modified concurrently")
+	ErrUserStateNotFound      = errors.New("user state does not exist")
+	ErrUserStateNotConfigured = errors.New("user state is not configured, set USER_STATE_TABLE_NAME or USER_STATE_BACKEND=memory")
+)
+
+// UserStateRepository stores each user's installed mods, backups, server details and preferences.
+type UserStateRepository interface {
+	// Get returns the user's state or ErrUserStateNotFound.
+	Get(ctx context.Context, discordId string) (*model.UserState, error)
+
+	// Put writes state only if the stored version still equals state.Version, or nothing is stored when it is zero,
+	// otherwise it returns ErrUserStateConflict. On success state.Version is incremented.
+	Put(ctx context.Context, state *model.UserState) error
+
+	// Delete removes the user's state.
+	Delete(ctx context.Context, discordId string) error
+}
+
+// MakeUserStateRepository returns the process wide user state repository. It is the DynamoDB repository for the
+// table named by USER_STATE_TABLE_NAME, or an in-memory repository only when USER_STATE_BACKEND is "memory". It
+// returns an error when neither is configured so a deployment never silently loses user state.
+func MakeUserStateRepository() (UserStateRepository, error) {
+	userStateOnce.Do(func() {
+		if os.Getenv("USER_STATE_BACKEND") == "memory" {
+			log.Warnf("USER_STATE_BACKEND is memory, user state will only be kept in memory")
+			userState = MakeMemoryUserStateRepository()
+			return
+		}
+
+		table := os.Getenv("USER_STATE_TABLE_NAME")
+		if table == "" {
+			userStateErr = ErrUserStateNotConfigured
+			return
+		}
+
+		repo, err := MakeDynamoUserStateRepository(table)
+		if err != nil {
+			userStateErr = fmt.Errorf("failed to create dynamodb user state repository: %w", err)
+			return
+		}
+		userState = repo
+	})
+
+	return userState, userStateErr
+}
+
+// unavailableUserStateRepository fails every operation with the error the repository could not be created with so
+// requests fail rather than reading and writing state which is not kept.
+type unavailableUserStateRepository struct {
+	err error
+}
+
+func (u unavailableUserStateRepository) Get(ctx context.Context, discordId string) (*model.UserState, error) {
+	return nil, u.err
+}
+
+func (u unavailableUserStateRepository) Put(ctx context.Context, state *model.UserState) error {
+	return u.err
+}
+
+func (u unavailableUserStateRepository) Delete(ctx context.Context, discordId string) error {
+	return u.err
+}
+
+// UpdateUserState applies update to the user's current state and writes it, re-reading and retrying when another
+// writer got there first. A user without state starts from an empty one.
+func UpdateUserState(ctx context.Context, repo UserStateRepository, discordId string, update func(state *model.UserState) error) (*model.UserState, error) {
+	for attempt := 0; attempt < maxUserStateRetries; attempt++ {
+		state, err := repo.Get(ctx, discordId)
+		if errors.Is(err, ErrUserStateNotFound) {
+			state = NewUserState(discordId)
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err = update(state); err != nil {
+			return nil, err
+		}
+
+		err = repo.Put(ctx, state)
+		if !errors.Is(err, ErrUserStateConflict) {
+			return state, err
+		}
+	}
+
+	return nil, fmt.Errorf("%w: gave up after %d attempts", ErrUserStateConflict, maxUserStateRetries)
+}
+
+// NewUserState returns the state of a user who has nothing installed.
+func NewUserState(discordId string) *model.UserState {
+	return &model.UserState{
+		DiscordID:        discordId,
+		InstalledMods:    make(map[string]bool),
+		InstalledBackups: make(map[string]bool),
+		Preferences:      make(map[string]string),
+	}
+}
+
+// MemoryUserStateRepository keeps user state in process. It is intended for tests and local development.
+type MemoryUserStateRepository struct {
+	mu     sync.Mutex
+	states map[string]model.UserState
+}
+
+// MakeMemoryUserStateRepository creates an empty in-memory repository.
+func MakeMemoryUserStateRepository() *MemoryUserStateRepository {
+	return &MemoryUserStateRepository{states: make(map[string]model.UserState)}
+}
+
+func (m *MemoryUserStateRepository) Get(ctx context.Context, discordId string) (*model.UserState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.states[discordId]
+	if !ok {
+		return nil, ErrUserStateNotFound
+	}
+
+	return copyUserState(state), nil
+}
+
+func (m *MemoryUserStateRepository) Put(ctx context.Context, state *model.UserState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.states[state.DiscordID].Version != state.Version {
+		return ErrUserStateConflict
+	}
+
+	state.Version++
+	state.UpdatedAt = time.Now().UTC()
+	m.states[state.DiscordID] = *copyUserState(*state)
+	return nil
+}
+
+func (m *MemoryUserStateRepository) Delete(ctx context.Context, discordId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.states, discordId)
+	return nil
+}
+
+// copyUserState deep copies state so callers cannot modify what is stored without a Put.
+func copyUserState(state model.UserState) *model.UserState {
+	copied := state
+	copied.InstalledMods = make(map[string]bool, len(state.InstalledMods))
+	for k, v := range state.InstalledMods {
+		copied.InstalledMods[k] = v
+	}
+	copied.InstalledBackups = make(map[string]bool, len(state.InstalledBackups))
+	for k, v := range state.InstalledBackups {
+		copied.InstalledBackups[k] = v
+	}
+	copied.Preferences = make(map[string]string, len(state.Preferences))
+	for k, v := range state.Preferences {
+		copied.Preferences[k] = v
+	}
+	copied.ServerDetails = append([]byte(nil), state.ServerDetails...)
+	return &copied
+}
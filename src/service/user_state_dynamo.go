@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/cbartram/hearthhub/src/model"
+	"strconv"
+	"time"
+)
+
+// DynamoUserStateRepository stores user state in a DynamoDB table with a "discordId" string partition key. The state
+// is stored as a JSON document alongside a numeric version attribute which conditional writes are checked against.
+type DynamoUserStateRepository struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// MakeDynamoUserStateRepository creates a repository which reads and writes table.
+func MakeDynamoUserStateRepository(table string) (*DynamoUserStateRepository, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("error loading default aws config: %w", err)
+	}
+
+	return &DynamoUserStateRepository{
+		client: dynamodb.NewFromConfig(cfg),
+		table:  table,
+	}, nil
+}
+
+func (d *DynamoUserStateRepository) Get(ctx context.Context, discordId string) (*model.UserState, error) {
+	out, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            map[string]types.AttributeValue{"discordId": &types.AttributeValueMemberS{Value: discordId}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user state: %s: %w", discordId, err)
+	}
+
+	if out.Item == nil {
+		return nil, ErrUserStateNotFound
+	}
+
+	state := NewUserState(discordId)
+	if err = json.Unmarshal([]byte(stringAttribute(out.Item, "state")), state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user state: %s: %w", discordId, err)
+	}
+
+	// The version attribute is what writes are conditioned on so it is authoritative over the document
+	if version, ok := out.Item["version"].(*types.AttributeValueMemberN); ok {
+		state.Version, _ = strconv.ParseInt(version.Value, 10, 64)
+	}
+
+	return state, nil
+}
+
+func (d *DynamoUserStateRepository) Put(ctx context.Context, state *model.UserState) error {
+	next := *state
+	next.Version = state.Version + 1
+	next.UpdatedAt = time.Now().UTC()
+
+	document, err := json.Marshal(next)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user state: %w", err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]types.AttributeValue{
+			"discordId": &types.AttributeValueMemberS{Value: state.DiscordID},
+			"state":     &types.AttributeValueMemberS{Value: string(document)},
+			"version":   &types.AttributeValueMemberN{Value: strconv.FormatInt(next.Version, 10)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(discordId)"),
+	}
+
+	if state.Version > 0 {
+		input.ConditionExpression = aws.String("version = :expected")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":expected": &types.AttributeValueMemberN{Value: strconv.FormatInt(state.Version, 10)},
+		}
+	}
+
+	_, err = d.client.PutItem(ctx, input)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrUserStateConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put user state: %s: %w", state.DiscordID, err)
+	}
+
+	state.Version = next.Version
+	state.UpdatedAt = next.UpdatedAt
+	return nil
+}
+
+func (d *DynamoUserStateRepository) Delete(ctx context.Context, discordId string) error {
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key:       map[string]types.AttributeValue{"discordId": &types.AttributeValueMemberS{Value: discordId}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete user state: %s: %w", discordId, err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/cbartram/hearthhub/src/model"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateUserState(t *testing.T) {
+	tests := []struct {
+		name        string
+		conflicts   int
+		wantErr     error
+		wantVersion int64
+		wantCalls   int
+	}{
+		{name: "no conflict", conflicts: 0, wantVersion: 2, wantCalls: 1},
+		{name: "retries after a conflict", conflicts: 2, wantVersion: 4, wantCalls: 3},
+		{name: "gives up after too many conflicts", conflicts: maxUserStateRetries, wantErr: ErrUserStateConflict, wantCalls: maxUserStateRetries},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := MakeMemoryUserStateRepository()
+			if err := repo.Put(ctx, NewUserState("1")); err != nil {
+				t.Fatal(err)
+			}
+
+			calls := 0
+			state, err := UpdateUserState(ctx, repo, "1", func(state *model.UserState) error {
+				calls++
+
+				// Another writer installs a backup between this read and write
+				if calls <= tt.conflicts {
+					if _, err := UpdateUserState(ctx, repo, "1", func(other *model.UserState) error {
+						other.InstalledBackups["other"] = true
+						return nil
+					}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				state.InstalledMods["valheim-plus"] = true
+				return nil
+			})
+
+			if calls != tt.wantCalls {
+				t.Errorf("update called %d times, want %d", calls, tt.wantCalls)
+			}
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("UpdateUserState() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("UpdateUserState() error = %v", err)
+			}
+
+			stored, err := repo.Get(ctx, "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stored.Version != tt.wantVersion || state.Version != tt.wantVersion {
+				t.Errorf("version = %d (returned %d), want %d", stored.Version, state.Version, tt.wantVersion)
+			}
+
+			// The retry must apply the update on top of the conflicting write rather than overwrite it
+			if !stored.InstalledMods["valheim-plus"] || stored.InstalledBackups["other"] != (tt.conflicts > 0) {
+				t.Errorf("stored state = %+v, want both writes applied", stored)
+			}
+		})
+	}
+}
+
+func TestUpdateUserStateCreatesMissingState(t *testing.T) {
+	repo := MakeMemoryUserStateRepository()
+	state, err := UpdateUserState(context.Background(), repo, "1", func(state *model.UserState) error {
+		state.Preferences["theme"] = "dark"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateUserState() error = %v", err)
+	}
+
+	if state.Version != 1 || state.Preferences["theme"] != "dark" {
+		t.Errorf("UpdateUserState() = %+v, want version 1 with the preference set", state)
+	}
+}
+
+func TestLegacyUserState(t *testing.T) {
+	attribute := func(name, value string) types.AttributeType {
+		return types.AttributeType{Name: aws.String(name), Value: aws.String(value)}
+	}
+
+	tests := []struct {
+		name       string
+		attributes []types.AttributeType
+		want       *model.UserState
+		wantErr    bool
+	}{
+		{
+			name:       "no attributes",
+			attributes: nil,
+			want:       NewUserState("1"),
+		},
+		{
+			name: "nil placeholders",
+			attributes: []types.AttributeType{
+				attribute("custom:installed_mods", "nil"),
+				attribute("custom:installed_backups", "nil"),
+				attribute("custom:server_details", "nil"),
+			},
+			want: NewUserState("1"),
+		},
+		{
+			name: "null maps",
+			attributes: []types.AttributeType{
+				attribute("custom:installed_mods", "null"),
+				attribute("custom:installed_backups", "null"),
+			},
+			want: NewUserState("1"),
+		},
+		{
+			name: "installed mods, backups and server details",
+			attributes: []types.AttributeType{
+				attribute("custom:installed_mods", `{"valheim-plus":true,"epic-loot":false}`),
+				attribute("custom:installed_backups", `{"world.db":true}`),
+				attribute("custom:server_details", `{"world_name":"midgard"}`),
+			},
+			want: func() *model.UserState {
+				state := NewUserState("1")
+				state.InstalledMods = map[string]bool{"valheim-plus": true, "epic-loot": false}
+				state.InstalledBackups = map[string]bool{"world.db": true}
+				state.ServerDetails = json.RawMessage(`{"world_name":"midgard"}`)
+				return state
+			}(),
+		},
+		{
+			name:       "invalid server details are dropped",
+			attributes: []types.AttributeType{attribute("custom:server_details", `{"world_name":`)},
+			want:       NewUserState("1"),
+		},
+		{
+			name:       "invalid installed mods",
+			attributes: []types.AttributeType{attribute("custom:installed_mods", `["valheim-plus"]`)},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid installed backups",
+			attributes: []types.AttributeType{attribute("custom:installed_backups", `{"world.db":"yes"}`)},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := legacyUserState("1", tt.attributes)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("legacyUserState() = %+v, want an error", state)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("legacyUserState() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(state, tt.want) {
+				t.Errorf("legacyUserState() = %+v, want %+v", state, tt.want)
+			}
+		})
+	}
+}
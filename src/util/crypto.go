@@ -1,12 +1,16 @@
 package util
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"math/big"
+	"strings"
 	"sync"
 )
 
@@ -17,8 +21,14 @@ const (
 	lowerChars   = "abcdefghijklmnopqrstuvwxyz"
 	numberChars  = "0123456789"
 	specialChars = "!@#$%^&*()_+-=[]{}|;:,.<>?"
+
+	// envelopePrefix marks an envelope ciphertext, the version allows the format to change later.
+	envelopePrefix = "enc:v1:"
+	dataKeySize    = 32
 )
 
+var ErrNotEncrypted = errors.New("value is not an envelope ciphertext")
+
 // PasswordConfig holds the configuration for password generation
 type PasswordConfig struct {
 	Length         int
@@ -29,7 +39,8 @@ type PasswordConfig struct {
 }
 
 type Crypto struct {
-	mu sync.RWMutex
+	mu   sync.RWMutex
+	keys KeyProvider
 }
 
 // MakeCognitoSecretHash Creates a hash based on the user id, service id and secret which must be
@@ -48,6 +59,123 @@ func MakeCrypto() *Crypto {
 	return &Crypto{}
 }
 
+// MakeEnvelopeCrypto creates a Crypto which can also envelope encrypt with data keys wrapped by keys.
+func MakeEnvelopeCrypto(keys KeyProvider) *Crypto {
+	return &Crypto{keys: keys}
+}
+
+// Encrypt encrypts plaintext with AES-256-GCM under a new data key which is wrapped by the key provider's current
+// master key. The result is "enc:v1:<key id>:<wrapped data key>:<nonce and ciphertext>" with each part base64url
+// encoded, so it can be stored as a string and the master key it needs is visible without decrypting it.
+func (c *Crypto) Encrypt(ctx context.Context, plaintext []byte) (string, error) {
+	if c.keys == nil {
+		return "", ErrNoKeyProvider
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	keyId := c.keys.CurrentKeyID()
+	wrapped, err := c.keys.Wrap(ctx, keyId, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	// The key id is authenticated with the ciphertext so it cannot be swapped. The wrapped key is not, but a swapped
+	// one unwraps to a different data key which then fails to open the ciphertext.
+	sealed, err := seal(dataKey, plaintext, []byte(keyId))
+	if err != nil {
+		return "", err
+	}
+
+	return formatEnvelope(keyId, wrapped, sealed), nil
+}
+
+// Decrypt reverses Encrypt.
+func (c *Crypto) Decrypt(ctx context.Context, ciphertext string) ([]byte, error) {
+	if c.keys == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	keyId, wrapped, sealed, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := c.keys.Unwrap(ctx, keyId, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := open(dataKey, sealed, []byte(keyId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// Rewrap re-encrypts ciphertext under the key provider's current master key. It returns false, and ciphertext
+// unchanged, when it is already under the current key.
+func (c *Crypto) Rewrap(ctx context.Context, ciphertext string) (string, bool, error) {
+	if c.keys == nil {
+		return "", false, ErrNoKeyProvider
+	}
+
+	keyId, _, _, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+
+	if keyId == c.keys.CurrentKeyID() {
+		return ciphertext, false, nil
+	}
+
+	// The ciphertext is authenticated with the key id so the value is re-encrypted rather than only the data key
+	// being rewrapped
+	plaintext, err := c.Decrypt(ctx, ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+
+	rewrapped, err := c.Encrypt(ctx, plaintext)
+	return rewrapped, err == nil, err
+}
+
+// IsEncrypted reports whether value is an envelope ciphertext.
+func (c *Crypto) IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, envelopePrefix)
+}
+
+func formatEnvelope(keyId string, wrapped, sealed []byte) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return envelopePrefix + strings.Join([]string{encode([]byte(keyId)), encode(wrapped), encode(sealed)}, ":")
+}
+
+func parseEnvelope(ciphertext string) (string, []byte, []byte, error) {
+	if !strings.HasPrefix(ciphertext, envelopePrefix) {
+		return "", nil, nil, ErrNotEncrypted
+	}
+
+	parts := strings.Split(strings.TrimPrefix(ciphertext, envelopePrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("malformed envelope ciphertext")
+	}
+
+	decoded := make([][]byte, len(parts))
+	for i, part := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("malformed envelope ciphertext: %w", err)
+		}
+		decoded[i] = b
+	}
+
+	return string(decoded[0]), decoded[1], decoded[2], nil
+}
+
 // GeneratePassword generates a cryptographically secure password
 func (c *Crypto) GeneratePassword(config PasswordConfig) (string, error) {
 	if config.Length < minLength {
@@ -115,6 +243,15 @@ func (c *Crypto) GeneratePassword(config PasswordConfig) (string, error) {
 	return string(password), nil
 }
 
+// GenerateID returns a random hex encoded identifier made from n bytes of entropy.
+func (c *Crypto) GenerateID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // Helper function to get a random character from a string
 func getRandomChar(chars string) (byte, error) {
 	if len(chars) == 0 {
@@ -0,0 +1,202 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeKeyfile(t *testing.T, current string, ids ...string) string {
+	t.Helper()
+
+	keys := make(map[string]string)
+	for _, id := range ids {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+
+	raw, err := json.Marshal(map[string]any{"current": current, "keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err = os.WriteFile(path, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func makeLocalCrypto(t *testing.T, path string) *Crypto {
+	t.Helper()
+
+	keys, err := MakeLocalKeyProvider(path)
+	if err != nil {
+		t.Fatalf("failed to load keyfile: %v", err)
+	}
+	return MakeEnvelopeCrypto(keys)
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	crypto := makeLocalCrypto(t, writeKeyfile(t, "dev-1", "dev-1"))
+
+	tests := []struct {
+		name      string
+		plaintext []byte
+	}{
+		{name: "empty", plaintext: []byte{}},
+		{name: "refresh token", plaintext: []byte("eyJjdHkiOiJKV1QiLCJlbmMiOiJBMjU2R0NNIiwiYWxnIjoiUlNBLU9BRVAifQ")},
+		{name: "binary", plaintext: []byte{0x00, 0xff, 0x10, 0x3a}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ciphertext, err := crypto.Encrypt(context.Background(), tt.plaintext)
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+
+			if !crypto.IsEncrypted(ciphertext) {
+				t.Fatalf("Encrypt() = %q, want an envelope ciphertext", ciphertext)
+			}
+
+			plaintext, err := crypto.Decrypt(context.Background(), ciphertext)
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+
+			if string(plaintext) != string(tt.plaintext) {
+				t.Errorf("Decrypt() = %q, want %q", plaintext, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsTamperedEnvelopes(t *testing.T) {
+	path := writeKeyfile(t, "dev-1", "dev-1", "dev-2")
+	crypto := makeLocalCrypto(t, path)
+
+	ciphertext, err := crypto.Encrypt(context.Background(), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(ciphertext, envelopePrefix), ":")
+	encode := base64.RawURLEncoding.EncodeToString
+
+	tests := []struct {
+		name       string
+		ciphertext string
+		wantErr    error
+	}{
+		{
+			name:       "key id swapped for another known key",
+			ciphertext: envelopePrefix + strings.Join([]string{encode([]byte("dev-2")), parts[1], parts[2]}, ":"),
+		},
+		{
+			name:       "unknown key id",
+			ciphertext: envelopePrefix + strings.Join([]string{encode([]byte("dev-3")), parts[1], parts[2]}, ":"),
+			wantErr:    ErrUnknownKey,
+		},
+		{
+			name:       "ciphertext altered",
+			ciphertext: envelopePrefix + strings.Join([]string{parts[0], parts[1], encode([]byte("not the sealed value"))}, ":"),
+		},
+		{
+			name:       "missing part",
+			ciphertext: envelopePrefix + strings.Join(parts[:2], ":"),
+		},
+		{
+			name:       "plaintext",
+			ciphertext: "secret",
+			wantErr:    ErrNotEncrypted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := crypto.Decrypt(context.Background(), tt.ciphertext)
+			if err == nil {
+				t.Fatalf("Decrypt() = %q, want an error", plaintext)
+			}
+
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decrypt() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := writeKeyfile(t, "dev-1", "dev-1", "dev-2")
+	raw, err := os.ReadFile(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rotated keyfile keeps both keys and only changes which is current
+	var keyfile map[string]any
+	if err = json.Unmarshal(raw, &keyfile); err != nil {
+		t.Fatal(err)
+	}
+	keyfile["current"] = "dev-2"
+	rotated, _ := json.Marshal(keyfile)
+	newPath := filepath.Join(dir, "rotated.json")
+	if err = os.WriteFile(newPath, rotated, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	before := makeLocalCrypto(t, oldPath)
+	after := makeLocalCrypto(t, newPath)
+
+	ciphertext, err := before.Encrypt(context.Background(), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		crypto      *Crypto
+		wantChanged bool
+		wantKeyId   string
+	}{
+		{name: "already under the current key", crypto: before, wantChanged: false, wantKeyId: "dev-1"},
+		{name: "under a previous key", crypto: after, wantChanged: true, wantKeyId: "dev-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewrapped, changed, err := tt.crypto.Rewrap(context.Background(), ciphertext)
+			if err != nil {
+				t.Fatalf("Rewrap() error = %v", err)
+			}
+
+			if changed != tt.wantChanged {
+				t.Errorf("Rewrap() changed = %v, want %v", changed, tt.wantChanged)
+			}
+
+			keyId, _, _, err := parseEnvelope(rewrapped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keyId != tt.wantKeyId {
+				t.Errorf("Rewrap() key id = %s, want %s", keyId, tt.wantKeyId)
+			}
+
+			plaintext, err := after.Decrypt(context.Background(), rewrapped)
+			if err != nil || string(plaintext) != "secret" {
+				t.Errorf("Decrypt() = %q, %v, want %q", plaintext, err, "secret")
+			}
+		})
+	}
+}
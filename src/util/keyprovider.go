@@ -0,0 +1,204 @@
+package util
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"os"
+)
+
+var (
+	ErrNoKeyProvider = errors.New("no key provider configured, set KMS_KEY_ID or ENCRYPTION_KEYFILE")
+	ErrUnknownKey    = errors.New("unknown master key")
+)
+
+// KeyProvider wraps and unwraps the per-record data keys used for envelope encryption with a master key. The master
+// key never leaves the provider. Wrapped keys name the master key they were wrapped with so master keys can be
+// rotated while older records remain readable.
+type KeyProvider interface {
+	// CurrentKeyID is the master key new data keys are wrapped with.
+	CurrentKeyID() string
+
+	// Wrap encrypts a data key with the master key keyId.
+	Wrap(ctx context.Context, keyId string, dataKey []byte) ([]byte, error)
+
+	// Unwrap decrypts a data key which was wrapped with the master key keyId.
+	Unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error)
+}
+
+// MakeKeyProvider returns the KMS provider when KMS_KEY_ID is set, otherwise the local keyfile provider when
+// ENCRYPTION_KEYFILE is set.
+func MakeKeyProvider() (KeyProvider, error) {
+	if keyId := os.Getenv("KMS_KEY_ID"); keyId != "" {
+		provider, err := MakeKMSKeyProvider(keyId)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}
+
+	if path := os.Getenv("ENCRYPTION_KEYFILE"); path != "" {
+		provider, err := MakeLocalKeyProvider(path)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}
+
+	return nil, ErrNoKeyProvider
+}
+
+// KMSKeyProvider wraps data keys with an AWS KMS symmetric key. Key ids are KMS key ids, ARNs or aliases. Rotating
+// to a new key only requires changing KMS_KEY_ID, keys used for existing records must stay enabled until they have
+// been rewrapped.
+type KMSKeyProvider struct {
+	client *kms.Client
+	keyId  string
+}
+
+// MakeKMSKeyProvider creates a provider which wraps new data keys with keyId.
+func MakeKMSKeyProvider(keyId string) (*KMSKeyProvider, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("error loading default aws config: %w", err)
+	}
+
+	return &KMSKeyProvider{
+		client: kms.NewFromConfig(cfg),
+		keyId:  keyId,
+	}, nil
+}
+
+func (k *KMSKeyProvider) CurrentKeyID() string {
+	return k.keyId
+}
+
+func (k *KMSKeyProvider) Wrap(ctx context.Context, keyId string, dataKey []byte) ([]byte, error) {
+	out, err := k.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(keyId),
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key with kms key: %s: %w", keyId, err)
+	}
+
+	return out.CiphertextBlob, nil
+}
+
+func (k *KMSKeyProvider) Unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	out, err := k.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(keyId),
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with kms key: %s: %w", keyId, err)
+	}
+
+	return out.Plaintext, nil
+}
+
+// LocalKeyProvider wraps data keys with AES-256-GCM master keys read from a JSON keyfile. It is meant for development
+// and tests. The keyfile looks like:
+//
+//	{"current": "dev-2", "keys": {"dev-1": "<base64 32 bytes>", "dev-2": "<base64 32 bytes>"}}
+type LocalKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+// MakeLocalKeyProvider loads master keys from the keyfile at path.
+func MakeLocalKeyProvider(path string) (*LocalKeyProvider, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+
+	var keyfile struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err = json.Unmarshal(raw, &keyfile); err != nil {
+		return nil, fmt.Errorf("failed to parse keyfile: %w", err)
+	}
+
+	provider := &LocalKeyProvider{current: keyfile.Current, keys: make(map[string][]byte)}
+	for id, encoded := range keyfile.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key: %s must be 32 base64 encoded bytes", id)
+		}
+		provider.keys[id] = key
+	}
+
+	if _, ok := provider.keys[provider.current]; !ok {
+		return nil, fmt.Errorf("current key: %s is not in the keyfile", provider.current)
+	}
+
+	return provider, nil
+}
+
+func (l *LocalKeyProvider) CurrentKeyID() string {
+	return l.current
+}
+
+func (l *LocalKeyProvider) Wrap(ctx context.Context, keyId string, dataKey []byte) ([]byte, error) {
+	key, ok := l.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyId)
+	}
+	return seal(key, dataKey, []byte(keyId))
+}
+
+func (l *LocalKeyProvider) Unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	key, ok := l.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyId)
+	}
+	return open(key, wrapped, []byte(keyId))
+}
+
+// seal encrypts plaintext with AES-256-GCM and prepends the random nonce.
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open reverses seal.
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}